@@ -0,0 +1,88 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// AppendTarSum extends TarSum with entries added after those of the
+// archive. All TarSums created by this package implement it.
+type AppendTarSum interface {
+	TarSum
+	AppendEntry(h *tar.Header, data []byte) error
+}
+
+// AppendEntry adds an entry to be summed after the last entry of the
+// archive, as if it had been written to the end of the archive before its
+// end-of-archive marker. Entries are summed in the order they are appended
+// and they are included in the re-emitted output, but not in the input
+// passed through by ModePassthrough or in Options.ContentDigest. The data
+// must be the entry's complete contents as described by h. AppendEntry
+// returns ErrAppendFinished once Read has returned io.EOF.
+func (ts *tarSum) AppendEntry(h *tar.Header, data []byte) error {
+	if ts.finished {
+		return ErrAppendFinished
+	}
+	// Each entry is encoded on its own so that an entry which fails to
+	// encode does not affect the others.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	// Flush reports data shorter than the size in the header.
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	ts.appended = append(ts.appended, buf.Bytes()...)
+	return nil
+}
+
+// nextHeader advances to the next entry, continuing with the appended
+// entries once the archive ends.
+func (ts *tarSum) nextHeader() (*tar.Header, error) {
+	ts.headerOffset = ts.tarOffset()
+	hdr, err := ts.tarR.Next()
+	for err == io.EOF && len(ts.appended) > 0 {
+		if err := ts.endInput(); err != nil {
+			return nil, err
+		}
+		// The appended entries are read back from their encoding so that
+		// they are summed exactly as entries of the archive would be.
+		r := io.MultiReader(bytes.NewReader(ts.appended), bytes.NewReader(make([]byte, 2*volumeBlockSize)))
+		if ts.opts.StrictHeaders {
+			ts.tarR = tar.NewStrictReader(r)
+		} else {
+			ts.tarR = tar.NewReader(r)
+		}
+		ts.appended = nil
+		ts.tarInput, ts.headerOffset = nil, -1
+		hdr, err = ts.tarR.Next()
+	}
+	return hdr, err
+}
+
+// endInput is called when the archive read from the input ends, before any
+// appended entries are read.
+func (ts *tarSum) endInput() error {
+	if ts.inputEnded {
+		return nil
+	}
+	ts.inputEnded = true
+	if ts.opts.CaptureTrailingData {
+		return ts.captureTrailingData()
+	}
+	if !ts.opts.RejectTrailingData {
+		return nil
+	}
+	err := ts.checkTrailingData()
+	if err == ErrTrailingData {
+		err = ts.softError(err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestAppendEntry(t *testing.T) {
+	entries := []testEntry{regEntry("a", "one"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 20*1024)))}
+	extra := []testEntry{
+		{header: &tar.Header{Name: "meta/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}},
+		regEntry("meta/generated.json", `{"generated":true}`),
+	}
+	archive := buildTar(t, entries...)
+	augmented := buildTar(t, append(append([]testEntry(nil), entries...), extra...)...)
+
+	for _, v := range []Version{Version0, Version1} {
+		ts, err := NewTarSum(bytes.NewReader(archive), true, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ats := ts.(AppendTarSum)
+		if err := ats.AppendEntry(extra[0].header, extra[0].data); err != nil {
+			t.Fatal(err)
+		}
+		// Entries may also be appended part way through the archive.
+		if _, err := io.CopyN(ioutil.Discard, ts, 512); err != nil {
+			t.Fatal(err)
+		}
+		if err := ats.AppendEntry(extra[1].header, extra[1].data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			t.Fatal(err)
+		}
+		if actual, expected := ts.Sum(nil), sumArchive(t, augmented, v); actual != expected {
+			t.Errorf("Mismatched sum for %s\n\tActual: %s\n\tExpected: %s", v, actual, expected)
+		}
+		if len(ts.GetSums()) != 4 {
+			t.Errorf("expected 4 file sums for %s, got %d", v, len(ts.GetSums()))
+		}
+		if err := ats.AppendEntry(extra[1].header, extra[1].data); err != ErrAppendFinished {
+			t.Errorf("Mismatched error appending after EOF\n\tActual: %v\n\tExpected: %v", err, ErrAppendFinished)
+		}
+	}
+}
+
+func TestAppendEntryShortData(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"))
+	ts, err := NewTarSum(bytes.NewReader(archive), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ats := ts.(AppendTarSum)
+	short := regEntry("b", "two")
+	if err := ats.AppendEntry(short.header, short.data[:1]); err == nil {
+		t.Fatal("expected an error appending an entry with short data")
+	}
+	// The failed entry is not summed and does not affect later ones.
+	c := regEntry("c", "three")
+	if err := ats.AppendEntry(c.header, c.data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+	if actual, expected := ts.Sum(nil), sumArchive(t, buildTar(t, regEntry("a", "one"), c), Version1); actual != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", actual, expected)
+	}
+}
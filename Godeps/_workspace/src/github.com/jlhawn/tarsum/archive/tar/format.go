@@ -0,0 +1,103 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tar
+
+import "strings"
+
+// Format is a set of tar formats, describing which format a header was
+// read in. A header read from an archive has exactly one format set.
+type Format int
+
+const (
+	// FormatUnknown indicates that the format is unknown, as for headers
+	// not produced by a Reader.
+	FormatUnknown Format = 0
+
+	// FormatV7 is the original Unix V7 tar format, with no magic.
+	FormatV7 Format = 1 << iota
+	// FormatUSTAR is the POSIX.1-1988 ustar format.
+	FormatUSTAR
+	// FormatPAX is the ustar format extended by a PAX extended header.
+	FormatPAX
+	// FormatGNU is the GNU tar format, identified by its "ustar  " magic
+	// or by GNU long name and long link headers.
+	FormatGNU
+	// FormatSTAR is the Schily star format, a ustar variant with access
+	// and change times.
+	FormatSTAR
+)
+
+var formatNames = []struct {
+	f    Format
+	name string
+}{
+	{FormatV7, "V7"},
+	{FormatUSTAR, "USTAR"},
+	{FormatPAX, "PAX"},
+	{FormatGNU, "GNU"},
+	{FormatSTAR, "STAR"},
+}
+
+// Has reports whether f includes all of the formats in g.
+func (f Format) Has(g Format) bool { return f&g == g }
+
+func (f Format) String() string {
+	var names []string
+	for _, fn := range formatNames {
+		if f.Has(fn.f) {
+			names = append(names, fn.name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "<unknown>"
+	case 1:
+		return names[0]
+	}
+	return "(" + strings.Join(names, " | ") + ")"
+}
+
+// Features is a set of format extensions used by an archive, as recorded by
+// a Reader.
+type Features int
+
+const (
+	// FeatureGNULongName is set by a GNU long name or long link header.
+	FeatureGNULongName Features = 1 << iota
+	// FeaturePAX is set by a PAX extended or global header.
+	FeaturePAX
+	// FeatureSparse is set by a GNU sparse file, in the old GNU format or
+	// described by PAX records.
+	FeatureSparse
+	// FeatureBase256 is set by a numeric field in the GNU base-256
+	// encoding, as used for values too large for octal.
+	FeatureBase256
+)
+
+var featureNames = []struct {
+	f    Features
+	name string
+}{
+	{FeatureGNULongName, "GNULongName"},
+	{FeaturePAX, "PAX"},
+	{FeatureSparse, "Sparse"},
+	{FeatureBase256, "Base256"},
+}
+
+// Has reports whether f includes all of the features in g.
+func (f Features) Has(g Features) bool { return f&g == g }
+
+func (f Features) String() string {
+	var names []string
+	for _, fn := range featureNames {
+		if f.Has(fn.f) {
+			names = append(names, fn.name)
+		}
+	}
+	if len(names) == 0 {
+		return "<none>"
+	}
+	return strings.Join(names, " | ")
+}
@@ -21,7 +21,9 @@ import (
 )
 
 var (
-	ErrHeader = errors.New("archive/tar: invalid tar header")
+	ErrHeader    = errors.New("archive/tar: invalid tar header")
+	ErrChecksum  = errors.New("archive/tar: invalid tar header checksum")
+	ErrBlockSize = errors.New("archive/tar: archive appears to use blocks larger than 512 bytes")
 )
 
 const maxNanoSecondIntSize = 9
@@ -36,6 +38,8 @@ type Reader struct {
 	pad     int64           // amount of padding (ignored) after current file entry
 	curr    numBytesReader  // reader for current file entry
 	hdrBuff [blockSize]byte // buffer to use in readHeader
+	strict  bool            // only accept unsigned header checksums
+	feature Features        // the features of the headers read so far
 }
 
 // Reset sets internal fields of this reader to their zero values
@@ -275,6 +279,15 @@ const (
 // NewReader creates a new Reader reading from r.
 func NewReader(r io.Reader) *Reader { return &Reader{r: r} }
 
+// NewStrictReader creates a Reader reading from r which only accepts header
+// checksums computed over unsigned bytes, as POSIX specifies, rather than
+// also accepting the signed checksums written by some historic tars. Headers
+// with an invalid checksum are reported as ErrChecksum rather than ErrHeader.
+func NewStrictReader(r io.Reader) *Reader { return &Reader{r: r, strict: true} }
+
+// Features returns the format extensions used by the headers read so far.
+func (tr *Reader) Features() Features { return tr.feature }
+
 // Next advances to the next entry in the tar archive.
 func (tr *Reader) Next() (*Header, error) {
 	var hdr *Header
@@ -292,6 +305,7 @@ func (tr *Reader) Next() (*Header, error) {
 	switch hdr.Typeflag {
 	case TypeXHeader:
 		//  PAX extended header
+		tr.feature |= FeaturePAX
 		headers, err := parsePAX(tr)
 		if err != nil {
 			return nil, err
@@ -303,7 +317,21 @@ func (tr *Reader) Next() (*Header, error) {
 		if hdr == nil {
 			return hdr, tr.err
 		}
-		mergePAX(hdr, headers)
+		if err := mergePAX(hdr, headers); err != nil {
+			tr.err = err
+			return nil, err
+		}
+		hdr.Format = FormatPAX
+		if _, ok := headers[paxSize]; ok {
+			// The PAX size overrides the one in the header block, which
+			// readHeader used to set up the reader for the entry data.
+			if hdr.Size < 0 {
+				tr.err = ErrHeader
+				return nil, tr.err
+			}
+			tr.pad = -hdr.Size & (blockSize - 1)
+			tr.curr = &regFileReader{r: tr.r, nb: hdr.Size}
+		}
 
 		// Check for a PAX format sparse file
 		sp, err := tr.checkForGNUSparsePAXHeaders(hdr, headers)
@@ -312,28 +340,39 @@ func (tr *Reader) Next() (*Header, error) {
 			return nil, err
 		}
 		if sp != nil {
+			tr.feature |= FeatureSparse
 			// Current file is a PAX format GNU sparse file.
 			// Set the current file reader to a sparse file reader.
 			tr.curr = &sparseFileReader{rfr: tr.curr.(*regFileReader), sp: sp, tot: hdr.Size}
 		}
 		return hdr, nil
+	case TypeXGlobalHeader:
+		tr.feature |= FeaturePAX
 	case TypeGNULongName:
 		// We have a GNU long name header. Its contents are the real file name.
+		tr.feature |= FeatureGNULongName
 		realname, err := ioutil.ReadAll(tr)
 		if err != nil {
 			return nil, err
 		}
 		hdr, err := tr.Next()
-		hdr.Name = cString(realname)
+		if hdr != nil {
+			hdr.Name = cString(realname)
+			hdr.Format = FormatGNU
+		}
 		return hdr, err
 	case TypeGNULongLink:
 		// We have a GNU long link header.
+		tr.feature |= FeatureGNULongName
 		realname, err := ioutil.ReadAll(tr)
 		if err != nil {
 			return nil, err
 		}
 		hdr, err := tr.Next()
-		hdr.Linkname = cString(realname)
+		if hdr != nil {
+			hdr.Linkname = cString(realname)
+			hdr.Format = FormatGNU
+		}
 		return hdr, err
 	}
 	return hdr, tr.err
@@ -375,13 +414,13 @@ func (tr *Reader) checkForGNUSparsePAXHeaders(hdr *Header, headers map[string]st
 		hdr.Name = sparseName
 	}
 	if sparseSizeOk {
-		realSize, err := strconv.ParseInt(sparseSize, 10, 0)
+		realSize, err := strconv.ParseInt(sparseSize, 10, 64)
 		if err != nil {
 			return nil, ErrHeader
 		}
 		hdr.Size = realSize
 	} else if sparseRealSizeOk {
-		realSize, err := strconv.ParseInt(sparseRealSize, 10, 0)
+		realSize, err := strconv.ParseInt(sparseRealSize, 10, 64)
 		if err != nil {
 			return nil, ErrHeader
 		}
@@ -417,16 +456,22 @@ func mergePAX(hdr *Header, headers map[string]string) error {
 		case paxUname:
 			hdr.Uname = v
 		case paxUid:
-			uid, err := strconv.ParseInt(v, 10, 0)
+			uid, err := strconv.ParseInt(v, 10, 64)
 			if err != nil {
 				return err
 			}
+			if int64(int(uid)) != uid {
+				return ErrHeader
+			}
 			hdr.Uid = int(uid)
 		case paxGid:
-			gid, err := strconv.ParseInt(v, 10, 0)
+			gid, err := strconv.ParseInt(v, 10, 64)
 			if err != nil {
 				return err
 			}
+			if int64(int(gid)) != gid {
+				return ErrHeader
+			}
 			hdr.Gid = int(gid)
 		case paxAtime:
 			t, err := parsePAXTime(v)
@@ -447,11 +492,11 @@ func mergePAX(hdr *Header, headers map[string]string) error {
 			}
 			hdr.ChangeTime = t
 		case paxSize:
-			size, err := strconv.ParseInt(v, 10, 0)
+			size, err := strconv.ParseInt(v, 10, 64)
 			if err != nil {
 				return err
 			}
-			hdr.Size = int64(size)
+			hdr.Size = size
 		default:
 			if strings.HasPrefix(k, paxXattr) {
 				if hdr.Xattrs == nil {
@@ -472,12 +517,12 @@ func parsePAXTime(t string) (time.Time, error) {
 	var seconds, nanoseconds int64
 	var err error
 	if pos == -1 {
-		seconds, err = strconv.ParseInt(t, 10, 0)
+		seconds, err = strconv.ParseInt(t, 10, 64)
 		if err != nil {
 			return time.Time{}, err
 		}
 	} else {
-		seconds, err = strconv.ParseInt(string(buf[:pos]), 10, 0)
+		seconds, err = strconv.ParseInt(string(buf[:pos]), 10, 64)
 		if err != nil {
 			return time.Time{}, err
 		}
@@ -500,6 +545,12 @@ func parsePAXTime(t string) (time.Time, error) {
 	return ts, nil
 }
 
+// ParsePAXRecords parses the records of a PAX extended header, such as the
+// data of a TypeXGlobalHeader entry, into a map from keyword to value.
+func ParsePAXRecords(b []byte) (map[string]string, error) {
+	return parsePAX(bytes.NewReader(b))
+}
+
 // parsePAX parses PAX headers.
 // If an extended header (type 'x') is invalid, ErrHeader is returned
 func parsePAX(r io.Reader) (map[string]string, error) {
@@ -525,7 +576,7 @@ func parsePAX(r io.Reader) (map[string]string, error) {
 		}
 		// Parse the first token as a decimal integer.
 		n, err := strconv.ParseInt(string(buf[:sp]), 10, 0)
-		if err != nil {
+		if err != nil || n < int64(sp)+2 || n > int64(len(buf)) || buf[n-1] != '\n' {
 			return nil, ErrHeader
 		}
 		// Extract everything between the decimal and the n -1 on the
@@ -569,16 +620,36 @@ func cString(b []byte) string {
 }
 
 func (tr *Reader) octal(b []byte) int64 {
-	// Check for binary format first.
+	// Check for binary format first. This is the GNU base-256 encoding: a
+	// big-endian two's complement number following the marker bit, so
+	// that a set second bit makes it negative.
 	if len(b) > 0 && b[0]&0x80 != 0 {
-		var x int64
+		tr.feature |= FeatureBase256
+		// Negative numbers are inverted while decoding, as -x-1 == ^x.
+		var inv byte
+		if b[0]&0x40 != 0 {
+			inv = 0xff
+		}
+		var x uint64
 		for i, c := range b {
+			c ^= inv
 			if i == 0 {
 				c &= 0x7f // ignore signal bit in first byte
 			}
-			x = x<<8 | int64(c)
+			if x>>56 != 0 {
+				tr.err = ErrHeader // overflow
+				return 0
+			}
+			x = x<<8 | uint64(c)
+		}
+		if x>>63 != 0 {
+			tr.err = ErrHeader // overflow
+			return 0
 		}
-		return x
+		if inv == 0xff {
+			return ^int64(x)
+		}
+		return int64(x)
 	}
 
 	// Because unused fields are filled with NULs, we need
@@ -598,6 +669,16 @@ func (tr *Reader) octal(b []byte) int64 {
 	return int64(x)
 }
 
+// intField parses a numeric field which is held in an int, setting tr.err
+// if its value does not fit, as for base-256 numbers on 32-bit platforms.
+func (tr *Reader) intField(b []byte) int {
+	x := tr.octal(b)
+	if int64(int(x)) != x {
+		tr.err = ErrHeader
+	}
+	return int(x)
+}
+
 // skipUnread skips any unread bytes in the existing file entry, as well as any alignment padding.
 func (tr *Reader) skipUnread() {
 	nr := tr.numBytes() + tr.pad // number of bytes to skip
@@ -617,6 +698,9 @@ func (tr *Reader) verifyChecksum(header []byte) bool {
 
 	given := tr.octal(header[148:156])
 	unsigned, signed := checksum(header)
+	if tr.strict {
+		return tr.err == nil && given == unsigned
+	}
 	return given == unsigned || given == signed
 }
 
@@ -633,9 +717,15 @@ func (tr *Reader) readHeader() *Header {
 		if _, tr.err = io.ReadFull(tr.r, header); tr.err != nil {
 			return nil
 		}
-		if bytes.Equal(header, zeroBlock[0:blockSize]) {
+		switch {
+		case bytes.Equal(header, zeroBlock[0:blockSize]):
 			tr.err = io.EOF
-		} else {
+		case tr.verifyChecksum(header):
+			// A header following a single zero block is most likely
+			// the next entry of an archive whose entries are padded
+			// to a larger block size.
+			tr.err = ErrBlockSize
+		default:
 			tr.err = ErrHeader // zero block and then non-zero block
 		}
 		return nil
@@ -643,6 +733,9 @@ func (tr *Reader) readHeader() *Header {
 
 	if !tr.verifyChecksum(header) {
 		tr.err = ErrHeader
+		if tr.strict {
+			tr.err = ErrChecksum
+		}
 		return nil
 	}
 
@@ -652,8 +745,8 @@ func (tr *Reader) readHeader() *Header {
 
 	hdr.Name = cString(s.next(100))
 	hdr.Mode = tr.octal(s.next(8))
-	hdr.Uid = int(tr.octal(s.next(8)))
-	hdr.Gid = int(tr.octal(s.next(8)))
+	hdr.Uid = tr.intField(s.next(8))
+	hdr.Gid = tr.intField(s.next(8))
 	hdr.Size = tr.octal(s.next(12))
 	hdr.ModTime = time.Unix(tr.octal(s.next(12)), 0)
 	s.next(8) // chksum
@@ -675,6 +768,16 @@ func (tr *Reader) readHeader() *Header {
 	case magic == "ustar  \x00": // old GNU tar
 		format = "gnu"
 	}
+	switch format {
+	case "posix":
+		hdr.Format = FormatUSTAR
+	case "gnu":
+		hdr.Format = FormatGNU
+	case "star":
+		hdr.Format = FormatSTAR
+	default:
+		hdr.Format = FormatV7
+	}
 
 	switch format {
 	case "posix", "gnu", "star":
@@ -705,8 +808,12 @@ func (tr *Reader) readHeader() *Header {
 		return nil
 	}
 
-	// Maximum value of hdr.Size is 64 GB (12 octal digits),
-	// so there's no risk of int64 overflowing.
+	// A base-256 encoded size may exceed the 8 GB of 11 octal digits, up
+	// to the range of int64, but it may not be negative.
+	if hdr.Size < 0 {
+		tr.err = ErrHeader
+		return nil
+	}
 	nb := int64(hdr.Size)
 	tr.pad = -nb & (blockSize - 1) // blockSize is a power of two
 
@@ -715,8 +822,13 @@ func (tr *Reader) readHeader() *Header {
 
 	// Check for old GNU sparse format entry.
 	if hdr.Typeflag == TypeGNUSparse {
+		tr.feature |= FeatureSparse
 		// Get the real size of the file.
 		hdr.Size = tr.octal(header[483:495])
+		if tr.err != nil || hdr.Size < 0 {
+			tr.err = ErrHeader
+			return nil
+		}
 
 		// Read the sparse map.
 		sp := tr.readOldGNUSparseMap(header)
@@ -14,6 +14,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -41,6 +42,11 @@ type Writer struct {
 	preferPax  bool            // use pax header instead of binary numeric header
 	hdrBuff    [blockSize]byte // buffer to use in writeHeader when writing a regular header
 	paxHdrBuff [blockSize]byte // buffer to use in writeHeader when writing a pax header
+
+	// Deterministic, when true, makes the extended headers written
+	// depend only on the headers given: they are named with a fixed
+	// value in place of the current pid and their records are sorted.
+	Deterministic bool
 }
 
 // NewWriter creates a new Writer writing to w.
@@ -306,6 +312,9 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHeaders map[string]string) erro
 	// The spec asks that we namespace our pseudo files
 	// with the current pid.
 	pid := os.Getpid()
+	if tw.Deterministic {
+		pid = 0
+	}
 	dir, file := path.Split(hdr.Name)
 	fullName := path.Join(dir,
 		fmt.Sprintf("PaxHeaders.%d", pid), file)
@@ -318,8 +327,15 @@ func (tw *Writer) writePAXHeader(hdr *Header, paxHeaders map[string]string) erro
 	// Construct the body
 	var buf bytes.Buffer
 
-	for k, v := range paxHeaders {
-		fmt.Fprint(&buf, paxHeader(k+"="+v))
+	keys := make([]string, 0, len(paxHeaders))
+	for k := range paxHeaders {
+		keys = append(keys, k)
+	}
+	if tw.Deterministic {
+		sort.Strings(keys)
+	}
+	for _, k := range keys {
+		fmt.Fprint(&buf, paxHeader(k+"="+paxHeaders[k]))
 	}
 
 	ext.Size = int64(len(buf.Bytes()))
@@ -0,0 +1,124 @@
+package tarsum
+
+import (
+	"encoding/hex"
+	"hash"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// ChunkTarSum extends TarSum with the sums of fixed-size chunks of large
+// files. TarSums created with a positive Options.ChunkSize implement it.
+type ChunkTarSum interface {
+	TarSum
+	// GetChunkSums returns the hex sums of the chunks of the body of the
+	// file with the given name, in order, or nil if the file was not
+	// larger than the chunk size. If several entries have the same name
+	// the chunks of the last are returned.
+	GetChunkSums(name string) []string
+	// GetChunkedSum returns the chunked sum of the file with the given
+	// name, as ChunkedFileSum derives it from the file's header and chunk
+	// sums, or "" if the file was not larger than the chunk size.
+	GetChunkedSum(name string) string
+}
+
+func (ts *tarSum) GetChunkSums(name string) []string {
+	return ts.chunkSums[name].sums
+}
+
+func (ts *tarSum) GetChunkedSum(name string) string {
+	return ts.chunkSums[name].sum
+}
+
+// chunkedFile holds the chunk sums of a file and the chunked sum derived
+// from them.
+type chunkedFile struct {
+	sums []string
+	sum  string
+}
+
+// CombineChunkSums derives a single sum for a file from the hex sums of its
+// chunks, as returned by GetChunkSums, by hashing their concatenation in
+// order with th. It is not the file's per-file sum, which also covers its
+// headers, but it identifies the body from its chunk sums alone.
+func CombineChunkSums(th THash, chunks []string) string {
+	h := th.Hash()
+	for _, chunk := range chunks {
+		h.Write([]byte(chunk))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ChunkedFileSum derives the chunked sum of a file, which stands in for its
+// per-file sum when only the sums of its chunks are known, such as to check
+// a file rebuilt from chunks. It is the hex hash, with th, of the headers
+// of hdr encoded as version v hashes them for the per-file sum, followed by
+// the hex chunk sums in order. The per-file sum itself hashes the headers
+// followed by the body, and cannot be recovered from the chunk sums.
+//
+// The chunked sum reported by GetChunkedSum is derived from the headers as
+// the TarSum hashed them, which differ with Options.TextNormalize and
+// Options.LegacyCompat, and is hashed with the TarSum's hash even with
+// Options.HashSelector. It is non-standard.
+func ChunkedFileSum(v Version, th THash, hdr *tar.Header, chunks []string) (string, error) {
+	selector, err := getTarHeaderSelector(v)
+	if err != nil {
+		return "", err
+	}
+	h := th.Hash()
+	if err := writeEntryHeaders(h, v, selector.selectHeaders(hdr), hdr.Size); err != nil {
+		return "", err
+	}
+	for _, chunk := range chunks {
+		h.Write([]byte(chunk))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// chunker hashes data in consecutive chunks of a fixed size.
+type chunker struct {
+	h    hash.Hash
+	size int64
+	n    int64     // bytes hashed in the current chunk
+	sum  hash.Hash // hashes the file's headers, then the chunk sums
+	sums []string
+}
+
+func newChunker(th THash, size int64) *chunker {
+	return &chunker{h: th.Hash(), size: size, sum: th.Hash()}
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		part := p
+		if remaining := c.size - c.n; int64(len(part)) > remaining {
+			part = part[:remaining]
+		}
+		c.h.Write(part)
+		c.n += int64(len(part))
+		p = p[len(part):]
+		if c.n == c.size {
+			c.next()
+		}
+	}
+	return written, nil
+}
+
+func (c *chunker) next() {
+	c.sums = append(c.sums, hex.EncodeToString(c.h.Sum(nil)))
+	c.h.Reset()
+	c.n = 0
+}
+
+// finish returns the chunk sums, including any partial final chunk, and
+// the chunked sum derived from them.
+func (c *chunker) finish() chunkedFile {
+	if c.n > 0 {
+		c.next()
+	}
+	for _, chunk := range c.sums {
+		c.sum.Write([]byte(chunk))
+	}
+	return chunkedFile{sums: c.sums, sum: hex.EncodeToString(c.sum.Sum(nil))}
+}
@@ -0,0 +1,78 @@
+package tarsum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestChunkSums(t *testing.T) {
+	body := strings.Repeat("0123456789", 2500)
+	archive := buildTar(t, regEntry("large", body), regEntry("small", "tiny"))
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{ChunkSize: 10000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, 4096)
+	cts := ts.(ChunkTarSum)
+
+	var expected []string
+	for i := 0; i < len(body); i += 10000 {
+		end := i + 10000
+		if end > len(body) {
+			end = len(body)
+		}
+		sum := sha256.Sum256([]byte(body[i:end]))
+		expected = append(expected, hex.EncodeToString(sum[:]))
+	}
+	chunks := cts.GetChunkSums("large")
+	if strings.Join(chunks, ",") != strings.Join(expected, ",") {
+		t.Errorf("Mismatched chunk sums\n\tActual: %v\n\tExpected: %v", chunks, expected)
+	}
+	if chunks := cts.GetChunkSums("small"); chunks != nil {
+		t.Errorf("expected no chunks for a small file, got %v", chunks)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(strings.Join(expected, "")))
+	if combined := CombineChunkSums(DefaultTHash, chunks); combined != hex.EncodeToString(h.Sum(nil)) {
+		t.Errorf("Mismatched combined sum %s", combined)
+	}
+
+	// The chunked sum is derived from the header and the chunk sums, and
+	// depends on both.
+	large := regEntry("large", body).header
+	for _, v := range []Version{Version0, Version1} {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), v, Options{ChunkSize: 10000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, 4096)
+		derived, err := ChunkedFileSum(v, DefaultTHash, large, expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunked := ts.(ChunkTarSum).GetChunkedSum("large"); chunked != derived {
+			t.Errorf("%s: Mismatched chunked sum\n\tActual: %s\n\tExpected: %s", v, chunked, derived)
+		}
+		if chunked := ts.(ChunkTarSum).GetChunkedSum("small"); chunked != "" {
+			t.Errorf("%s: expected no chunked sum for a small file, got %s", v, chunked)
+		}
+	}
+	derived, _ := ChunkedFileSum(Version1, DefaultTHash, large, expected)
+	renamed := *large
+	renamed.Name = "renamed"
+	if other, _ := ChunkedFileSum(Version1, DefaultTHash, &renamed, expected); other == derived {
+		t.Error("expected the header to change the chunked sum")
+	}
+	if other, _ := ChunkedFileSum(Version1, DefaultTHash, large, expected[:2]); other == derived {
+		t.Error("expected the chunk sums to change the chunked sum")
+	}
+
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
@@ -0,0 +1,55 @@
+package tarsum
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ZstdMagic is the magic number which begins every zstd frame, for a
+// Decompressor of zstd compressed layers.
+var ZstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Decompressor decompresses input which begins with Magic, such as a format
+// the standard library does not implement, for Options.Decompressors.
+type Decompressor struct {
+	Magic     []byte
+	NewReader func(r io.Reader) (io.Reader, error)
+}
+
+// decompressReader returns a reader which decompresses r if it begins with
+// the magic number of one of decompressors or a gzip header, or reads r
+// unmodified otherwise.
+func decompressReader(r io.Reader, decompressors []Decompressor) (io.Reader, error) {
+	peek := len(gzipMagic)
+	for _, d := range decompressors {
+		if len(d.Magic) > peek {
+			peek = len(d.Magic)
+		}
+	}
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(peek)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	for _, d := range decompressors {
+		if len(d.Magic) > 0 && bytes.HasPrefix(magic, d.Magic) {
+			return d.NewReader(br)
+		}
+	}
+	if !bytes.HasPrefix(magic, gzipMagic) {
+		return br, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, err
+	}
+	// Multistream mode is the default, but be explicit since layers may
+	// be made up of several concatenated gzip members.
+	gz.Multistream(true)
+	return gz, nil
+}
@@ -0,0 +1,45 @@
+package tarsum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func gzipBytes(t testing.TB, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAutoDecompress(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "hello"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 4096))))
+	expected := sumArchive(t, archive, Version1)
+
+	half := len(archive) / 2
+	inputs := map[string][]byte{
+		"uncompressed":  archive,
+		"single member": gzipBytes(t, archive),
+		"two members":   append(gzipBytes(t, archive[:half]), gzipBytes(t, archive[half:])...),
+	}
+	for name, input := range inputs {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(input), Version1, Options{AutoDecompress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if sum := ts.Sum(nil); sum != expected {
+			t.Errorf("Mismatched sum for %s input\n\tActual: %s\n\tExpected: %s", name, sum, expected)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package tarsum
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+)
+
+// DeltaSum drains the archive read from r and returns a checksum over only
+// the files whose per-file sum differs from the one in base, which maps file
+// names to per-file sums as reported by GetSums, along with the names of
+// those files in archive order. Files not present in base count as changed;
+// files in base which are absent from the archive are not reported.
+//
+// The delta sum is aggregated as Sum does, over the changed files only, and
+// is labelled with the version and hash. It is only meaningful relative to
+// the base it was computed against. Global PAX records, which
+// VersionGlobalPAX aggregates apart from the files, are not part of it, as
+// base holds per-file sums only.
+func DeltaSum(r io.Reader, base map[string]string, v Version) (deltaSum string, changed []string, err error) {
+	ts, err := newTarSumOptions(r, v, Options{Mode: ModeDigestOnly})
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return "", nil, err
+	}
+
+	var delta FileInfoSums
+	for _, fis := range ts.GetSums() {
+		if want, ok := base[fis.Name()]; !ok || want != fis.Sum() {
+			delta = append(delta, fis)
+			changed = append(changed, fis.Name())
+		}
+	}
+
+	delta.sortSums(v)
+	h := ts.th.Hash()
+	for _, fis := range delta {
+		writeAggregateSum(h, v, fis.Sum())
+	}
+	return v.String() + "+" + ts.th.Name() + ":" + hex.EncodeToString(h.Sum(nil)), changed, nil
+}
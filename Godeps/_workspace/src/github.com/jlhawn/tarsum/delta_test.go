@@ -0,0 +1,47 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDeltaSum(t *testing.T) {
+	var baseEntries, newEntries []testEntry
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("file%d", i)
+		baseEntries = append(baseEntries, regEntry(name, "original"))
+		switch i {
+		case 3, 7:
+			newEntries = append(newEntries, regEntry(name, "modified"))
+		default:
+			newEntries = append(newEntries, regEntry(name, "original"))
+		}
+	}
+	newEntries = append(newEntries, regEntry("added", "new"))
+
+	base := fileSumMap(t, buildTar(t, baseEntries...), Version1)
+
+	deltaSum, changed, err := DeltaSum(bytes.NewReader(buildTar(t, newEntries...)), base, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"file3", "file7", "added"}; !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Mismatched changed files\n\tActual: %v\n\tExpected: %v", changed, expected)
+	}
+
+	// The delta sum is the sum of an archive holding only the changed files.
+	onlyChanged := buildTar(t, regEntry("file3", "modified"), regEntry("file7", "modified"), regEntry("added", "new"))
+	if expected := sumArchive(t, onlyChanged, Version1); deltaSum != expected {
+		t.Errorf("Mismatched delta sum\n\tActual: %s\n\tExpected: %s", deltaSum, expected)
+	}
+
+	if _, changed, err := DeltaSum(bytes.NewReader(buildTar(t, baseEntries...)), base, Version1); err != nil || len(changed) != 0 {
+		t.Errorf("expected no changes against the base itself, got %v, %v", changed, err)
+	}
+	if !strings.HasPrefix(deltaSum, "tarsum.v1+sha256:") {
+		t.Errorf("expected a labelled delta sum, got %s", deltaSum)
+	}
+}
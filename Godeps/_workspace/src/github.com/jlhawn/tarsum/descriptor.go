@@ -0,0 +1,64 @@
+package tarsum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// DefaultDescriptorMediaType is the generic media type given to the
+// descriptors returned by Descriptors.
+const DefaultDescriptorMediaType = "application/octet-stream"
+
+// Descriptor describes the body of a file in the form of an OCI content
+// descriptor, without depending on the OCI packages.
+type Descriptor struct {
+	// MediaType is DefaultDescriptorMediaType, to be replaced by the
+	// caller where the type of the content is known.
+	MediaType string
+	// Digest is the sha256 digest of the body, as "sha256:{hex}".
+	Digest string
+	// Size is the size of the body declared by the entry.
+	Size int64
+	// Name is the name of the file, with any leading "./" and trailing
+	// "/" removed. It is not part of an OCI descriptor, but may be
+	// recorded in an annotation such as "org.opencontainers.image.title".
+	Name string
+}
+
+// DescriptorTarSum extends TarSum with descriptors of the bodies of the
+// summed files. TarSums created with Options.Descriptors implement it.
+type DescriptorTarSum interface {
+	TarSum
+	// Descriptors returns a descriptor of the body of each regular file
+	// summed so far, in archive order.
+	Descriptors() []Descriptor
+}
+
+func (ts *tarSum) Descriptors() []Descriptor {
+	return ts.descriptors
+}
+
+// startDescriptor starts digesting the body of the current file if it is
+// described by Descriptors.
+func (ts *tarSum) startDescriptor(typeflag byte) {
+	if ts.opts.Descriptors && !ts.skip && (typeflag == tar.TypeReg || typeflag == tar.TypeRegA) {
+		ts.bodyDigest = sha256.New()
+	}
+}
+
+// finishDescriptor records the descriptor of the current file if its body
+// has been digested.
+func (ts *tarSum) finishDescriptor() {
+	if ts.bodyDigest == nil {
+		return
+	}
+	ts.descriptors = append(ts.descriptors, Descriptor{
+		MediaType: DefaultDescriptorMediaType,
+		Digest:    "sha256:" + hex.EncodeToString(ts.bodyDigest.Sum(nil)),
+		Size:      ts.currentSize,
+		Name:      ts.currentFile,
+	})
+	ts.bodyDigest = nil
+}
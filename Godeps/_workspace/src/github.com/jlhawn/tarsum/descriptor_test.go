@@ -0,0 +1,53 @@
+package tarsum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestDescriptors(t *testing.T) {
+	dir := regEntry("dir/", "")
+	dir.header.Typeflag = tar.TypeDir
+	link := regEntry("dir/link", "")
+	link.header.Typeflag = tar.TypeSymlink
+	link.header.Linkname = "file"
+	entries := []testEntry{dir, regEntry("dir/file", "contents"), link, regEntry("./empty", ""), regEntry("large", strings.Repeat("large", 20000))}
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(buildTar(t, entries...)), Version1, Options{Mode: ModeDigestOnly, Descriptors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, 1000)
+	descriptors := ts.(DescriptorTarSum).Descriptors()
+
+	var expected []Descriptor
+	for _, e := range entries {
+		if e.header.Typeflag != tar.TypeReg {
+			continue
+		}
+		digest := sha256.Sum256(e.data)
+		expected = append(expected, Descriptor{
+			MediaType: DefaultDescriptorMediaType,
+			Digest:    "sha256:" + hex.EncodeToString(digest[:]),
+			Size:      e.header.Size,
+			Name:      entryName(e.header.Name),
+		})
+	}
+	if len(descriptors) != len(expected) {
+		t.Fatalf("Mismatched descriptors\n\tActual: %v\n\tExpected: %v", descriptors, expected)
+	}
+	for i := range expected {
+		if descriptors[i] != expected[i] {
+			t.Errorf("Mismatched descriptor\n\tActual: %+v\n\tExpected: %+v", descriptors[i], expected[i])
+		}
+	}
+
+	if sum := ts.Sum(nil); sum != sumArchive(t, buildTar(t, entries...), Version1) {
+		t.Errorf("expected descriptors not to affect the checksum: %s", sum)
+	}
+}
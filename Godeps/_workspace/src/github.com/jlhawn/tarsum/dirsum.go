@@ -0,0 +1,80 @@
+package tarsum
+
+import (
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DirTarSum extends TarSum with sums of the contents of directories. All
+// TarSums created by this package implement it.
+type DirTarSum interface {
+	TarSum
+	DirSum(name string) string
+	TopLevelSums() map[string]string
+}
+
+// DirSum returns a sum of the immediate children of the named directory
+// among the files summed so far, or "" if it has none. The name is matched
+// as the per-file sums are named, with any leading "./" and trailing "/"
+// removed; "" or "." names the top level of the archive. The directory's own
+// entry is not included, and neither are the contents of subdirectories
+// beyond their entries.
+//
+// The per-file sums of the children are sorted as strings and aggregated in
+// that order as Sum aggregates every file, so the result does not depend on
+// the order of the entries in the archive. It is labelled like a checksum
+// but it is not comparable with one.
+func (ts *tarSum) DirSum(name string) string {
+	dir := entryName(name)
+	if dir == "" {
+		dir = "."
+	}
+
+	var children []string
+	for _, fis := range ts.sums {
+		// The top-level directory's own entry is named "".
+		if fis.Name() != "" && fis.Name() != dir && path.Dir(fis.Name()) == dir {
+			children = append(children, fis.Sum())
+		}
+	}
+	if len(children) == 0 {
+		return ""
+	}
+	return ts.aggregateSums(children)
+}
+
+// TopLevelSums returns a sum for each top-level name among the files summed
+// so far, keyed by the first element of the path.Clean form of their names,
+// of all of the files with that first element: a top-level file alone, or a
+// top-level directory's entry and all of its contents. The entry of the top
+// level itself is not included in any of them. Each sum is computed as by
+// DirSum, from the per-file sums sorted as strings.
+func (ts *tarSum) TopLevelSums() map[string]string {
+	groups := make(map[string][]string)
+	for _, fis := range ts.sums {
+		name := path.Clean(fis.Name())
+		if name == "." || name == "/" {
+			continue
+		}
+		top := strings.SplitN(strings.TrimPrefix(name, "/"), "/", 2)[0]
+		groups[top] = append(groups[top], fis.Sum())
+	}
+	sums := make(map[string]string, len(groups))
+	for top, group := range groups {
+		sums[top] = ts.aggregateSums(group)
+	}
+	return sums
+}
+
+// aggregateSums sorts the per-file sums and aggregates them in that order as
+// Sum aggregates every file.
+func (ts *tarSum) aggregateSums(sums []string) string {
+	sort.Strings(sums)
+	h := ts.th.Hash()
+	for _, sum := range sums {
+		writeAggregateSum(h, ts.tarSumVersion, sum)
+	}
+	return ts.Version().String() + "+" + ts.th.Name() + ":" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package tarsum
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestDirSum(t *testing.T) {
+	dir := func(name string) testEntry {
+		return testEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}
+	}
+	dirSums := func(entries ...testEntry) map[string]string {
+		ts, err := NewTarSum(bytes.NewReader(buildTar(t, entries...)), true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		dts := ts.(DirTarSum)
+		sums := make(map[string]string)
+		for _, name := range []string{"", "etc", "etc/", "./etc/", "etc/conf.d", "missing"} {
+			sums[name] = dts.DirSum(name)
+		}
+		return sums
+	}
+
+	ordered := dirSums(dir("./"), dir("etc/"), regEntry("etc/a", "1"), regEntry("etc/b", "2"), dir("etc/conf.d/"), regEntry("etc/conf.d/x", "3"), regEntry("top", "4"))
+	shuffled := dirSums(regEntry("top", "4"), dir("etc/"), dir("etc/conf.d/"), regEntry("etc/b", "2"), regEntry("etc/conf.d/x", "3"), dir("./"), regEntry("etc/a", "1"))
+	for name, sum := range ordered {
+		if shuffled[name] != sum {
+			t.Errorf("%q: Mismatched dir sum after shuffling\n\tActual: %s\n\tExpected: %s", name, shuffled[name], sum)
+		}
+	}
+
+	if ordered["etc"] == "" || ordered["etc"] != ordered["etc/"] || ordered["etc"] != ordered["./etc/"] {
+		t.Errorf("expected every spelling of etc to name the same directory: %v", ordered)
+	}
+	if ordered["missing"] != "" {
+		t.Errorf("expected no sum for a directory without children, got %s", ordered["missing"])
+	}
+	distinct := map[string]bool{ordered[""]: true, ordered["etc"]: true, ordered["etc/conf.d"]: true}
+	if len(distinct) != 3 {
+		t.Errorf("expected distinct sums for each directory: %v", ordered)
+	}
+
+	// Changing a file beneath a subdirectory only changes the sums of
+	// the directories containing it directly.
+	changed := dirSums(dir("./"), dir("etc/"), regEntry("etc/a", "1"), regEntry("etc/b", "2"), dir("etc/conf.d/"), regEntry("etc/conf.d/x", "changed"), regEntry("top", "4"))
+	if changed["etc/conf.d"] == ordered["etc/conf.d"] {
+		t.Error("expected the sum of etc/conf.d to change")
+	}
+	if changed["etc"] != ordered["etc"] || changed[""] != ordered[""] {
+		t.Error("expected the sums of etc and the top level to be unchanged")
+	}
+}
+
+func TestTopLevelSums(t *testing.T) {
+	dir := func(name string) testEntry {
+		return testEntry{header: &tar.Header{Name: name, Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}
+	}
+	topLevelSums := func(entries ...testEntry) map[string]string {
+		ts, err := NewTarSum(bytes.NewReader(buildTar(t, entries...)), true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		return ts.(DirTarSum).TopLevelSums()
+	}
+
+	ordered := topLevelSums(dir("./"), dir("etc/"), regEntry("etc/a", "1"), dir("etc/conf.d/"), regEntry("etc/conf.d/x", "2"), dir("usr/"), regEntry("usr/bin", "3"), regEntry("top", "4"))
+	shuffled := topLevelSums(regEntry("top", "4"), regEntry("usr/bin", "3"), regEntry("etc/conf.d/x", "2"), dir("etc/"), dir("usr/"), dir("etc/conf.d/"), regEntry("etc/a", "1"), dir("./"))
+	if len(ordered) != 3 || ordered["etc"] == "" || ordered["usr"] == "" || ordered["top"] == "" {
+		t.Fatalf("expected sums for etc, usr and top: %v", ordered)
+	}
+	for name, sum := range ordered {
+		if shuffled[name] != sum {
+			t.Errorf("%q: Mismatched top-level sum after shuffling\n\tActual: %s\n\tExpected: %s", name, shuffled[name], sum)
+		}
+	}
+
+	// Changing a file changes only the sum of its top-level directory,
+	// however deep it is.
+	changed := topLevelSums(dir("./"), dir("etc/"), regEntry("etc/a", "1"), dir("etc/conf.d/"), regEntry("etc/conf.d/x", "changed"), dir("usr/"), regEntry("usr/bin", "3"), regEntry("top", "4"))
+	if changed["etc"] == ordered["etc"] {
+		t.Error("expected the sum of etc to change")
+	}
+	if changed["usr"] != ordered["usr"] || changed["top"] != ordered["top"] {
+		t.Error("expected the sums of usr and top to be unchanged")
+	}
+}
@@ -0,0 +1,52 @@
+package tarsum
+
+import (
+	"context"
+	"io"
+)
+
+// drainProgressInterval is the number of bytes Drain reads between calls to
+// its progress function.
+const drainProgressInterval = 1024 * 1024
+
+// Drain reads ts until io.EOF, discarding the output, and returns the number
+// of bytes read from it. Afterwards the sums of ts are complete. The context
+// is checked before each Read, and its error is returned once it is done; a
+// Read which is blocked on the input is not interrupted. When progress is
+// non-nil it is called with the total number of bytes read so far after
+// every further megabyte, and once more when ts is drained. A source given to
+// NewTarSumReadCloser is closed whether or not ts is drained.
+//
+// Note that the bytes counted are those returned by Read, so in
+// ModeDigestOnly the count is always zero.
+func Drain(ctx context.Context, ts TarSum, progress func(int64)) (int64, error) {
+	var (
+		buf      = make([]byte, buf32K)
+		n        int64
+		reported int64
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			if ts, ok := ts.(*tarSum); ok {
+				// Read closes the source itself at io.EOF or an error.
+				ts.closeSource()
+			}
+			return n, err
+		}
+		nr, err := ts.Read(buf)
+		n += int64(nr)
+		if progress != nil && n-reported >= drainProgressInterval {
+			progress(n)
+			reported = n
+		}
+		if err == io.EOF {
+			if progress != nil {
+				progress(n)
+			}
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
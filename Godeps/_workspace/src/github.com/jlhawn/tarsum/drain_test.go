@@ -0,0 +1,48 @@
+package tarsum
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestDrain(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("big", string(bytes.Repeat([]byte("drain"), 1024*1024))))
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{DisableCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []int64
+	n, err := Drain(context.Background(), ts, func(total int64) { calls = append(calls, total) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n < int64(len(archive))-1024 {
+		t.Errorf("expected the whole re-encoded archive to be read, got %d bytes of %d", n, len(archive))
+	}
+	if len(calls) < 5 || calls[len(calls)-1] != n {
+		t.Errorf("expected periodic progress ending with the total, got %v", calls)
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i] < calls[i-1] {
+			t.Errorf("expected progress to be increasing, got %v", calls)
+		}
+	}
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ts, err = NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{DisableCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = Drain(ctx, ts, func(int64) { cancel() })
+	if err != context.Canceled {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, context.Canceled)
+	}
+	if n == 0 {
+		t.Error("expected the cancellation to be observed after progress was made")
+	}
+}
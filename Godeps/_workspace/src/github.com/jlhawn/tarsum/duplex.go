@@ -0,0 +1,72 @@
+package tarsum
+
+import (
+	"io"
+)
+
+// Duplex is a TarSum which is fed its input through Write rather than
+// reading it from an io.Reader, for use where input is pushed and output
+// pulled on the same object. Read returns the output selected by the
+// Options, as for any TarSum.
+//
+// Input and output are bridged by a synchronous pipe, so there is no
+// internal buffering of input: Write blocks until Read has consumed the
+// written bytes. Writes and reads must therefore be made from different
+// goroutines, or alternated such that Read is called while a Write is
+// pending.
+type Duplex struct {
+	TarSum
+	pw *io.PipeWriter
+}
+
+// NewDuplex creates a Duplex using the given version and options.
+func NewDuplex(v Version, opts Options) (*Duplex, error) {
+	pr, pw := io.Pipe()
+	var r io.Reader = pr
+	if opts.AutoDecompress {
+		// Detecting compression peeks at the input, which would block
+		// until the first Write, so defer it until the first Read.
+		opts.AutoDecompress = false
+		r = &lazyDecompressReader{r: pr, decompressors: opts.Decompressors}
+	}
+	ts, err := newTarSumOptions(r, v, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Duplex{TarSum: ts, pw: pw}, nil
+}
+
+// Write feeds p to the TarSum, blocking until it has been consumed.
+func (d *Duplex) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+// Close signals the end of the input. Read then returns the remaining
+// output followed by io.EOF, after which Sum and GetSums are complete.
+func (d *Duplex) Close() error {
+	return d.pw.Close()
+}
+
+// CloseWithError ends the input with an error, which is returned by Read
+// once the TarSum next needs input.
+func (d *Duplex) CloseWithError(err error) error {
+	return d.pw.CloseWithError(err)
+}
+
+// lazyDecompressReader applies decompressReader to r on the first Read.
+type lazyDecompressReader struct {
+	r             io.Reader
+	decompressors []Decompressor
+	dr            io.Reader
+	err           error
+}
+
+func (l *lazyDecompressReader) Read(p []byte) (int, error) {
+	if l.dr == nil && l.err == nil {
+		l.dr, l.err = decompressReader(l.r, l.decompressors)
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.dr.Read(p)
+}
@@ -0,0 +1,79 @@
+package tarsum
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDuplex(t *testing.T) {
+	archive := buildTar(t, regEntry("a", strings.Repeat("a", 10000)), regEntry("b", "bbb"))
+	expected := sumArchive(t, archive, Version1)
+
+	out, sum := duplexSum(t, archive, Options{DisableCompression: true})
+	if sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	// The output should be the same as that of a TarSum reading the
+	// archive directly.
+	ts, err := NewTarSum(bytes.NewReader(archive), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := ioutil.ReadAll(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, direct) {
+		t.Error("duplex output differs from the output of NewTarSum")
+	}
+
+	if _, sum := duplexSum(t, gzipBytes(t, archive), Options{AutoDecompress: true}); sum != expected {
+		t.Errorf("Mismatched sum for compressed input\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
+
+// duplexSum writes input to a Duplex in small chunks from another goroutine
+// while reading its output.
+func duplexSum(t *testing.T, input []byte, opts Options) ([]byte, string) {
+	d, err := NewDuplex(Version1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for rest := input; len(rest) > 0; {
+			n := 700
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := d.Write(rest[:n]); err != nil {
+				d.CloseWithError(err)
+				return
+			}
+			rest = rest[n:]
+		}
+		d.Close()
+	}()
+
+	out, err := ioutil.ReadAll(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out, d.Sum(nil)
+}
+
+func TestDuplexCloseWithError(t *testing.T) {
+	d, err := NewDuplex(Version1, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errStop := errors.New("stop")
+	go d.CloseWithError(errStop)
+
+	if _, err := ioutil.ReadAll(d); err != errStop {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, errStop)
+	}
+}
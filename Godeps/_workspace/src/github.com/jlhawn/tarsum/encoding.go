@@ -0,0 +1,76 @@
+package tarsum
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// DigestEncoding selects how per-file sums are rendered by GetSums,
+// GetSumsWhere and Manifest. It is a display encoding only: the checksum
+// returned by Sum is always computed from, and ordered by, the hex form of
+// each per-file sum, so it is the same for every encoding.
+type DigestEncoding int
+
+const (
+	// EncodingHex renders sums as lower case hex. It is the default.
+	EncodingHex DigestEncoding = iota
+	// EncodingBase64URL renders sums as unpadded URL-safe base64.
+	EncodingBase64URL
+	// EncodingBase32 renders sums as unpadded standard base32.
+	EncodingBase32
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (e DigestEncoding) String() string {
+	switch e {
+	case EncodingHex:
+		return "hex"
+	case EncodingBase64URL:
+		return "base64url"
+	case EncodingBase32:
+		return "base32"
+	}
+	return fmt.Sprintf("DigestEncoding(%d)", int(e))
+}
+
+// encode renders the raw digest b in the encoding.
+func (e DigestEncoding) encode(b []byte) string {
+	switch e {
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b)
+	case EncodingBase32:
+		return base32NoPadding.EncodeToString(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Decode returns the raw digest from a sum rendered in the encoding.
+func (e DigestEncoding) Decode(s string) ([]byte, error) {
+	switch e {
+	case EncodingHex:
+		return hex.DecodeString(s)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(s)
+	case EncodingBase32:
+		return base32NoPadding.DecodeString(s)
+	}
+	return nil, fmt.Errorf("tarsum: unknown digest encoding %v", e)
+}
+
+// encodeSums returns a copy of sums, which hold hex sums, with each digest
+// truncated to its first n bytes, unless n is 0, and rendered in the
+// encoding.
+func (e DigestEncoding) encodeSums(sums FileInfoSums, n int) FileInfoSums {
+	encoded := make(FileInfoSums, len(sums))
+	for i, fis := range sums {
+		b, _ := hex.DecodeString(fis.Sum())
+		if n > 0 && n < len(b) {
+			b = b[:n]
+		}
+		encoded[i] = fileInfoSum{name: fis.Name(), sum: e.encode(b), pos: fis.Pos(), typeflag: fileTypeflag(fis)}
+	}
+	return encoded
+}
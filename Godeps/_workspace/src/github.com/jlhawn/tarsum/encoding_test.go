@@ -0,0 +1,81 @@
+package tarsum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestDigestEncoding(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"), regEntry("c", "three"))
+	expected := sumArchive(t, archive, Version1)
+	hexSums, err := ComputeFileSums(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []DigestEncoding{EncodingHex, EncodingBase64URL, EncodingBase32} {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{DigestEncoding: encoding})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+
+		sums := ts.GetSums()
+		if len(sums) != len(hexSums) {
+			t.Fatalf("%v: expected %d sums, got %d", encoding, len(hexSums), len(sums))
+		}
+		for i, fis := range sums {
+			raw, err := encoding.Decode(fis.Sum())
+			if err != nil {
+				t.Fatalf("%v: %v", encoding, err)
+			}
+			if fis.Name() != hexSums[i].Name() || hex.EncodeToString(raw) != hexSums[i].Sum() {
+				t.Errorf("Mismatched %v sum for %s\n\tActual: %s\n\tExpected: %s", encoding, fis.Name(), hex.EncodeToString(raw), hexSums[i].Sum())
+			}
+		}
+
+		if sum := ts.Sum(nil); sum != expected {
+			t.Errorf("Mismatched %v checksum\n\tActual: %s\n\tExpected: %s", encoding, sum, expected)
+		}
+	}
+}
+
+func TestPerFileDigestBytes(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"), regEntry("c", "three"))
+	expected := sumArchive(t, archive, Version1)
+	hexSums, err := ComputeFileSums(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encoding := range []DigestEncoding{EncodingHex, EncodingBase64URL} {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{DigestEncoding: encoding, PerFileDigestBytes: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+
+		for i, fis := range ts.GetSums() {
+			raw, err := encoding.Decode(fis.Sum())
+			if err != nil {
+				t.Fatalf("%v: %v", encoding, err)
+			}
+			if len(raw) != 16 {
+				t.Errorf("Mismatched %v sum length for %s\n\tActual: %d\n\tExpected: %d", encoding, fis.Name(), len(raw), 16)
+			}
+			if actual, expected := hex.EncodeToString(raw), hexSums[i].Sum()[:32]; actual != expected {
+				t.Errorf("Mismatched %v sum for %s\n\tActual: %s\n\tExpected: %s", encoding, fis.Name(), actual, expected)
+			}
+		}
+		if sum := ts.Sum(nil); sum != expected {
+			t.Errorf("Mismatched %v checksum\n\tActual: %s\n\tExpected: %s", encoding, sum, expected)
+		}
+	}
+
+	for _, n := range []int{-1, 33} {
+		if _, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{PerFileDigestBytes: n}); err == nil {
+			t.Errorf("expected an error truncating sha256 sums to %d bytes", n)
+		}
+	}
+}
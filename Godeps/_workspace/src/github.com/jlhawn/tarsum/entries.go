@@ -0,0 +1,81 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// Entry is a single archive entry held in memory.
+type Entry struct {
+	Header *tar.Header
+	Data   []byte
+}
+
+// SumEntries computes the checksum of an archive made up of the given
+// entries without building the archive. The result is equal to the
+// checksum of the equivalent tar archive.
+//
+// Every header must have a Name. If Size is zero it defaults to the length
+// of Data, otherwise it must equal it. All other fields are interpreted as
+// the tar writer would encode them; for example, ModTime is truncated to
+// whole seconds and device numbers are only kept for device entries.
+func SumEntries(entries []Entry, v Version) (string, error) {
+	ts, err := newTarSum(nil, true, v)
+	if err != nil {
+		return "", err
+	}
+
+	for _, e := range entries {
+		hdr, err := encodedHeader(e.Header, int64(len(e.Data)))
+		if err != nil {
+			return "", err
+		}
+
+		ts.currentFile, ts.currentType = entryName(hdr.Name), hdr.Typeflag
+		ts.global = v == VersionGlobalPAX && hdr.Typeflag == tar.TypeXGlobalHeader
+		ts.skip = ts.global
+		if ts.global {
+			ts.captureGlobal(e.Data)
+			if err := ts.finishFile(); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if err := ts.encodeHeader(hdr); err != nil {
+			return "", err
+		}
+		if _, err := ts.h.Write(e.Data); err != nil {
+			return "", err
+		}
+		if err := ts.finishFile(); err != nil {
+			return "", err
+		}
+	}
+
+	return ts.Sum(nil), nil
+}
+
+// encodedHeader returns hdr as it would be read back after being written to
+// a tar archive describing an entry with size bytes of data.
+func encodedHeader(hdr *tar.Header, size int64) (*tar.Header, error) {
+	if hdr == nil || hdr.Name == "" {
+		return nil, fmt.Errorf("tarsum: entry header must have a name")
+	}
+
+	h := *hdr
+	if h.Size == 0 {
+		h.Size = size
+	} else if h.Size != size {
+		return nil, fmt.Errorf("tarsum: entry %q has size %d but %d bytes of data", h.Name, h.Size, size)
+	}
+
+	// Only the header blocks are written, so the entry's data is never
+	// copied.
+	buf := new(bytes.Buffer)
+	if err := tar.NewWriter(buf).WriteHeader(&h); err != nil {
+		return nil, err
+	}
+	return tar.NewReader(buf).Next()
+}
@@ -0,0 +1,55 @@
+package tarsum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestSumEntries(t *testing.T) {
+	long := "dir/a-very-long-file-name-which-does-not-fit-in-a-ustar-name-field-and-so-requires-an-extension-header.txt"
+	entries := []Entry{
+		{Header: &tar.Header{Name: "dir/", Mode: 0755, Typeflag: tar.TypeDir, ModTime: time.Unix(1400000000, 500)}},
+		{Header: &tar.Header{Name: "dir/file", Mode: 0644, Typeflag: tar.TypeReg, Uname: "user"}, Data: []byte("contents")},
+		{Header: &tar.Header{Name: long, Mode: 0644, Typeflag: tar.TypeReg, Xattrs: map[string]string{"user.key": "value"}}, Data: []byte("more")},
+	}
+
+	var physical []testEntry
+	for _, e := range entries {
+		h := *e.Header
+		h.Size = int64(len(e.Data))
+		physical = append(physical, testEntry{header: &h, data: e.Data})
+	}
+	archive := buildTar(t, physical...)
+
+	for _, v := range []Version{Version0, Version1} {
+		sum, err := SumEntries(entries, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := sumArchive(t, archive, v); sum != expected {
+			t.Errorf("Mismatched %s sum\n\tActual: %s\n\tExpected: %s", v, sum, expected)
+		}
+	}
+
+	// Global PAX records are aggregated as they are when read.
+	global := []Entry{
+		{Header: &tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader}, Data: []byte(paxRecord("comment", "layer"))},
+		entries[1],
+	}
+	for _, v := range []Version{Version1, VersionGlobalPAX} {
+		sum, err := SumEntries(global, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := sumArchive(t, buildTar(t, globalEntry(paxRecord("comment", "layer")), physical[1]), v); sum != expected {
+			t.Errorf("Mismatched %s sum with global records\n\tActual: %s\n\tExpected: %s", v, sum, expected)
+		}
+	}
+
+	bad := []Entry{{Header: &tar.Header{Name: "file", Size: 10}, Data: []byte("short")}}
+	if _, err := SumEntries(bad, Version1); err == nil {
+		t.Error("expected an error for a header size which does not match the data")
+	}
+}
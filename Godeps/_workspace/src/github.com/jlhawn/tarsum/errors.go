@@ -0,0 +1,25 @@
+package tarsum
+
+// ErrorTarSum extends TarSum with the recoverable errors collected while
+// reading. TarSums created with Options.CollectErrors implement it.
+type ErrorTarSum interface {
+	TarSum
+	// Errors returns the recoverable errors collected so far, in the
+	// order they occurred. The checksum is best-effort if it is not empty.
+	Errors() []error
+}
+
+func (ts *tarSum) Errors() []error {
+	return ts.errs
+}
+
+// softError records a recoverable error and returns nil when errors are
+// being collected, and otherwise returns err.
+func (ts *tarSum) softError(err error) error {
+	if err == nil || !ts.opts.CollectErrors {
+		return err
+	}
+	ts.logger.Debugf("collected error: %v", err)
+	ts.errs = append(ts.errs, err)
+	return nil
+}
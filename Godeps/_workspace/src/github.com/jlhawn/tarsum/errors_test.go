@@ -0,0 +1,63 @@
+package tarsum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCollectErrors(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+	expected := sumArchive(t, archive, Version1)
+	trailing := append(append([]byte(nil), archive...), "garbage"...)
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(trailing), Version1, Options{
+		CollectErrors:      true,
+		RejectTrailingData: true,
+		AuditWriter:        &faultyWriter{w: &bytes.Buffer{}, failAfter: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+
+	errs := ts.(ErrorTarSum).Errors()
+	if len(errs) != 3 || errs[2] != ErrTrailingData {
+		t.Errorf("expected two audit errors followed by ErrTrailingData, got %v", errs)
+	}
+	if sum := ts.Sum(nil); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	// Without CollectErrors the first error aborts.
+	ts, err = NewTarSumWithOptions(bytes.NewReader(trailing), Version1, Options{RejectTrailingData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(ts); err != ErrTrailingData {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, ErrTrailingData)
+	}
+}
+
+func TestCollectErrorsVerifierMismatch(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+
+	// A verifier's mismatch aborts Read even when errors are collected.
+	ts, err := newTarSumOptions(bytes.NewReader(archive), Version1, Options{CollectErrors: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatch := ErrFileSumMismatch{Name: "a", Got: "got", Want: "want"}
+	ts.fileDone = func(fis FileInfoSumInterface) error {
+		if fis.Name() == "a" {
+			return mismatch
+		}
+		return nil
+	}
+	if _, err := ioutil.ReadAll(ts); err != mismatch {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, mismatch)
+	}
+	if errs := ts.Errors(); len(errs) != 0 {
+		t.Errorf("expected the mismatch not to be collected, got %v", errs)
+	}
+}
@@ -0,0 +1,111 @@
+package tarsum
+
+import (
+	"io"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// Approximate sizes used by EstimateMemory.
+const (
+	// gzipWriterMemory is the allocation made by a compress/gzip writer at
+	// the default compression level, dominated by the flate window and
+	// hash chains.
+	gzipWriterMemory = 768 * 1024
+	// tarStateMemory covers the tar reader and writer, including a block
+	// for each and room for an extended header.
+	tarStateMemory = 4 * 1024
+	// hashStateMemory covers the state of a SHA-2 family hash.
+	hashStateMemory = 256
+)
+
+// EstimateMemory returns the approximate number of bytes a TarSum holds
+// while re-emitting an archive when Read is called with buffers of at most
+// maxReadBuf bytes, with or without gzip compression of the output.
+//
+// The estimate assumes the default THash and that the output is drained as
+// it is produced. It counts the scratch buffer, which is at least 8K, the
+// re-encoded tar bytes of one step, the output buffer, which can hold up to
+// two reads' worth of data, the compressor and the hash state. It does not
+// include per-file sums or retained headers, which grow with the number of
+// entries, and it is advisory only.
+func EstimateMemory(maxReadBuf int, compression bool) int {
+	if maxReadBuf < 0 {
+		maxReadBuf = 0
+	}
+
+	scratch := maxReadBuf
+	switch {
+	case maxReadBuf <= buf8K:
+		scratch = buf8K
+	case maxReadBuf <= buf16K:
+		scratch = buf16K
+	case maxReadBuf <= buf32K:
+		scratch = buf32K
+	}
+
+	total := scratch + maxReadBuf + 2*maxReadBuf + tarStateMemory + hashStateMemory
+	if compression {
+		total += gzipWriterMemory
+	}
+	return total
+}
+
+// VerifyEstimate describes the work of verifying an archive, as estimated
+// by EstimateVerify.
+type VerifyEstimate struct {
+	// Files is the number of entries which would be summed as files.
+	Files int64
+	// DataBytes is the total declared size of the bodies of the entries,
+	// the data which would be hashed.
+	DataBytes int64
+	// ArchiveBytes is the number of bytes of the archive, up to and
+	// including its end-of-archive marker, which would be read.
+	ArchiveBytes int64
+}
+
+// EstimateVerify scans the headers of the archive read from rs, seeking past
+// the body of each entry rather than reading it, to estimate the work of
+// summing it with version v, such as to decide whether to verify it now.
+// The scan starts at the current position of rs, which is restored once it
+// ends, whether or not it succeeds. The archive's checksum is not computed,
+// and the headers are not checked as strictly as by a full pass.
+func EstimateVerify(rs io.ReadSeeker, v Version) (est *VerifyEstimate, err error) {
+	if _, err := getTarHeaderSelector(v); err != nil {
+		return nil, err
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, serr := rs.Seek(start, io.SeekStart); serr != nil && err == nil {
+			est, err = nil, serr
+		}
+	}()
+
+	// The tar reader seeks past bodies when its input is seekable.
+	tr := tar.NewReader(rs)
+	est = &VerifyEstimate{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		est.DataBytes += hdr.Size
+		// VersionGlobalPAX aggregates global PAX records rather than
+		// summing their entries as files.
+		if v != VersionGlobalPAX || hdr.Typeflag != tar.TypeXGlobalHeader {
+			est.Files++
+		}
+	}
+	end, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	est.ArchiveBytes = end - start
+	return est, nil
+}
@@ -0,0 +1,70 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	small := EstimateMemory(512, false)
+	if small < buf8K {
+		t.Errorf("estimate %d does not include the minimum scratch buffer", small)
+	}
+	if large := EstimateMemory(1<<20, false); large <= small {
+		t.Errorf("estimate did not grow with the read buffer: %d <= %d", large, small)
+	}
+	if compressed := EstimateMemory(512, true); compressed-small != gzipWriterMemory {
+		t.Errorf("Mismatched compressor estimate\n\tActual: %d\n\tExpected: %d", compressed-small, gzipWriterMemory)
+	}
+}
+
+// readCountingSeeker counts the bytes read from a seekable reader.
+type readCountingSeeker struct {
+	io.ReadSeeker
+	n int64
+}
+
+func (r *readCountingSeeker) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestEstimateVerify(t *testing.T) {
+	entries := []testEntry{regEntry("a", "one"), regEntry("big", strings.Repeat("b", 1<<20)), regEntry(strings.Repeat("long/", 30)+"name", "pax")}
+	archive := buildTar(t, entries...)
+	// Padding after the end of the archive is not read by a full pass.
+	input := append(append([]byte("prefix"), archive...), make([]byte, 10240)...)
+
+	rs := &readCountingSeeker{ReadSeeker: bytes.NewReader(input)}
+	rs.Seek(int64(len("prefix")), io.SeekStart)
+	est, err := EstimateVerify(rs, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos, _ := rs.Seek(0, io.SeekCurrent); pos != int64(len("prefix")) {
+		t.Errorf("Mismatched position after the estimate\n\tActual: %d\n\tExpected: %d", pos, len("prefix"))
+	}
+	if rs.n >= 1<<20 {
+		t.Errorf("expected the bodies to be skipped: read %d bytes", rs.n)
+	}
+
+	result, err := DigestArchive(rs, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dataBytes int64
+	for _, e := range entries {
+		dataBytes += int64(len(e.data))
+	}
+	expected := VerifyEstimate{Files: int64(result.FileCount), DataBytes: dataBytes, ArchiveBytes: result.TotalBytes}
+	if *est != expected {
+		t.Errorf("Mismatched estimate\n\tActual: %+v\n\tExpected: %+v", *est, expected)
+	}
+
+	if _, err := EstimateVerify(bytes.NewReader([]byte(strings.Repeat("x", 1024))), Version1); err == nil {
+		t.Error("expected an error for input which is not an archive")
+	}
+}
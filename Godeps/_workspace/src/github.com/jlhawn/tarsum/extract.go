@@ -0,0 +1,203 @@
+package tarsum
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// ExtractOptions holds the optional settings for ExtractVerify.
+type ExtractOptions struct {
+	// KeepOnFailure, when true, leaves the extracted files in place if
+	// extraction or verification fails. By default every top-level path
+	// created under the destination is removed.
+	KeepOnFailure bool
+}
+
+// ExtractVerify extracts the archive read from r beneath the directory dst
+// while computing its checksum with the version and hash named in expected.
+// Once the archive has been read the checksum is compared to expected and an
+// ErrSumMismatch is returned if they differ.
+//
+// Directories, regular files, symlinks and hard links are extracted with
+// their permission bits; regular files also keep their modification time.
+// Ownership is not changed and device nodes and fifos are skipped. Entries
+// whose names or hard link targets would resolve outside of dst, directly or
+// through a symlink extracted earlier, are rejected with an error. Symlink
+// targets are not checked since they are never followed during extraction.
+func ExtractVerify(dst string, r io.Reader, expected string, opts ExtractOptions) (err error) {
+	ts, err := newTarSumForChecksum(r, expected)
+	if err != nil {
+		return err
+	}
+
+	x := &extractor{dst: dst, existing: make(map[string]bool)}
+	defer func() {
+		if err != nil && !opts.KeepOnFailure {
+			x.cleanup()
+		}
+	}()
+
+	// Extract from the re-emitted stream so that the checksum is computed
+	// as the entries are written.
+	tr := tar.NewReader(ts)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.extract(hdr, tr); err != nil {
+			return err
+		}
+	}
+	// Drain the remainder of the archive to complete the checksum.
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return err
+	}
+
+	if got := ts.Sum(nil); got != expected {
+		return ErrSumMismatch{Got: got, Want: expected}
+	}
+	return nil
+}
+
+type extractor struct {
+	dst      string
+	existing map[string]bool // whether each top-level name existed before extraction
+	created  []string        // top-level paths created by extraction
+}
+
+// target returns the path beneath x.dst for the archive name, making sure
+// that neither the name nor any existing parent directory leads outside of
+// x.dst.
+func (x *extractor) target(name string) (string, error) {
+	// Absolute names are extracted relative to x.dst, as tar does.
+	rel := path.Clean(strings.TrimLeft(name, "/"))
+	if rel == "." {
+		return x.dst, nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("tarsum: refusing to extract %q outside of the destination", name)
+	}
+
+	// Refuse to extract through a symlink.
+	parent := x.dst
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		fi, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("tarsum: refusing to extract %q through symlink %q", name, parent)
+		}
+	}
+
+	x.track(parts[0])
+	return filepath.Join(x.dst, filepath.FromSlash(rel)), nil
+}
+
+// track records whether the top-level path top existed before it was first
+// extracted to, so that cleanup only removes what extraction created.
+func (x *extractor) track(top string) {
+	if _, ok := x.existing[top]; ok {
+		return
+	}
+	_, err := os.Lstat(filepath.Join(x.dst, top))
+	x.existing[top] = err == nil
+	if err != nil {
+		x.created = append(x.created, filepath.Join(x.dst, top))
+	}
+}
+
+func (x *extractor) extract(hdr *tar.Header, r io.Reader) error {
+	target, err := x.target(hdr.Name)
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(hdr.Mode) & os.ModePerm
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+			// Replace a file or symlink rather than following it.
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		return os.Chmod(target, perm)
+
+	case tar.TypeReg, tar.TypeRegA:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		// Remove any existing entry, which may be a symlink, rather
+		// than writing through it.
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := os.Chmod(target, perm); err != nil {
+			return err
+		}
+		return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(hdr.Linkname, target)
+
+	case tar.TypeLink:
+		source, err := x.target(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Link(source, target)
+	}
+
+	// Device nodes, fifos and other entries are not extracted.
+	return nil
+}
+
+// cleanup removes the top-level paths created by extraction.
+func (x *extractor) cleanup() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		os.RemoveAll(x.created[i])
+	}
+}
@@ -0,0 +1,89 @@
+package tarsum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestExtractVerify(t *testing.T) {
+	mtime := time.Unix(1400000000, 0)
+	archive := buildTar(t,
+		testEntry{header: &tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: mtime}},
+		testEntry{header: &tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0755, Size: 6, ModTime: mtime}, data: []byte("binary")},
+		testEntry{header: &tar.Header{Name: "bin/link", Typeflag: tar.TypeSymlink, Linkname: "app", Mode: 0777, ModTime: mtime}},
+		testEntry{header: &tar.Header{Name: "bin/hard", Typeflag: tar.TypeLink, Linkname: "bin/app", ModTime: mtime}},
+		deviceEntry("dev/null", tar.TypeChar, 1, 3),
+	)
+	expected := sumArchive(t, archive, Version1)
+
+	dst, err := ioutil.TempDir("", "tarsum-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	if err := ExtractVerify(dst, bytes.NewReader(archive), expected, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dst, "bin/app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0755 || !fi.ModTime().Equal(mtime) {
+		t.Errorf("unexpected file metadata: %v, %v", fi.Mode(), fi.ModTime())
+	}
+	if fi, err := os.Stat(filepath.Join(dst, "bin")); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("unexpected directory metadata: %v, %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "bin/link")); err != nil || target != "app" {
+		t.Errorf("unexpected symlink target %q, %v", target, err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dst, "bin/hard")); err != nil || string(data) != "binary" {
+		t.Errorf("unexpected hard link content %q, %v", data, err)
+	}
+
+	// A mismatch removes what was extracted.
+	other, err := ioutil.TempDir("", "tarsum-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	wrong := sumArchive(t, buildTar(t, regEntry("a", "1")), Version1)
+	if err := ExtractVerify(other, bytes.NewReader(archive), wrong, ExtractOptions{}); err == nil {
+		t.Error("expected ErrSumMismatch")
+	} else if _, ok := err.(ErrSumMismatch); !ok {
+		t.Errorf("expected ErrSumMismatch, got %v", err)
+	}
+	if names, _ := ioutil.ReadDir(other); len(names) != 0 {
+		t.Errorf("expected extracted files to be removed, found %d", len(names))
+	}
+}
+
+func TestExtractVerifyUnsafePaths(t *testing.T) {
+	mtime := time.Unix(1400000000, 0)
+	testCases := map[string][]testEntry{
+		"parent":    {regEntry("../escape", "x")},
+		"nested":    {regEntry("a/../../escape", "x")},
+		"symlink":   {{header: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/tmp", ModTime: mtime}}, regEntry("link/escape", "x")},
+		"hard link": {{header: &tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../outside", ModTime: mtime}}},
+	}
+	for name, entries := range testCases {
+		archive := buildTar(t, entries...)
+		dst, err := ioutil.TempDir("", "tarsum-extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dst)
+
+		if err := ExtractVerify(dst, bytes.NewReader(archive), sumArchive(t, archive, Version1), ExtractOptions{}); err == nil {
+			t.Errorf("%s: expected an unsafe path to be rejected", name)
+		}
+	}
+}
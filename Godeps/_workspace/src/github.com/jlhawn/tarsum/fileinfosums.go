@@ -1,9 +1,13 @@
 package tarsum
 
-import "sort"
+import (
+	"sort"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
 
 // This info will be accessed through interface so the actual name and sum cannot be medled with
-type fileInfoSumInterface interface {
+type FileInfoSumInterface interface {
 	// File name
 	Name() string
 	// Checksum of this particular file and its headers
@@ -12,10 +16,22 @@ type fileInfoSumInterface interface {
 	Pos() int64
 }
 
+// TypedFileInfoSum extends FileInfoSumInterface with the type of the entry,
+// so that entries whose names are the same once any trailing "/" has been
+// removed, such as a directory "dir/" and a file "dir", can be told apart,
+// as VersionTypedNames does when aggregating them.
+// The sums recorded by a TarSum implement it.
+type TypedFileInfoSum interface {
+	FileInfoSumInterface
+	// Typeflag of the entry
+	Typeflag() byte
+}
+
 type fileInfoSum struct {
-	name string
-	sum  string
-	pos  int64
+	name     string
+	sum      string
+	pos      int64
+	typeflag byte
 }
 
 func (fis fileInfoSum) Name() string {
@@ -27,11 +43,38 @@ func (fis fileInfoSum) Sum() string {
 func (fis fileInfoSum) Pos() int64 {
 	return fis.pos
 }
+func (fis fileInfoSum) Typeflag() byte {
+	return fis.typeflag
+}
+
+// fileTypeflag returns the type of the entry summed by fis, or 0 if it is
+// not known.
+func fileTypeflag(fis FileInfoSumInterface) byte {
+	if t, ok := fis.(TypedFileInfoSum); ok {
+		return t.Typeflag()
+	}
+	return 0
+}
+
+// sameEntry reports whether a and b are sums of the same file as
+// VersionTypedNames identifies them: whether they have the same name and,
+// when both have a type, both or neither of them are directories.
+func sameEntry(a, b FileInfoSumInterface) bool {
+	if a.Name() != b.Name() {
+		return false
+	}
+	ta, okA := a.(TypedFileInfoSum)
+	tb, okB := b.(TypedFileInfoSum)
+	if !okA || !okB {
+		return true
+	}
+	return (ta.Typeflag() == tar.TypeDir) == (tb.Typeflag() == tar.TypeDir)
+}
 
-type fileInfoSums []fileInfoSumInterface
+type FileInfoSums []FileInfoSumInterface
 
 // GetFile returns the first FileInfoSumInterface with a matching name
-func (fis fileInfoSums) GetFile(name string) fileInfoSumInterface {
+func (fis FileInfoSums) GetFile(name string) FileInfoSumInterface {
 	for i := range fis {
 		if fis[i].Name() == name {
 			return fis[i]
@@ -41,8 +84,8 @@ func (fis fileInfoSums) GetFile(name string) fileInfoSumInterface {
 }
 
 // GetAllFile returns a FileInfoSums with all matching names
-func (fis fileInfoSums) GetAllFile(name string) fileInfoSums {
-	f := fileInfoSums{}
+func (fis FileInfoSums) GetAllFile(name string) FileInfoSums {
+	f := FileInfoSums{}
 	for i := range fis {
 		if fis[i].Name() == name {
 			f = append(f, fis[i])
@@ -60,7 +103,7 @@ func contains(s []string, e string) bool {
 	return false
 }
 
-func (fis fileInfoSums) GetDuplicatePaths() (dups fileInfoSums) {
+func (fis FileInfoSums) GetDuplicatePaths() (dups FileInfoSums) {
 	seen := make(map[string]int, len(fis)) // allocate earl. no need to grow this map.
 	for i := range fis {
 		f := fis[i]
@@ -73,53 +116,69 @@ func (fis fileInfoSums) GetDuplicatePaths() (dups fileInfoSums) {
 	return dups
 }
 
-func (fis fileInfoSums) Len() int      { return len(fis) }
-func (fis fileInfoSums) Swap(i, j int) { fis[i], fis[j] = fis[j], fis[i] }
+func (fis FileInfoSums) Len() int      { return len(fis) }
+func (fis FileInfoSums) Swap(i, j int) { fis[i], fis[j] = fis[j], fis[i] }
 
-func (fis fileInfoSums) SortByPos() {
+func (fis FileInfoSums) SortByPos() {
 	sort.Sort(byPos{fis})
 }
 
-func (fis fileInfoSums) SortByNames() {
+func (fis FileInfoSums) SortByNames() {
 	sort.Sort(byName{fis})
 }
 
-func (fis fileInfoSums) SortBySums() {
+func (fis FileInfoSums) SortBySums() {
+	fis.sortBySums(false)
+}
+
+// sortSums sorts the sums as version v aggregates them.
+func (fis FileInfoSums) sortSums(v Version) {
+	fis.sortBySums(v == VersionTypedNames)
+}
+
+// sortBySums sorts by sum, ordering files which share a name by position.
+// When typed, a directory and a non-directory sharing a name are different
+// files, ordered by sum.
+func (fis FileInfoSums) sortBySums(typed bool) {
 	dups := fis.GetDuplicatePaths()
 	if len(dups) > 0 {
-		sort.Sort(bySum{fis, dups})
+		sort.Sort(bySum{FileInfoSums: fis, dups: dups, typed: typed})
 	} else {
-		sort.Sort(bySum{fis, nil})
+		sort.Sort(bySum{FileInfoSums: fis})
 	}
 }
 
 // byName is a sort.Sort helper for sorting by file names.
 // If names are the same, order them by their appearance in the tar archive
-type byName struct{ fileInfoSums }
+type byName struct{ FileInfoSums }
 
 func (bn byName) Less(i, j int) bool {
-	if bn.fileInfoSums[i].Name() == bn.fileInfoSums[j].Name() {
-		return bn.fileInfoSums[i].Pos() < bn.fileInfoSums[j].Pos()
+	if bn.FileInfoSums[i].Name() == bn.FileInfoSums[j].Name() {
+		return bn.FileInfoSums[i].Pos() < bn.FileInfoSums[j].Pos()
 	}
-	return bn.fileInfoSums[i].Name() < bn.fileInfoSums[j].Name()
+	return bn.FileInfoSums[i].Name() < bn.FileInfoSums[j].Name()
 }
 
 // bySum is a sort.Sort helper for sorting by the sums of all the fileinfos in the tar archive
 type bySum struct {
-	fileInfoSums
-	dups fileInfoSums
+	FileInfoSums
+	dups  FileInfoSums
+	typed bool // whether a directory and a file sharing a name are different files
 }
 
 func (bs bySum) Less(i, j int) bool {
-	if bs.dups != nil && bs.fileInfoSums[i].Name() == bs.fileInfoSums[j].Name() {
-		return bs.fileInfoSums[i].Pos() < bs.fileInfoSums[j].Pos()
+	if bs.dups != nil {
+		a, b := bs.FileInfoSums[i], bs.FileInfoSums[j]
+		if bs.typed && sameEntry(a, b) || !bs.typed && a.Name() == b.Name() {
+			return a.Pos() < b.Pos()
+		}
 	}
-	return bs.fileInfoSums[i].Sum() < bs.fileInfoSums[j].Sum()
+	return bs.FileInfoSums[i].Sum() < bs.FileInfoSums[j].Sum()
 }
 
 // byPos is a sort.Sort helper for sorting by the sums of all the fileinfos by their original order
-type byPos struct{ fileInfoSums }
+type byPos struct{ FileInfoSums }
 
 func (bp byPos) Less(i, j int) bool {
-	return bp.fileInfoSums[i].Pos() < bp.fileInfoSums[j].Pos()
+	return bp.FileInfoSums[i].Pos() < bp.FileInfoSums[j].Pos()
 }
@@ -0,0 +1,116 @@
+package tarsum
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// excluded reports whether the entry with the given name and type should be
+// left out of the checksum.
+func (ts *tarSum) excluded(name string, typeflag byte) bool {
+	if ts.opts.TypeFilter != nil {
+		if typeflag == tar.TypeRegA {
+			typeflag = tar.TypeReg
+		}
+		if !ts.opts.TypeFilter[typeflag] {
+			return true
+		}
+	}
+	for _, pattern := range ts.opts.ExcludePatterns {
+		if matchExcludePattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchExcludePattern reports whether name matches pattern as described for
+// Options.ExcludePatterns. The pattern must already be known to be valid.
+func matchExcludePattern(pattern, name string) bool {
+	if dir := strings.TrimSuffix(pattern, "/**"); dir != pattern {
+		// Match any entry beneath a directory matching dir.
+		for parent := path.Dir(name); parent != "." && parent != "/"; parent = path.Dir(parent) {
+			if ok, _ := path.Match(dir, parent); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// ErrMissingRequiredFile is returned by Read at the end of an archive which
+// lacks one of the entries named in Options.RequireFiles.
+type ErrMissingRequiredFile struct {
+	Name string
+}
+
+func (e ErrMissingRequiredFile) Error() string {
+	return fmt.Sprintf("tarsum: archive is missing required file %q", e.Name)
+}
+
+// entryName normalizes an entry name as the per-file sums are named, with
+// any leading "./" and trailing "/" removed.
+func entryName(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+}
+
+// seeRequired records that an entry with the given normalized name was read.
+func (ts *tarSum) seeRequired(name string) {
+	if _, ok := ts.required[name]; ok {
+		ts.required[name] = true
+	}
+}
+
+// checkRequired returns an ErrMissingRequiredFile for the first name in
+// Options.RequireFiles which was not read.
+func (ts *tarSum) checkRequired() error {
+	for _, name := range ts.opts.RequireFiles {
+		if !ts.required[entryName(name)] {
+			return ErrMissingRequiredFile{Name: name}
+		}
+	}
+	return nil
+}
+
+// ErrUnsortedEntries is returned by Read with Options.RequireSortedEntries
+// when an entry's name sorts before that of the entry preceding it.
+type ErrUnsortedEntries struct {
+	Prev string
+	Cur  string
+}
+
+func (e ErrUnsortedEntries) Error() string {
+	return fmt.Sprintf("tarsum: entry %q is out of order after %q", e.Cur, e.Prev)
+}
+
+// lessEntryName reports whether the normalized entry name a sorts before b
+// when compared element by element, so that the contents of a directory
+// sort directly after it.
+func lessEntryName(a, b string) bool {
+	for a != "" && b != "" {
+		var ea, eb string
+		ea, a = splitFirst(a)
+		eb, b = splitFirst(b)
+		if ea != eb {
+			return ea < eb
+		}
+	}
+	return a == "" && b != ""
+}
+
+// splitFirst splits the first element from a slash-separated name.
+func splitFirst(name string) (first, rest string) {
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
@@ -0,0 +1,152 @@
+package tarsum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestExcludePatterns(t *testing.T) {
+	kept := []testEntry{regEntry("app/main", "binary"), regEntry("tmp", "not a directory")}
+	archive := buildTar(t,
+		regEntry("app/main", "binary"),
+		regEntry("app/debug.log", "log line"),
+		regEntry("tmp", "not a directory"),
+		regEntry("tmp/cache/entry", "cached"),
+		regEntry("server.log", "another"),
+	)
+
+	sums, err := ComputeFileSums(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 5 {
+		t.Fatalf("expected 5 files without excludes, got %d", len(sums))
+	}
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+		DisableCompression: true,
+		ExcludePatterns:    []string{"*.log", "tmp/**"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := readAllSizes(t, ts, buf32K)
+
+	if sum, expected := ts.Sum(nil), sumArchive(t, buildTar(t, kept...), Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+	if !bytes.Contains(out, []byte("cached")) {
+		t.Error("expected excluded entries to still be re-emitted")
+	}
+
+	if _, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{ExcludePatterns: []string{"["}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestTypeFilter(t *testing.T) {
+	mtime := time.Unix(1400000000, 0)
+	link := testEntry{header: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "file", Mode: 0777, ModTime: mtime}}
+	kept := []testEntry{regEntry("file", "content"), link}
+	archive := buildTar(t,
+		testEntry{header: &tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime}},
+		regEntry("file", "content"),
+		link,
+		testEntry{header: &tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644, ModTime: mtime}},
+		deviceEntry("dev/null", tar.TypeChar, 1, 3),
+	)
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+		TypeFilter: map[byte]bool{tar.TypeReg: true, tar.TypeSymlink: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+
+	if len(ts.GetSums()) != 2 {
+		t.Errorf("expected 2 files to be summed, got %d", len(ts.GetSums()))
+	}
+	if sum, expected := ts.Sum(nil), sumArchive(t, buildTar(t, kept...), Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
+
+func TestRequireFiles(t *testing.T) {
+	archive := buildTar(t,
+		testEntry{header: &tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}},
+		regEntry("etc/manifest.json", "{}"),
+		regEntry("app.log", "excluded but present"),
+	)
+
+	testCases := []struct {
+		require []string
+		err     error
+	}{
+		{nil, nil},
+		{[]string{"etc/manifest.json"}, nil},
+		{[]string{"./etc/manifest.json", "etc", "app.log"}, nil},
+		{[]string{"etc/manifest.json", "etc/missing", "other"}, ErrMissingRequiredFile{Name: "etc/missing"}},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+			RequireFiles:    testCase.require,
+			ExcludePatterns: []string{"*.log"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(ts); err != testCase.err {
+			t.Errorf("Mismatched error for %v\n\tActual: %v\n\tExpected: %v", testCase.require, err, testCase.err)
+		}
+	}
+}
+
+func TestRequireSortedEntries(t *testing.T) {
+	dir := testEntry{header: &tar.Header{Name: "./dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}
+	sorted := buildTar(t, dir, regEntry("dir/a", "1"), regEntry("dir/b", "2"), regEntry("dir-x", "3"), regEntry("z", "4"))
+	unsorted := buildTar(t, regEntry("z", "4"), dir, regEntry("dir/b", "2"), regEntry("dir/a", "1"), regEntry("dir-x", "3"))
+
+	if sumArchive(t, sorted, Version1) != sumArchive(t, unsorted, Version1) {
+		t.Fatal("expected both orders to have the same checksum")
+	}
+
+	testCases := []struct {
+		name    string
+		archive []byte
+		err     error
+	}{
+		{"sorted", sorted, nil},
+		{"unsorted", unsorted, ErrUnsortedEntries{Prev: "z", Cur: "dir"}},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(testCase.archive), Version1, Options{RequireSortedEntries: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(ts); err != testCase.err {
+			t.Errorf("Mismatched error for %s\n\tActual: %v\n\tExpected: %v", testCase.name, err, testCase.err)
+		}
+	}
+
+	less := []struct {
+		a, b string
+		less bool
+	}{
+		{"", "a", true},
+		{"dir", "dir/a", true},
+		{"dir/a", "dir-x", true},
+		{"dir-x", "dir/a", false},
+		{"a", "a", false},
+		{"a/b", "a/c", true},
+	}
+	for _, l := range less {
+		if lessEntryName(l.a, l.b) != l.less {
+			t.Errorf("expected lessEntryName(%q, %q) to be %t", l.a, l.b, l.less)
+		}
+	}
+}
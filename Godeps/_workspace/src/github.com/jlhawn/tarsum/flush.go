@@ -0,0 +1,52 @@
+package tarsum
+
+import (
+	"fmt"
+)
+
+// FlushPolicy selects when the output writer of ModeReemit, the gzip
+// compressor unless DisableCompression is set, is flushed to the output
+// returned by Read. The re-encoded bytes and the checksum are the same for
+// every policy; only the gzip sync flushes and the amount of output
+// available to each Read differ.
+type FlushPolicy int
+
+const (
+	// FlushAlways flushes after every step of Read. It is the default.
+	FlushAlways FlushPolicy = iota
+	// FlushNever only flushes when the archive ends. Until then Read
+	// returns the output the compressor emits on its own, so it may
+	// consume much more input before returning anything.
+	FlushNever
+	// FlushEveryN flushes once at least Options.FlushBytes bytes have
+	// been written to the output writer since the last flush.
+	FlushEveryN
+)
+
+func (p FlushPolicy) String() string {
+	switch p {
+	case FlushAlways:
+		return "always"
+	case FlushNever:
+		return "never"
+	case FlushEveryN:
+		return "every-n"
+	}
+	return fmt.Sprintf("FlushPolicy(%d)", int(p))
+}
+
+// skipFlush reports whether the flush policy leaves the output writer
+// unflushed after n more bytes have been written to it.
+func (ts *tarSum) skipFlush(n int64) bool {
+	switch ts.opts.FlushPolicy {
+	case FlushNever:
+		return true
+	case FlushEveryN:
+		ts.unflushed += n
+		if ts.unflushed < ts.opts.FlushBytes {
+			return true
+		}
+		ts.unflushed = 0
+	}
+	return false
+}
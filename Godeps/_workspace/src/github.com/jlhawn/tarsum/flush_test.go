@@ -0,0 +1,54 @@
+package tarsum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestFlushPolicy(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("big", string(bytes.Repeat([]byte("flush"), 64*1024))), regEntry("b", "two"))
+
+	output := func(opts Options) []byte {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := readAllSizes(t, ts, buf8K)
+		if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+			t.Errorf("Mismatched sum with %v\n\tActual: %s\n\tExpected: %s", opts.FlushPolicy, sum, expected)
+		}
+		if !opts.DisableCompression {
+			gz, err := gzip.NewReader(bytes.NewReader(out))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return out
+	}
+
+	policies := []Options{
+		{FlushPolicy: FlushNever},
+		{FlushPolicy: FlushEveryN, FlushBytes: 32 * 1024},
+		{FlushPolicy: FlushEveryN, FlushBytes: 1},
+	}
+	for _, dc := range []bool{true, false} {
+		expected := output(Options{DisableCompression: dc})
+		for _, opts := range policies {
+			opts.DisableCompression = dc
+			if actual := output(opts); !bytes.Equal(actual, expected) {
+				t.Errorf("Mismatched output with %v and compression disabled %t: %d bytes, expected %d bytes", opts.FlushPolicy, dc, len(actual), len(expected))
+			}
+		}
+	}
+
+	for _, opts := range []Options{{FlushPolicy: FlushEveryN}, {FlushPolicy: FlushPolicy(-1)}} {
+		if _, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, opts); err == nil {
+			t.Errorf("expected an error for %v with %d flush bytes", opts.FlushPolicy, opts.FlushBytes)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package tarsum
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// captureGlobal keeps the data of a global PAX header entry being read.
+func (ts *tarSum) captureGlobal(p []byte) {
+	if ts.global {
+		ts.globalData.Write(p)
+	}
+}
+
+// finishGlobal parses the records of the global PAX header entry just read
+// and merges them into those aggregated so far.
+func (ts *tarSum) finishGlobal() error {
+	records, err := tar.ParsePAXRecords(ts.globalData.Bytes())
+	ts.globalData.Reset()
+	if err != nil {
+		return fmt.Errorf("tarsum: parsing global PAX header %q: %v", ts.currentFile, err)
+	}
+	if ts.globalRecords == nil {
+		ts.globalRecords = make(map[string]string)
+	}
+	for k, v := range records {
+		ts.globalRecords[k] = v
+	}
+	return nil
+}
+
+// writeGlobalRecords writes the aggregated global PAX records sorted by
+// keyword, each framed as a PAX record.
+func (ts *tarSum) writeGlobalRecords(w io.Writer) {
+	keys := make([]string, 0, len(ts.globalRecords))
+	for k := range ts.globalRecords {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.Write([]byte(paxRecord(k, ts.globalRecords[k])))
+	}
+}
+
+// paxRecord formats a PAX record, "%d %s=%s\n", whose length includes the
+// length field itself.
+func paxRecord(k, v string) string {
+	size := len(k) + len(v) + len(" =\n")
+	size += len(fmt.Sprint(size))
+	record := fmt.Sprintf("%d %s=%s\n", size, k, v)
+	if len(record) != size {
+		// The length field grew by a digit.
+		size = len(record)
+		record = fmt.Sprintf("%d %s=%s\n", size, k, v)
+	}
+	return record
+}
@@ -0,0 +1,39 @@
+package tarsum
+
+import (
+	"fmt"
+	"hash"
+	"strings"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// selectFileHash sets the hash of the current file to the one chosen by
+// Options.HashSelector for its header, or to the hash of the checksum if it
+// chooses none. Each hash is validated when it is first chosen and reused
+// for every file it is chosen for.
+func (ts *tarSum) selectFileHash(h *tar.Header) error {
+	th := ts.opts.HashSelector(h)
+	if th == nil {
+		th = ts.th
+	}
+	name := th.Name()
+	fh, ok := ts.fileHashes[name]
+	if !ok {
+		if err := validateTHash(th); err != nil {
+			return err
+		}
+		if strings.Contains(name, ":") {
+			return fmt.Errorf("tarsum: per-file hash name %q contains a colon", name)
+		}
+		if ts.fileHashes == nil {
+			ts.fileHashes = make(map[string]hash.Hash)
+		}
+		fh = th.Hash()
+		ts.fileHashes[name] = fh
+	}
+	fh.Reset()
+	ts.h = fh
+	ts.fileHashName = name
+	return nil
+}
@@ -0,0 +1,74 @@
+package tarsum
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"hash"
+	"strings"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestHashSelector(t *testing.T) {
+	archive := buildTar(t, regEntry("bin/tool", "executable"), regEntry("media/movie.mp4", "frames"), regEntry("etc/config", "settings"))
+	sha512THash := NewTHash("sha512", sha512.New)
+	sum := func(selector func(h *tar.Header) THash) (string, FileInfoSums) {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{Mode: ModeDigestOnly, HashSelector: selector})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		return ts.Sum(nil), ts.GetSums()
+	}
+	strongFor := func(prefix string) func(h *tar.Header) THash {
+		return func(h *tar.Header) THash {
+			if strings.HasPrefix(h.Name, prefix) {
+				return sha512THash
+			}
+			return nil
+		}
+	}
+
+	mixed, sums := sum(strongFor("bin/"))
+	expected := map[string]string{"bin/tool": "sha512", "media/movie.mp4": "sha256", "etc/config": "sha256"}
+	for name, hashName := range expected {
+		fis := sums.GetFile(name)
+		if fis == nil {
+			t.Fatalf("expected a sum for %s", name)
+		}
+		parts := strings.SplitN(fis.Sum(), ":", 2)
+		if len(parts) != 2 || parts[0] != hashName {
+			t.Errorf("Mismatched hash of %s\n\tActual: %s\n\tExpected: %s", name, fis.Sum(), hashName)
+			continue
+		}
+		if size := len(parts[1]) / 2; size != standardTHashes[hashName].Hash().Size() {
+			t.Errorf("Mismatched digest size of %s\n\tActual: %d", name, size)
+		}
+	}
+	if !strings.HasPrefix(mixed, "tarsum.v1+sha256:") {
+		t.Errorf("expected the checksum to be aggregated with THash: %s", mixed)
+	}
+
+	if other, _ := sum(strongFor("media/")); other == mixed {
+		t.Error("expected changing the hash of a file to change the checksum")
+	}
+	if none, _ := sum(strongFor("none/")); none == mixed || none == sumArchive(t, archive, Version1) {
+		t.Error("expected labelled sums to produce a distinct checksum")
+	}
+	if again, _ := sum(strongFor("bin/")); again != mixed {
+		t.Errorf("Mismatched checksums\n\tActual: %s\n\tExpected: %s", again, mixed)
+	}
+
+	badTHash := NewTHash("bad:name", func() hash.Hash { return sha512.New() })
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{Mode: ModeDigestOnly, HashSelector: func(*tar.Header) THash { return badTHash }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Read(make([]byte, buf32K)); err == nil {
+		t.Error("expected a hash name with a colon to be rejected")
+	}
+	if _, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{HashSelector: strongFor("bin/"), DigestEncoding: EncodingBase32}); err == nil {
+		t.Error("expected re-encoding labelled sums to be rejected")
+	}
+}
@@ -0,0 +1,68 @@
+package tarsum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestHashHeadBytes(t *testing.T) {
+	const head = 4096
+	blob := []byte(strings.Repeat("0123456789abcdef", 1<<16))
+	archive := func(blob []byte) []byte {
+		return buildTar(t, regEntry("small", "data"), regEntry("blob", string(blob)))
+	}
+	sum := func(b []byte) string {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(b), Version1, Options{Mode: ModeDigestOnly, HashHeadBytes: head})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Reads span the end of the head.
+		readAllSizes(t, ts, 3000)
+		return ts.Sum(nil)
+	}
+	changed := func(i int) []byte {
+		c := append([]byte(nil), blob...)
+		c[i] ^= 0xff
+		return c
+	}
+
+	base := sum(archive(blob))
+	if base == sumArchive(t, archive(blob), Version1) {
+		t.Error("expected hashing heads to produce a non-standard checksum")
+	}
+	// Files no larger than the head are hashed in full.
+	full := sumArchive(t, buildTar(t, regEntry("small", "data")), Version1)
+	if small := sum(buildTar(t, regEntry("small", "data"))); small != full {
+		t.Errorf("Mismatched sums of a file within the head\n\tActual: %s\n\tExpected: %s", small, full)
+	}
+
+	if tail := sum(archive(changed(len(blob) - 1))); tail != base {
+		t.Errorf("Mismatched sums of a file changed beyond its head\n\tActual: %s\n\tExpected: %s", tail, base)
+	}
+	if changedHead := sum(archive(changed(head - 1))); changedHead == base {
+		t.Error("expected a change within the head to change the sum")
+	}
+	if longer := sum(archive(append(blob, 'x'))); longer == base {
+		t.Error("expected a change in the declared size to change the sum")
+	}
+
+	// The size is hashed even for normalized text.
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive(blob)), Version1, Options{Mode: ModeDigestOnly, HashHeadBytes: head, TextNormalize: func(string) bool { return true }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+	longerText, err := NewTarSumWithOptions(bytes.NewReader(archive(append(blob, 'x'))), Version1, Options{Mode: ModeDigestOnly, HashHeadBytes: head, TextNormalize: func(string) bool { return true }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, longerText, buf32K)
+	if ts.Sum(nil) == longerText.Sum(nil) {
+		t.Error("expected a change in the declared size of normalized text to change the sum")
+	}
+
+	if _, err := NewTarSumWithOptions(bytes.NewReader(nil), Version1, Options{HashHeadBytes: -1}); err == nil {
+		t.Error("expected a negative head size to be rejected")
+	}
+}
@@ -0,0 +1,106 @@
+package tarsum
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// errBodyExpired is returned when the body of an entry is read after
+// NextFile has advanced past it.
+var errBodyExpired = errors.New("tarsum: file body read after NextFile")
+
+// FileIterator reads the entries of an archive one at a time, giving access
+// to the body of each as it is hashed.
+type FileIterator struct {
+	ts   *tarSum
+	body *fileBody
+	err  error
+}
+
+// fileBody reads the body of the current entry, hashing it as it is read.
+type fileBody struct {
+	it *FileIterator
+}
+
+// NewFileIterator creates a FileIterator reading the archive from r. Once
+// NextFile has returned io.EOF, Sum and GetSums report the checksum and the
+// per-file sums of the archive as a TarSum of the version would.
+func NewFileIterator(r io.Reader, v Version) (*FileIterator, error) {
+	ts, err := newTarSumOptions(r, v, Options{Mode: ModeDigestOnly})
+	if err != nil {
+		return nil, err
+	}
+	return &FileIterator{ts: ts}, nil
+}
+
+// NextFile advances to the next entry of the archive and returns its header
+// and a reader over its body. Whatever the caller does not read of the
+// previous body is read and hashed first, so the checksum does not depend on
+// how much of each body is read. The reader is only valid until the next
+// call to NextFile. At the end of the archive NextFile returns io.EOF.
+func (it *FileIterator) NextFile() (*tar.Header, io.Reader, error) {
+	if it.err != nil {
+		return nil, nil, it.err
+	}
+	if it.body != nil {
+		it.body.it = nil
+		it.body = nil
+		if it.err = it.finishEntry(); it.err != nil {
+			return nil, nil, it.err
+		}
+	}
+
+	ts := it.ts
+	hdr, err := ts.tarR.Next()
+	if err != nil {
+		it.err = err
+		return nil, nil, err
+	}
+	ts.beginEntry(hdr)
+	if !ts.skip {
+		if it.err = ts.encodeHeader(hdr); it.err != nil {
+			return nil, nil, it.err
+		}
+	}
+	it.body = &fileBody{it: it}
+	return hdr, it.body, nil
+}
+
+// finishEntry hashes the unread remainder of the current entry and records
+// its sum.
+func (it *FileIterator) finishEntry() error {
+	if _, err := io.Copy(ioutil.Discard, &fileBody{it: it}); err != nil {
+		return err
+	}
+	return it.ts.finishFile()
+}
+
+// Sum returns the checksum of the archive, as TarSum.Sum does.
+func (it *FileIterator) Sum(extra []byte) string {
+	return it.ts.Sum(extra)
+}
+
+// GetSums returns the per-file sums of the entries passed so far, as
+// TarSum.GetSums does. The sum of an entry is recorded when NextFile
+// advances past it.
+func (it *FileIterator) GetSums() FileInfoSums {
+	return it.ts.GetSums()
+}
+
+func (b *fileBody) Read(p []byte) (int, error) {
+	if b.it == nil {
+		return 0, errBodyExpired
+	}
+	ts := b.it.ts
+	n, err := ts.tarR.Read(p)
+	ts.captureGlobal(p[:n])
+	if !ts.skip {
+		if err := ts.hashData(p[:n]); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
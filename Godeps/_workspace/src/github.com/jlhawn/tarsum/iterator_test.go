@@ -0,0 +1,67 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestFileIterator(t *testing.T) {
+	big := string(bytes.Repeat([]byte("big"), 64*1024))
+	dir := testEntry{header: &tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}
+	archive := buildTar(t, dir, regEntry("dir/a", "one"), regEntry("dir/big", big), regEntry("dir/skipped", big), regEntry("c", "three"))
+
+	for _, v := range []Version{Version0, Version1} {
+		it, err := NewFileIterator(bytes.NewReader(archive), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		var stale io.Reader
+		for {
+			hdr, body, err := it.NextFile()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+			switch hdr.Name {
+			case "dir/a":
+				// Read all of one body.
+				data, err := ioutil.ReadAll(body)
+				if err != nil || string(data) != "one" {
+					t.Errorf("Mismatched body of %s\n\tActual: %q, %v\n\tExpected: %q", hdr.Name, data, err, "one")
+				}
+				stale = body
+			case "dir/big":
+				// Read part of another.
+				buf := make([]byte, 100)
+				if _, err := io.ReadFull(body, buf); err != nil || string(buf) != big[:100] {
+					t.Errorf("Mismatched start of the body of %s: %q, %v", hdr.Name, buf, err)
+				}
+			}
+		}
+		if len(names) != 5 {
+			t.Errorf("expected 5 entries, got %v", names)
+		}
+		if _, err := stale.Read(make([]byte, 1)); err != errBodyExpired {
+			t.Errorf("Mismatched error reading an expired body\n\tActual: %v\n\tExpected: %v", err, errBodyExpired)
+		}
+		if _, _, err := it.NextFile(); err != io.EOF {
+			t.Errorf("expected io.EOF after the end of the archive, got %v", err)
+		}
+
+		if len(it.GetSums()) != 5 {
+			t.Errorf("expected 5 file sums, got %d", len(it.GetSums()))
+		}
+		if actual, expected := it.Sum(nil), sumArchive(t, archive, v); actual != expected {
+			t.Errorf("Mismatched sum for %s\n\tActual: %s\n\tExpected: %s", v, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package tarsum
+
+import (
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// legacyHeaderSelector selects headers as builds of Docker for 32-bit
+// platforms did, as Options.LegacyCompat requires. Their header selectors
+// format the numeric fields of a header through int, which is 32 bits wide
+// there, and their tar reader parses uid and gid into it too, so every
+// field which does not fit wraps around before it is hashed.
+type legacyHeaderSelector struct {
+	tarHeaderSelector
+}
+
+func (ls legacyHeaderSelector) selectHeaders(h *tar.Header) (orderedHeaders [][2]string) {
+	c := *h
+	c.Mode = int64(int32(h.Mode))
+	c.Uid, c.Gid = int(int32(h.Uid)), int(int32(h.Gid))
+	c.Size = int64(int32(h.Size))
+	c.ModTime = time.Unix(int64(int32(h.ModTime.Unix())), int64(h.ModTime.Nanosecond()))
+	c.Devmajor, c.Devminor = int64(int32(h.Devmajor)), int64(int32(h.Devminor))
+	return ls.tarHeaderSelector.selectHeaders(&c)
+}
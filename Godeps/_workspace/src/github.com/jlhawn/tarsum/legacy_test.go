@@ -0,0 +1,58 @@
+package tarsum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// The fixture holds a directory, a file modified after January 2038 and a
+// character device whose numbers do not fit in 32 bits. The legacy sums
+// were computed by the TarSum of Docker 1.8.1, as vendored under
+// examples/registry/malevolent, built with GOARCH=386, and the standard
+// sums by the same code built with GOARCH=amd64. Both read the archive
+// with this package's original copy of archive/tar, since that release
+// does not handle the tar reader of current Go returning data together
+// with io.EOF.
+const legacyFixture = "testdata/legacy.tar"
+
+func TestLegacyCompat(t *testing.T) {
+	archive, err := ioutil.ReadFile(legacyFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		v        Version
+		standard string
+		legacy   string
+	}{
+		{Version0, "tarsum+sha256:e18b23cd09507cb324f3c0f2c74bbb653a6f11499355a4b37917e83e65a29737", "tarsum+sha256:a631f16f59a1b47b55602b46819654019b6410db3ee0ab4522ec76e87e1dd125"},
+		{Version1, "tarsum.v1+sha256:c9487fabb06e8e4656484f802ae8b016e8bb1a3f1add403df122c9399a5f9c4e", "tarsum.v1+sha256:edf37c03cef1f54161d3e48cc48c7b99497c8c58c0ae5b5391d991e7af36f49d"},
+	}
+	for _, testCase := range testCases {
+		if sum := legacySum(t, archive, testCase.v); sum != testCase.legacy {
+			t.Errorf("Mismatched legacy sum for %s\n\tActual: %s\n\tExpected: %s", testCase.v, sum, testCase.legacy)
+		}
+		if sum := sumArchive(t, archive, testCase.v); sum != testCase.standard {
+			t.Errorf("Mismatched standard sum for %s\n\tActual: %s\n\tExpected: %s", testCase.v, sum, testCase.standard)
+		}
+	}
+
+	// Archives whose fields all fit in 32 bits sum as they do normally.
+	plain := buildTar(t, regEntry("bin/tool", "tool"), regEntry("./etc/config", "new"))
+	for _, v := range []Version{Version0, Version1} {
+		if actual, expected := legacySum(t, plain, v), sumArchive(t, plain, v); actual != expected {
+			t.Errorf("Mismatched legacy sum of %s without wide fields\n\tActual: %s\n\tExpected: %s", v, actual, expected)
+		}
+	}
+}
+
+func legacySum(t *testing.T, archive []byte, v Version) string {
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), v, Options{Mode: ModeDigestOnly, LegacyCompat: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+	return ts.Sum(nil)
+}
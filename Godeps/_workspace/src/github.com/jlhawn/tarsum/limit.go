@@ -0,0 +1,85 @@
+package tarsum
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrFileTimeout is returned by Read when consuming the body of a single
+// entry takes longer than Options.PerFileTimeout.
+type ErrFileTimeout struct {
+	Name string
+}
+
+func (e ErrFileTimeout) Error() string {
+	return fmt.Sprintf("tarsum: timed out reading %q", e.Name)
+}
+
+// startFileTimer returns the start time of a read from the body of the
+// current entry, without reading the clock unless opts.PerFileTimeout is
+// set.
+func (ts *tarSum) startFileTimer() time.Time {
+	if ts.opts.PerFileTimeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// checkFileTimeout adds the time since start to the time spent reading the
+// current entry and returns ErrFileTimeout once it exceeds the limit.
+func (ts *tarSum) checkFileTimeout(start time.Time) error {
+	if ts.opts.PerFileTimeout <= 0 || ts.first {
+		return nil
+	}
+	ts.fileElapsed += time.Since(start)
+	if ts.fileElapsed > ts.opts.PerFileTimeout {
+		return ErrFileTimeout{Name: ts.currentFile}
+	}
+	return nil
+}
+
+// seeName records the name of an entry and returns ErrTooManyNames once
+// more than opts.MaxDistinctNames distinct names have been read.
+func (ts *tarSum) seeName(name string) error {
+	if ts.opts.MaxDistinctNames <= 0 || ts.names[name] {
+		return nil
+	}
+	if len(ts.names) >= ts.opts.MaxDistinctNames {
+		return ErrTooManyNames
+	}
+	if ts.names == nil {
+		ts.names = make(map[string]bool)
+	}
+	ts.names[name] = true
+	return nil
+}
+
+// inputLimitReader reads at most n bytes from r, returning ErrInputTooLarge
+// rather than io.EOF if r has more.
+type inputLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *inputLimitReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if l.n <= 0 {
+		// The limit has been reached; the input is only acceptable if it
+		// ends here.
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrInputTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
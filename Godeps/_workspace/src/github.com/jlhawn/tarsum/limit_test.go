@@ -0,0 +1,125 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMaxInputBytes(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+	compressed := gzipBytes(t, archive)
+
+	testCases := []struct {
+		name  string
+		input []byte
+		opts  Options
+		err   error
+	}{
+		{"exact", archive, Options{MaxInputBytes: int64(len(archive))}, nil},
+		{"unlimited", archive, Options{}, nil},
+		{"one short", archive, Options{MaxInputBytes: int64(len(archive)) - 1}, ErrInputTooLarge},
+		{"mid-stream", archive, Options{MaxInputBytes: 700}, ErrInputTooLarge},
+		{"compressed", compressed, Options{AutoDecompress: true, MaxInputBytes: int64(len(compressed))}, nil},
+		{"compressed too large", compressed, Options{AutoDecompress: true, MaxInputBytes: 20}, ErrInputTooLarge},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(testCase.input), Version1, testCase.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(ts); err != testCase.err {
+			t.Errorf("Mismatched error for %s\n\tActual: %v\n\tExpected: %v", testCase.name, err, testCase.err)
+		}
+	}
+}
+
+// slowReader returns at most one block per Read after a delay.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	if len(p) > 512 {
+		p = p[:512]
+	}
+	return r.r.Read(p)
+}
+
+func TestPerFileTimeout(t *testing.T) {
+	archive := buildTar(t, regEntry("small", "one"), regEntry("big", string(bytes.Repeat([]byte{'b'}, 256*1024))))
+
+	ts, err := NewTarSumWithOptions(slowReader{bytes.NewReader(archive), time.Millisecond}, Version1, Options{
+		PerFileTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(ioutil.Discard, ts)
+	if expected := (ErrFileTimeout{Name: "big"}); err != expected {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, expected)
+	}
+	if sums := ts.GetSums(); len(sums) != 1 || sums[0].Name() != "small" {
+		t.Errorf("expected only small to be summed before the timeout, got %v", sums)
+	}
+
+	// Time spent by the caller between reads does not count.
+	ts, err = NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+		DisableCompression: true,
+		PerFileTimeout:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, buf32K)
+	for {
+		if _, err := ts.Read(buf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestMaxDistinctNames(t *testing.T) {
+	const limit = 100
+	unique := make([]testEntry, limit+1)
+	duplicates := make([]testEntry, 10*limit)
+	for i := range unique {
+		unique[i] = regEntry(fmt.Sprintf("file%d", i), "data")
+	}
+	for i := range duplicates {
+		// The names differ only in how they are written.
+		name := fmt.Sprintf("file%d", i%limit)
+		if i%2 == 1 {
+			name = "./" + name
+		}
+		duplicates[i] = regEntry(name, "data")
+	}
+
+	testCases := []struct {
+		desc    string
+		entries []testEntry
+		err     error
+	}{
+		{"unique names", unique, ErrTooManyNames},
+		{"unique names within the limit", unique[:limit], nil},
+		{"duplicate names", duplicates, nil},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(buildTar(t, testCase.entries...)), Version1, Options{Mode: ModeDigestOnly, MaxDistinctNames: limit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != testCase.err {
+			t.Errorf("Mismatched errors for %s\n\tActual: %v\n\tExpected: %v", testCase.desc, err, testCase.err)
+		}
+	}
+}
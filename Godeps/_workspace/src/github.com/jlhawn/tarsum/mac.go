@@ -0,0 +1,62 @@
+package tarsum
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// macPrefix is prepended to the name of a THash keyed with HMAC, so that a
+// keyed checksum cannot be mistaken for an unkeyed one.
+const macPrefix = "hmac-"
+
+// NewMACTHash returns a THash computing an HMAC with the given key over th.
+// Its name is th's name prefixed with "hmac-".
+func NewMACTHash(th THash, key []byte) THash {
+	return NewTHash(macPrefix+th.Name(), func() hash.Hash {
+		return hmac.New(th.Hash, key)
+	})
+}
+
+// NewTarSumMAC creates a TarSum which uses an HMAC with the given key over th
+// for both the per-file sums and the aggregate checksum. The checksum is
+// labelled with the hash name "hmac-<name>", as in
+// "tarsum.v1+hmac-sha256:{hex}", and can only be verified with the key.
+func NewTarSumMAC(r io.Reader, dc bool, v Version, key []byte, th THash) (TarSum, error) {
+	if th == nil {
+		th = DefaultTHash
+	}
+	if err := validateTHash(th); err != nil {
+		return nil, err
+	}
+	return newTarSumHash(r, dc, v, NewMACTHash(th, key))
+}
+
+// VerifyTarSumMAC reports whether the keyed checksum of the archive read
+// from r, computed with key, matches expected. The checksum must name one of
+// the standard hashes with the "hmac-" prefix.
+func VerifyTarSumMAC(r io.Reader, expected string, key []byte) (bool, error) {
+	v, hashName, err := parseChecksumLabel(expected)
+	if err != nil {
+		return false, err
+	}
+	if !strings.HasPrefix(hashName, macPrefix) {
+		return false, fmt.Errorf("tarsum: checksum %q is not keyed", expected)
+	}
+	th, ok := standardTHashes[strings.TrimPrefix(hashName, macPrefix)]
+	if !ok {
+		return false, fmt.Errorf("tarsum: unknown hash name: %q", hashName)
+	}
+
+	ts, err := newTarSumHash(r, true, v, NewMACTHash(th, key))
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return false, err
+	}
+	return hmac.Equal([]byte(ts.Sum(nil)), []byte(expected)), nil
+}
@@ -0,0 +1,41 @@
+package tarsum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestTarSumMAC(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+	key := []byte("secret")
+
+	ts, err := NewTarSumMAC(bytes.NewReader(archive), true, Version1, key, DefaultTHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(ts); err != nil {
+		t.Fatal(err)
+	}
+	sum := ts.Sum(nil)
+	if !strings.HasPrefix(sum, "tarsum.v1+hmac-sha256:") {
+		t.Errorf("expected a keyed label, got %s", sum)
+	}
+	if strings.TrimPrefix(sum, "tarsum.v1+hmac-sha256:") == strings.TrimPrefix(sumArchive(t, archive, Version1), "tarsum.v1+sha256:") {
+		t.Error("expected the keyed checksum to differ from the unkeyed one")
+	}
+
+	if ok, err := VerifyTarSumMAC(bytes.NewReader(archive), sum, key); err != nil || !ok {
+		t.Errorf("expected verification with the key to succeed, got %t, %v", ok, err)
+	}
+	if ok, err := VerifyTarSumMAC(bytes.NewReader(archive), sum, []byte("wrong")); err != nil || ok {
+		t.Errorf("expected verification with the wrong key to fail, got %t, %v", ok, err)
+	}
+	if _, err := VerifyTarSumMAC(bytes.NewReader(archive), sumArchive(t, archive, Version1), key); err == nil {
+		t.Error("expected an error verifying an unkeyed checksum")
+	}
+	if _, err := VerifyTarSum(bytes.NewReader(archive), sum); err == nil {
+		t.Error("expected an error verifying a keyed checksum without a key")
+	}
+}
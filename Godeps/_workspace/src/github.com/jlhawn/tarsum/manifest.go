@@ -0,0 +1,72 @@
+package tarsum
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ManifestMarker separates the output of Read from the manifest which
+// follows it when Options.TrailingManifest is set. The stream is the output
+// as it would be without the option, then ManifestMarker, then the manifest
+// encoded as a single line of JSON ending with a newline. Encoded JSON never
+// contains a raw newline, so the manifest starts after the last occurrence
+// of the marker even if the output before it happens to contain one.
+const ManifestMarker = "\n#tarsum-manifest v1\n"
+
+// Manifest is the summary of an archive emitted after the output of Read by
+// a TarSum created with Options.TrailingManifest.
+type Manifest struct {
+	// Sum is the checksum of the archive, as returned by Sum(nil).
+	Sum string `json:"sum"`
+	// Files lists the per-file sums in archive order, rendered with
+	// Options.DigestEncoding as by GetSums.
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile is the sum of a single file within a Manifest.
+type ManifestFile struct {
+	Name string `json:"name"`
+	Sum  string `json:"sum"`
+	Pos  int64  `json:"pos"`
+}
+
+// ErrNoManifest is returned by SplitManifest if the stream does not end with
+// a manifest.
+var ErrNoManifest = errors.New("tarsum: stream has no trailing manifest")
+
+// SplitManifest splits a stream read from a TarSum created with
+// Options.TrailingManifest into the output preceding the manifest and the
+// decoded manifest.
+func SplitManifest(stream []byte) ([]byte, *Manifest, error) {
+	i := bytes.LastIndex(stream, []byte(ManifestMarker))
+	if i < 0 {
+		return nil, nil, ErrNoManifest
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(stream[i+len(ManifestMarker):], m); err != nil {
+		return nil, nil, fmt.Errorf("tarsum: decoding trailing manifest: %v", err)
+	}
+	return stream[:i], m, nil
+}
+
+// writeManifest appends the marker and the manifest to the output.
+func (ts *tarSum) writeManifest() error {
+	m := Manifest{Files: []ManifestFile{}}
+	for _, fis := range ts.GetSums() {
+		m.Files = append(m.Files, ManifestFile{Name: fis.Name(), Sum: fis.Sum(), Pos: fis.Pos()})
+	}
+	// Sum sorts the sums in place, but GetSums keeps returning them in
+	// archive order until the caller calls Sum.
+	sums := append(FileInfoSums(nil), ts.sums...)
+	m.Sum = ts.Sum(nil)
+	ts.sums = sums
+
+	ts.bufWriter.WriteString(ManifestMarker)
+	// Encode writes the manifest followed by a newline.
+	if err := json.NewEncoder(ts.bufWriter).Encode(m); err != nil {
+		return fmt.Errorf("tarsum: encoding trailing manifest: %v", err)
+	}
+	return nil
+}
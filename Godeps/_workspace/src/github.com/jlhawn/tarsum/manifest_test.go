@@ -0,0 +1,79 @@
+package tarsum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTrailingManifest(t *testing.T) {
+	archive := buildTar(t, regEntry("b", "one"), regEntry("a", "two"), regEntry("c", ManifestMarker))
+	expected := sumArchive(t, archive, Version1)
+
+	for _, mode := range []Mode{ModeReemit, ModePassthrough, ModeDigestOnly} {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+			DisableCompression: true,
+			TrailingManifest:   true,
+			Mode:               mode,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, _ := readAllSizes(t, ts, buf8K)
+
+		output, m, err := SplitManifest(stream)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		if m.Sum != expected || m.Sum != ts.Sum(nil) {
+			t.Errorf("%s: Mismatched manifest sum\n\tActual: %s\n\tExpected: %s", mode, m.Sum, expected)
+		}
+		if len(m.Files) != 3 || m.Files[0].Name != "b" || m.Files[1].Name != "a" || m.Files[2].Pos != 2 {
+			t.Errorf("%s: expected files in archive order, got %+v", mode, m.Files)
+		}
+		for _, f := range m.Files {
+			if fis := ts.GetSums().GetFile(f.Name); fis == nil || fis.Sum() != f.Sum {
+				t.Errorf("%s: Mismatched sum of %s in manifest", mode, f.Name)
+			}
+		}
+
+		switch mode {
+		case ModeDigestOnly:
+			if len(output) != 0 {
+				t.Errorf("%s: expected no output before the manifest, got %d bytes", mode, len(output))
+			}
+		default:
+			// The marker within the archive does not confuse the split.
+			if sum := sumArchive(t, output, Version1); sum != expected {
+				t.Errorf("%s: Mismatched sum of output\n\tActual: %s\n\tExpected: %s", mode, sum, expected)
+			}
+		}
+	}
+
+	// The compressed output is a complete gzip stream.
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{TrailingManifest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, _ := readAllSizes(t, ts, buf8K)
+	output, _, err := SplitManifest(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sumArchive(t, decoded, Version1); sum != expected {
+		t.Errorf("Mismatched sum of decompressed output\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	if _, _, err := SplitManifest(buildTar(t, regEntry("a", "one"))); err != ErrNoManifest {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, ErrNoManifest)
+	}
+}
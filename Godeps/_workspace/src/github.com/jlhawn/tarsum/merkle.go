@@ -0,0 +1,142 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Prefixes which separate leaf hashes from interior node hashes, so that a
+// leaf can never be mistaken for a subtree.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// MerkleTree is a binary Merkle tree over the per-file sums of an archive,
+// allowing inclusion proofs for individual files. It is an alternative to
+// the flat aggregation used by Sum and its root is not comparable with a
+// TarSum checksum.
+//
+// Leaves are the per-file sums in the order used by Sum (sorted by sum),
+// each hashed as H(0x00 || sum). Interior nodes are H(0x01 || left || right).
+// When a level has an odd number of nodes the last one is carried up to the
+// next level unchanged.
+type MerkleTree struct {
+	th     THash
+	sums   FileInfoSums
+	levels [][][]byte // levels[0] holds the leaves, the last level the root
+}
+
+// NewMerkleTree builds a MerkleTree over the given per-file sums using th.
+// The sums are copied, so the caller's slice is not reordered.
+func NewMerkleTree(sums FileInfoSums, th THash) *MerkleTree {
+	mt := &MerkleTree{th: th, sums: append(FileInfoSums(nil), sums...)}
+	mt.sums.SortBySums()
+
+	level := make([][]byte, len(mt.sums))
+	for i, fis := range mt.sums {
+		level[i] = merkleLeaf(th, fis.Sum())
+	}
+	mt.levels = append(mt.levels, level)
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleNode(th, level[i], level[i+1]))
+			}
+		}
+		mt.levels = append(mt.levels, next)
+		level = next
+	}
+
+	return mt
+}
+
+func merkleLeaf(th THash, sum string) []byte {
+	h := th.Hash()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write([]byte(sum))
+	return h.Sum(nil)
+}
+
+func merkleNode(th THash, left, right []byte) []byte {
+	h := th.Hash()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Root returns the root hash of the tree, or nil if it has no leaves.
+func (mt *MerkleTree) Root() []byte {
+	top := mt.levels[len(mt.levels)-1]
+	if len(top) == 0 {
+		return nil
+	}
+	return top[0]
+}
+
+// Len returns the number of leaves in the tree.
+func (mt *MerkleTree) Len() int {
+	return len(mt.sums)
+}
+
+// Index returns the leaf index of the first file with the given name.
+func (mt *MerkleTree) Index(name string) (int, error) {
+	for i, fis := range mt.sums {
+		if fis.Name() == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("tarsum: no file named %q in merkle tree", name)
+}
+
+// Proof returns the sibling hashes needed to recompute the root from the
+// leaf of the first file with the given name, ordered from the leaf up.
+// Levels at which the node has no sibling contribute nothing.
+func (mt *MerkleTree) Proof(name string) ([][]byte, error) {
+	index, err := mt.Index(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var proof [][]byte
+	for _, level := range mt.levels[:len(mt.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof reports whether proof shows that a file with the given
+// per-file sum is the leaf at index in a tree of count leaves with the
+// given root.
+func VerifyMerkleProof(th THash, root []byte, sum string, index, count int, proof [][]byte) bool {
+	if index < 0 || index >= count {
+		return false
+	}
+
+	node := merkleLeaf(th, sum)
+	for width := count; width > 1; width = (width + 1) / 2 {
+		sibling := index ^ 1
+		if sibling < width {
+			if len(proof) == 0 {
+				return false
+			}
+			if index%2 == 0 {
+				node = merkleNode(th, node, proof[0])
+			} else {
+				node = merkleNode(th, proof[0], node)
+			}
+			proof = proof[1:]
+		}
+		index /= 2
+	}
+
+	return len(proof) == 0 && bytes.Equal(node, root)
+}
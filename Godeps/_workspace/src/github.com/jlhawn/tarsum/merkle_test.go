@@ -0,0 +1,40 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for _, count := range []int{1, 2, 5, 8} {
+		var entries []testEntry
+		for i := 0; i < count; i++ {
+			entries = append(entries, regEntry(fmt.Sprintf("file%d", i), fmt.Sprintf("data %d", i)))
+		}
+		sums, err := ComputeFileSums(bytes.NewReader(buildTar(t, entries...)), Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mt := NewMerkleTree(sums, DefaultTHash)
+		root := mt.Root()
+		for _, fis := range sums {
+			proof, err := mt.Proof(fis.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			index, _ := mt.Index(fis.Name())
+			if !VerifyMerkleProof(DefaultTHash, root, fis.Sum(), index, mt.Len(), proof) {
+				t.Errorf("proof for %s in a tree of %d leaves did not verify", fis.Name(), count)
+			}
+			if VerifyMerkleProof(DefaultTHash, root, sums[0].Sum()+"0", index, mt.Len(), proof) {
+				t.Errorf("proof for %s verified with the wrong sum", fis.Name())
+			}
+		}
+	}
+
+	if _, err := NewMerkleTree(nil, DefaultTHash).Proof("missing"); err == nil {
+		t.Error("expected an error for a proof of a missing file")
+	}
+}
@@ -0,0 +1,40 @@
+package tarsum
+
+import (
+	"fmt"
+)
+
+// Mode selects what Read returns while a TarSum computes its checksum. The
+// checksum is the same in every mode for the same input.
+//
+//	Mode             Read returns
+//	ModeReemit       the archive re-encoded by the internal tar writer,
+//	                 gzip compressed unless DisableCompression is set
+//	ModePassthrough  the input bytes exactly as read, including any
+//	                 compression and data following the end of the archive
+//	ModeDigestOnly   no data; the first Read consumes the whole input and
+//	                 returns io.EOF
+//
+// DisableCompression and Canonicalize only affect ModeReemit.
+type Mode int
+
+const (
+	// ModeReemit re-encodes the archive. It is the default.
+	ModeReemit Mode = iota
+	// ModePassthrough returns the original input bytes.
+	ModePassthrough
+	// ModeDigestOnly returns no data and only computes the checksum.
+	ModeDigestOnly
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeReemit:
+		return "reemit"
+	case ModePassthrough:
+		return "passthrough"
+	case ModeDigestOnly:
+		return "digest-only"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
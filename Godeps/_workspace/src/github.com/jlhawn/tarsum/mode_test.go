@@ -0,0 +1,55 @@
+package tarsum
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestModes(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "hello"), regEntry("dir/b", "world"))
+	expected := sumArchive(t, archive, Version1)
+
+	inputs := []struct {
+		name  string
+		input []byte
+		opts  Options
+	}{
+		{"plain", archive, Options{}},
+		{"trailing zeros", append(append([]byte(nil), archive...), make([]byte, 1024)...), Options{}},
+		{"gzip", gzipBytes(t, archive), Options{AutoDecompress: true}},
+	}
+
+	for _, input := range inputs {
+		for _, mode := range []Mode{ModeReemit, ModePassthrough, ModeDigestOnly} {
+			opts := input.opts
+			opts.Mode = mode
+			ts, err := NewTarSumWithOptions(bytes.NewReader(input.input), Version1, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := ioutil.ReadAll(ts)
+			if err != nil {
+				t.Fatalf("%s %v: %v", input.name, mode, err)
+			}
+			if sum := ts.Sum(nil); sum != expected {
+				t.Errorf("Mismatched sum for %s in %v\n\tActual: %s\n\tExpected: %s", input.name, mode, sum, expected)
+			}
+
+			switch mode {
+			case ModePassthrough:
+				if !bytes.Equal(out, input.input) {
+					t.Errorf("%s: passthrough output differs from the input", input.name)
+				}
+			case ModeDigestOnly:
+				if len(out) != 0 {
+					t.Errorf("%s: digest-only mode returned %d bytes", input.name, len(out))
+				}
+			}
+		}
+	}
+
+	if _, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{Mode: Mode(42)}); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,42 @@
+package tarsum
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// MultihashTarSum extends TarSum with the checksum encoded as a multihash.
+// All TarSums created by this package implement it.
+type MultihashTarSum interface {
+	TarSum
+	SumMultihash(extra []byte) ([]byte, error)
+}
+
+// multihashCodes maps the names of hashes to their multihash codes.
+var multihashCodes = map[string]uint64{
+	"sha1":     0x11,
+	"sha256":   0x12,
+	"sha512":   0x13,
+	"sha3-512": 0x14,
+	"sha3-384": 0x15,
+	"sha3-256": 0x16,
+	"sha3-224": 0x17,
+}
+
+// SumMultihash returns the digest in the checksum returned by Sum(extra)
+// encoded as a multihash: the code of the hash and the length of the digest,
+// each as an unsigned varint, followed by the digest. The version is not
+// encoded. It returns an error if the hash has no multihash code, as for a
+// MAC. Like Sum, it sorts the sums.
+func (ts *tarSum) SumMultihash(extra []byte) ([]byte, error) {
+	code, ok := multihashCodes[ts.th.Name()]
+	if !ok {
+		return nil, fmt.Errorf("tarsum: hash %q has no multihash code", ts.th.Name())
+	}
+	digest := ts.sumDigest(extra)
+	mh := make([]byte, 0, 2*binary.MaxVarintLen64+len(digest))
+	var buf [binary.MaxVarintLen64]byte
+	mh = append(mh, buf[:binary.PutUvarint(buf[:], code)]...)
+	mh = append(mh, buf[:binary.PutUvarint(buf[:], uint64(len(digest)))]...)
+	return append(mh, digest...), nil
+}
@@ -0,0 +1,61 @@
+package tarsum
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSumMultihash(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+
+	testCases := []struct {
+		th   THash
+		code uint64
+	}{
+		{DefaultTHash, 0x12},
+		{NewTHash("sha512", sha512.New), 0x13},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumHash(bytes.NewReader(archive), true, Version1, testCase.th)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			t.Fatal(err)
+		}
+		mh, err := ts.(MultihashTarSum).SumMultihash(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		code, n := binary.Uvarint(mh)
+		if n <= 0 || code != testCase.code {
+			t.Errorf("Mismatched multihash code for %s\n\tActual: %#x\n\tExpected: %#x", testCase.th.Name(), code, testCase.code)
+			continue
+		}
+		mh = mh[n:]
+		length, n := binary.Uvarint(mh)
+		if n <= 0 || length != uint64(len(mh[n:])) {
+			t.Errorf("Mismatched multihash length for %s\n\tActual: %d\n\tExpected: %d", testCase.th.Name(), length, len(mh[n:]))
+			continue
+		}
+		sum := ts.Sum(nil)
+		if actual, expected := hex.EncodeToString(mh[n:]), sum[strings.Index(sum, ":")+1:]; actual != expected {
+			t.Errorf("Mismatched multihash digest for %s\n\tActual: %s\n\tExpected: %s", testCase.th.Name(), actual, expected)
+		}
+	}
+
+	ts, err := NewTarSumHash(bytes.NewReader(archive), true, Version1, NewMACTHash(DefaultTHash, []byte("key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.(MultihashTarSum).SumMultihash(nil); err == nil {
+		t.Error("expected an error for a hash without a multihash code")
+	}
+}
@@ -0,0 +1,218 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// GNU tar header types and fields used to continue an archive across
+// volumes, which the tar package does not expose.
+const (
+	gnuTypeVolumeHeader = 'V' // volume label
+	gnuTypeMultiVolume  = 'M' // continuation of a file from the previous volume
+
+	volumeBlockSize       = 512
+	volumeSizeOffset      = 124
+	volumeChecksumOffset  = 148
+	volumeChecksumLen     = 8
+	volumeTypeflagOffset  = 156
+	volumeOffsetOffset    = 369 // start of a continued file's data in this volume
+	volumeIsExtended      = 482 // old GNU sparse header is followed by extensions
+	volumeExtIsExtended   = 504 // a sparse extension is followed by another
+	volumeNumericFieldLen = 12
+)
+
+// NewMultiVolumeTarSum creates a TarSum over an archive stored in several
+// parts, read in order as a single logical archive. The parts may be plain
+// splits of one archive at block boundaries or the volumes of a GNU
+// multi-volume archive: a volume label starting a part after the first is
+// dropped, and a file continued from the previous part through a
+// multi-volume header is joined with its remainder so that it is hashed as
+// one file. Read returns ErrBadVolume if a multi-volume header does not
+// continue the file left unfinished by the previous part. Continuations are
+// tracked from the GNU header fields, so a file whose size is overridden by
+// a PAX record cannot be continued; plain splits are unaffected.
+func NewMultiVolumeTarSum(parts []io.Reader, v Version) (TarSum, error) {
+	return NewTarSumWithOptions(newVolumeReader(parts), v, Options{})
+}
+
+// volumeReader concatenates the parts of a multi-volume archive, block by
+// block, while tracking the entry being read so it can check and remove the
+// headers GNU tar writes at the start of each continuation volume.
+type volumeReader struct {
+	parts []io.Reader
+	part  int
+	err   error
+
+	block [volumeBlockSize]byte
+	out   []byte // unread bytes of the current block
+
+	ended    bool  // the end-of-archive marker has been reached
+	extended bool  // sparse extension headers follow
+	size     int64 // data size of the current entry
+	done     int64 // data bytes of the current entry already read
+}
+
+func newVolumeReader(parts []io.Reader) *volumeReader {
+	return &volumeReader{parts: parts}
+}
+
+func (vr *volumeReader) Read(p []byte) (int, error) {
+	for len(vr.out) == 0 {
+		if vr.err != nil {
+			return 0, vr.err
+		}
+		vr.err = vr.nextBlock()
+	}
+	n := copy(p, vr.out)
+	vr.out = vr.out[n:]
+	return n, nil
+}
+
+// nextBlock reads the next block of the logical archive into vr.out.
+func (vr *volumeReader) nextBlock() error {
+	n := 0
+	start := false
+	for {
+		if vr.part >= len(vr.parts) {
+			if n > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return io.EOF
+		}
+		m, err := io.ReadFull(vr.parts[vr.part], vr.block[n:])
+		n += m
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A part ending within a block is a plain split, which is
+			// continued as it is.
+			vr.part++
+			start = n == 0
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if start && !vr.ended && !vr.extended {
+			skip, err := vr.volumeStart()
+			if err != nil {
+				return err
+			}
+			if skip {
+				// A continuation header may follow a volume label.
+				n = 0
+				start = vr.block[volumeTypeflagOffset] == gnuTypeVolumeHeader
+				continue
+			}
+		}
+		vr.advance()
+		vr.out = vr.block[:]
+		return nil
+	}
+}
+
+// volumeStart checks a block at the start of a part after the first, and
+// reports whether it is a GNU volume header to be dropped from the logical
+// archive. Any other block is continued as for a plain split.
+func (vr *volumeReader) volumeStart() (bool, error) {
+	if !validHeaderChecksum(vr.block[:]) {
+		return false, nil
+	}
+	switch vr.block[volumeTypeflagOffset] {
+	case gnuTypeVolumeHeader:
+		return true, nil
+	case gnuTypeMultiVolume:
+		size, ok1 := parseVolumeNumeric(vr.block[volumeSizeOffset:])
+		offset, ok2 := parseVolumeNumeric(vr.block[volumeOffsetOffset:])
+		if !ok1 || !ok2 || vr.done >= vr.size || offset != vr.done || size != vr.size-vr.done {
+			return false, ErrBadVolume
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// advance updates the entry state for vr.block, the next block of the
+// logical archive.
+func (vr *volumeReader) advance() {
+	switch {
+	case vr.ended:
+	case vr.extended:
+		vr.extended = vr.block[volumeExtIsExtended] != 0
+	case vr.done < vr.size:
+		vr.done += volumeBlockSize
+		if vr.done > vr.size {
+			vr.done = vr.size
+		}
+	case isZeroBlock(vr.block[:]):
+		vr.ended = true
+	default:
+		vr.size, _ = parseVolumeNumeric(vr.block[volumeSizeOffset:])
+		vr.done = 0
+		vr.extended = vr.block[volumeTypeflagOffset] == 'S' && vr.block[volumeIsExtended] != 0
+	}
+}
+
+// validHeaderChecksum reports whether b is a header block with a correct
+// checksum, so that file data is not mistaken for a volume header.
+func validHeaderChecksum(b []byte) bool {
+	want, ok := parseVolumeNumeric(b[volumeChecksumOffset:])
+	if !ok {
+		return false
+	}
+	var sum int64
+	for i, c := range b {
+		if i >= volumeChecksumOffset && i < volumeChecksumOffset+volumeChecksumLen {
+			c = ' '
+		}
+		sum += int64(c)
+	}
+	return sum == want
+}
+
+func isZeroBlock(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseVolumeNumeric parses a numeric header field in octal or in the GNU
+// base-256 encoding.
+func parseVolumeNumeric(b []byte) (int64, bool) {
+	if len(b) > volumeNumericFieldLen {
+		b = b[:volumeNumericFieldLen]
+	}
+	if b[0]&0x80 != 0 {
+		var n int64
+		for i, c := range b {
+			if i == 0 {
+				c &= 0x7f
+			}
+			if n > (1<<63-1)>>8 {
+				return 0, false
+			}
+			n = n<<8 | int64(c)
+		}
+		return n, true
+	}
+
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	s := strings.Trim(string(b), " ")
+	if s == "" {
+		return 0, true
+	}
+	var n int64
+	for _, c := range s {
+		if c < '0' || c > '7' || n > (1<<63-1)>>3 {
+			return 0, false
+		}
+		n = n<<3 | int64(c-'0')
+	}
+	return n, true
+}
@@ -0,0 +1,67 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// gnuVolumeHeader builds a GNU volume label or multi-volume continuation
+// header block.
+func gnuVolumeHeader(name string, typeflag byte, size, offset int64) []byte {
+	header := make([]byte, 512)
+	copy(header, name)
+	copy(header[124:136], fmt.Sprintf("%011o\x00", size))
+	header[156] = typeflag
+	if typeflag == gnuTypeMultiVolume {
+		copy(header[369:381], fmt.Sprintf("%011o\x00", offset))
+	}
+	fixHeaderChecksum(header)
+	return header
+}
+
+func TestMultiVolumeTarSum(t *testing.T) {
+	big := bytes.Repeat([]byte("0123456789"), 300)
+	archive := buildTar(t, regEntry("a", "small"), regEntry("big", string(big)), regEntry("c", "after"))
+	expected := sumArchive(t, archive, Version1)
+
+	// "big" has its header at 1024 and its 3000 bytes of data at 1536.
+	// The first volume ends after 1536 bytes of data and the second
+	// continues the remaining 1464 bytes.
+	split := 1536 + 1536
+	volume2 := append(gnuVolumeHeader("label Volume 2", gnuTypeVolumeHeader, 0, 0), gnuVolumeHeader("big", gnuTypeMultiVolume, 1464, 1536)...)
+	volume2 = append(volume2, archive[split:]...)
+
+	testCases := map[string][][]byte{
+		"gnu multi-volume": {archive[:split], volume2},
+		"block split":      {archive[:split], archive[split:]},
+		"byte split":       {archive[:1000], archive[1000:2500], archive[2500:]},
+		"empty part":       {archive[:split], nil, archive[split:]},
+	}
+	for name, parts := range testCases {
+		readers := make([]io.Reader, len(parts))
+		for i, part := range parts {
+			readers[i] = bytes.NewReader(part)
+		}
+		ts, err := NewMultiVolumeTarSum(readers, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		if sum := ts.Sum(nil); sum != expected {
+			t.Errorf("%s: Mismatched sum\n\tActual: %s\n\tExpected: %s", name, sum, expected)
+		}
+	}
+
+	// A continuation header must match the unfinished file.
+	bad := append(gnuVolumeHeader("big", gnuTypeMultiVolume, 1464, 1024), archive[split:]...)
+	ts, err := NewMultiVolumeTarSum([]io.Reader{bytes.NewReader(archive[:split]), bytes.NewReader(bad)}, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != ErrBadVolume {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, ErrBadVolume)
+	}
+}
@@ -0,0 +1,367 @@
+package tarsum
+
+import (
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// Options holds the optional settings used when creating a TarSum with
+// NewTarSumWithOptions. The zero value matches the behavior of NewTarSum
+// with compression enabled.
+type Options struct {
+	// DisableCompression, when true, causes the re-encoded tar stream
+	// returned by Read to be left uncompressed.
+	DisableCompression bool
+
+	// THash selects the hash used for the checksum. DefaultTHash is used
+	// when nil.
+	THash THash
+
+	// Logger receives debug output. When nil, output is discarded unless
+	// UseGlobalLogger is set.
+	Logger Logger
+
+	// LazyFlush, when true, flushes the compressor only when a step of Read
+	// would otherwise leave no output to return, rather than after every
+	// step. This avoids emitting a gzip sync flush for each chunk of input,
+	// which improves speed and compression ratio, but less of the output is
+	// available to Read until the compressor emits it on its own. It suits
+	// callers which consume the whole output at the end. The checksum is
+	// unaffected.
+	LazyFlush bool
+
+	// FlushPolicy selects when the compressor is flushed in ModeReemit.
+	// The default, FlushAlways, flushes after every step of Read, while
+	// FlushNever may withhold output from Read until the archive ends. It
+	// suits callers buffering the output themselves, such as through a
+	// bufio.Writer. LazyFlush also applies when the policy would flush.
+	// The checksum is unaffected.
+	FlushPolicy FlushPolicy
+
+	// FlushBytes is the number of bytes written to the compressor between
+	// flushes with FlushEveryN. It must be positive for that policy.
+	FlushBytes int64
+
+	// Canonicalize, when true, resets timestamps and ownership on the
+	// headers of the re-encoded tar stream, and writes its PAX headers in a
+	// fixed form, so that archives with identical logical content are
+	// re-emitted as identical bytes by any process. It has no effect
+	// on the checksum, which is always determined by the version's header
+	// selector.
+	Canonicalize bool
+
+	// RejectTrailingData, when true, causes Read to return ErrTrailingData
+	// if any non-zero bytes follow the end-of-archive marker. Zero padding
+	// is always accepted. If the bytes start with a tar header, Read
+	// returns ErrBlockSize instead: the data of an archive whose entries
+	// are padded to blocks larger than 512 bytes can look like the end of
+	// the archive followed by its remaining entries. Such archives cannot
+	// be summed, and Read always returns ErrBlockSize when the padding is
+	// short enough not to look like the end of the archive.
+	RejectTrailingData bool
+
+	// CaptureTrailingData, when true, keeps the bytes following the
+	// end-of-archive marker, such as a detached signature, where
+	// RejectTrailingData would reject them. They are returned by the
+	// TrailingBytes method of TrailingTarSum once Read has returned
+	// io.EOF, and are not summed. ModeReemit does not re-emit them, while
+	// ModePassthrough returns them with the rest of the input. Whole zero
+	// blocks directly after the marker are dropped as the padding of the
+	// archive to its record size. At most MaxTrailingBytes are kept; Read
+	// returns ErrTrailingDataTooLarge if there are more. With
+	// AutoDecompress, they are the bytes following the archive in the
+	// decompressed stream. It cannot be combined with RejectTrailingData.
+	CaptureTrailingData bool
+
+	// ExcludePatterns lists patterns of entry names to leave out of the
+	// checksum entirely; excluded entries are still re-emitted by Read.
+	// Patterns use path.Match syntax against the entry name with any
+	// leading "./" and trailing "/" removed. A pattern without a "/" is
+	// matched against the last element of the name, as in "*.log", and a
+	// pattern ending in "/**" matches everything beneath a directory, as
+	// in "tmp/**". Excluding entries produces a non-standard checksum.
+	ExcludePatterns []string
+
+	// AutoDecompress, when true, detects gzip compressed input and
+	// decompresses it before it is parsed. Concatenated gzip members are
+	// decompressed as a single stream.
+	AutoDecompress bool
+
+	// Decompressors lists further compression formats detected by their
+	// magic numbers with AutoDecompress, ahead of gzip, such as zstd with
+	// ZstdMagic and the reader of a zstd implementation. The checksum is
+	// always that of the decompressed archive.
+	Decompressors []Decompressor
+
+	// Compressor, when non-nil, creates the writer which compresses the
+	// re-encoded tar stream returned by Read in place of gzip, such as a
+	// zstd encoder. It is called with the destination of the compressed
+	// output, and it is not used with DisableCompression. The checksum is
+	// unaffected.
+	Compressor func(w io.Writer) (CompressWriter, error)
+
+	// StrictHeaders, when true, only accepts header checksums computed as
+	// POSIX specifies and causes Read to return ErrHeaderChecksum for any
+	// header whose checksum does not match. Headers with a mismatched
+	// checksum are always rejected, as ErrHeader, by default.
+	StrictHeaders bool
+
+	// RejectEmptyNames, when true, causes Read to return ErrEmptyEntryName
+	// for an entry whose name is empty once any leading "./" and trailing
+	// "/" are removed, such as "./". By default such entries are summed
+	// under the empty name, which GetSums reports like any other.
+	RejectEmptyNames bool
+
+	// StrictSpecialFiles, when true, causes Read to return
+	// ErrSpecialFileData for a character device, block device or fifo
+	// entry which declares a non-zero size, so that data cannot be hidden
+	// in entries which never have any. By default the data of such entries
+	// is hashed like that of any other.
+	StrictSpecialFiles bool
+
+	// RequireFiles lists the names of entries which the archive must
+	// contain. Once the archive has been read, Read returns an
+	// ErrMissingRequiredFile naming the first of them which was not found.
+	// Names are compared with any leading "./" and trailing "/" removed,
+	// and every entry counts, including those left out of the checksum.
+	RequireFiles []string
+
+	// RequireSortedEntries, when true, causes Read to return an
+	// ErrUnsortedEntries if an entry's name sorts before that of the
+	// previous entry, as in a non-reproducible archive. Names are compared
+	// with any leading "./" and trailing "/" removed, element by element,
+	// so that a directory's contents sort directly after it as tar
+	// --sort=name writes them. Entries with equal names are accepted.
+	RequireSortedEntries bool
+
+	// LegacyCompat, when true, reproduces a bug of builds of Docker for
+	// 32-bit platforms, such as i386 and arm: the numeric header fields of
+	// each entry (mode, uid, gid, size, mtime, devmajor and devminor) are
+	// hashed after wrapping them to 32-bit signed integers, so that a file
+	// of 2 GiB or more, a modification time after January 2038 or a large
+	// device number is hashed differently than by a correct
+	// implementation. It is a bug-compatibility mode, which exists only to
+	// verify checksums stored by those builds, and must not be used to
+	// compute new checksums. Archives whose fields all fit in 32 bits sum
+	// as they do without it.
+	LegacyCompat bool
+
+	// TextNormalize, when non-nil, selects the regular files to hash as
+	// text, by their names with any leading "./" and trailing "/" removed.
+	// The body of each selected file is hashed with every CRLF line ending
+	// replaced by LF, and its size is left out of its header hash since the
+	// normalized size is not known until the body has been read. Other
+	// files are hashed as usual. Only the hash is normalized: Read returns
+	// the bodies unchanged, and chunk sums are of the original bodies.
+	// Normalizing produces a non-standard checksum.
+	TextNormalize func(name string) bool
+
+	// HashHeadBytes, when positive, limits the hash of the body of each
+	// file larger than HashHeadBytes to its first HashHeadBytes bytes, for
+	// a quick approximate sum of archives dominated by a few large files.
+	// The declared size is always hashed with the header of such a file,
+	// even with TextNormalize, so its sum still changes with its length,
+	// but changes beyond the head of its body go undetected. This is a
+	// much weaker check than a full sum and must not be used to verify
+	// content. Read returns the bodies unchanged, and chunk sums are of
+	// the whole bodies. Hashing heads produces a non-standard checksum.
+	HashHeadBytes int64
+
+	// PrefetchBytes, when positive, causes the input to be read ahead on a
+	// separate goroutine into at most PrefetchBytes of buffers, so that
+	// waiting for slow input, such as from the network, overlaps with
+	// hashing and re-encoding what has already been read. Reading ahead
+	// stops when Read returns io.EOF or an error, or when the TarSum is
+	// closed through CloseTarSum, but up to PrefetchBytes bytes following
+	// the end of the archive may have been read from the input. A TarSum
+	// abandoned before Read has returned io.EOF or an error must be
+	// closed: otherwise its goroutine blocks forever, keeping the input
+	// and the buffers alive. The checksum and the re-encoded archive are
+	// unaffected, though its compressed form may be flushed at different
+	// points.
+	PrefetchBytes int
+
+	// MaxInputBytes, when positive, limits the number of bytes read from
+	// the input. Read returns ErrInputTooLarge once the input is found to
+	// be longer. With AutoDecompress the limit applies to the compressed
+	// input. Input following the end of the archive which is never read
+	// is not counted. Zero means unlimited.
+	MaxInputBytes int64
+
+	// MaxDistinctNames, when positive, limits the number of distinct entry
+	// names in the archive, as a guard against archives crafted to inflate
+	// the maps keyed by name built from GetSums. Read returns ErrTooManyNames once an
+	// entry would exceed the limit. Entries with a name already read do
+	// not count again, and every entry counts, including those left out of
+	// the checksum. Names are compared with any leading "./" and trailing
+	// "/" removed. Zero means unlimited.
+	MaxDistinctNames int
+
+	// PerFileTimeout, when positive, limits the time spent reading the body
+	// of any single entry from the input. Read returns an ErrFileTimeout
+	// naming the entry once the limit is exceeded. Only the time spent
+	// within Read counts, not the time between calls. Zero means
+	// unlimited.
+	PerFileTimeout time.Duration
+
+	// HeaderTransform, when non-nil, is called with the header of each
+	// entry before it is hashed and re-emitted, and may modify it, for
+	// example to strip a path prefix or remap ownership. Both the checksum
+	// and the output of Read reflect the modified header, and filters are
+	// applied to it. The transform must not change Size; Read returns an
+	// error if it does, as for an error from the transform. Transforming
+	// headers produces a non-standard checksum.
+	HeaderTransform func(*tar.Header) error
+
+	// TypeFilter, when non-nil, lists the entry types to include in the
+	// checksum, keyed by tar Typeflag. Entries of any other type are left
+	// out of the checksum as for ExcludePatterns, and are still re-emitted
+	// by Read. TypeRegA entries are treated as TypeReg. Filtering by type
+	// produces a non-standard checksum.
+	TypeFilter map[byte]bool
+
+	// HashSelector, when non-nil, chooses the hash of each summed file from
+	// its header, such as a fast hash for large media and a strong one for
+	// executables; THash is used for any file it returns nil for. Each
+	// per-file sum is labelled with the name of its hash, as in
+	// "sha512:{hex}", and the labelled sums are aggregated into the
+	// checksum with THash, so that the checksum changes with the hash of
+	// any file. Hash names must not contain a colon. Chunk sums still use
+	// THash, and the labelled sums cannot be re-encoded, so DigestEncoding
+	// must be EncodingHex and PerFileDigestBytes zero. Selecting hashes
+	// produces a non-standard checksum.
+	HashSelector func(h *tar.Header) THash
+
+	// RetainHeaders, when true, keeps a copy of the header of each summed
+	// entry, available through HeaderTarSum.GetHeaders in the order the
+	// entries were processed, along with the offset of each header through
+	// HeaderTarSum.GetOffsets. It is off by default to save memory.
+	RetainHeaders bool
+
+	// StructureSum, when true, records the name, type, mode and size of
+	// each summed entry for StructureTarSum.StructureSum. It is off by
+	// default to save memory.
+	StructureSum bool
+
+	// SkipFirstN, when positive, causes the first SkipFirstN entries of
+	// the archive to be read without being hashed, as when resuming a
+	// verification which has already summed them. ResumeSums holds the
+	// sums recorded for those entries, in archive order, and they are
+	// used in place of hashing. The resulting checksum is only correct if
+	// ResumeSums are exactly the sums a full pass would record for the
+	// skipped entries using the same version, hash and filters; this is
+	// not checked. Skipped entries are still re-emitted by Read.
+	SkipFirstN int64
+	ResumeSums FileInfoSums
+
+	// AuditWriter, when non-nil, receives a line for each file as its sum
+	// is recorded, of the form "pos\tname\tsum\n". The name is quoted as
+	// by strconv.Quote, so that names holding tabs or newlines cannot
+	// forge fields or lines. Each line is written with a single call so
+	// that a persisted record is complete up to the last file processed.
+	// It has no effect on the checksum.
+	AuditWriter io.Writer
+
+	// DigestEncoding selects how per-file sums are rendered by GetSums.
+	// Sums returned in an encoding other than EncodingHex are copies, and
+	// they do not affect the checksum. GetSums, GetSumsWhere and Manifest
+	// are the only places sums are rendered: those written to the
+	// AuditWriter and RecordWriter and those reported in errors are always
+	// hex. ResumeSums must always be hex.
+	DigestEncoding DigestEncoding
+
+	// PerFileDigestBytes, when positive, truncates the per-file sums
+	// returned by GetSums, GetSumsWhere and Manifest to their first
+	// PerFileDigestBytes bytes, such as for compact identifiers in a
+	// manifest; sums written elsewhere are kept in full. It must not
+	// exceed the digest size of the hash. A truncated sum is much more
+	// likely to collide with another: n bytes resist a deliberate
+	// collision only up to about 2^(4n) attempts, so short sums should
+	// identify files rather than secure them. The checksum is always
+	// aggregated from the full sums, so it is unaffected.
+	PerFileDigestBytes int
+
+	// RecordTimings, when true, accumulates the time spent in each phase
+	// of Read, available through TimingTarSum.GetTimings. It is a
+	// diagnostic and is off by default to avoid the cost of reading the
+	// clock.
+	RecordTimings bool
+
+	// CollectErrors, when true, causes recoverable errors to be recorded,
+	// available through ErrorTarSum.Errors, rather than returned by Read,
+	// so that processing continues. Recoverable errors are those which do
+	// not depend on the state of the input stream: failures writing a
+	// file's record to the AuditWriter or RecordWriter, and ErrTrailingData
+	// when RejectTrailingData is set. Malformed headers, read errors and
+	// failures writing the output always abort. The checksum is computed
+	// as usual, but it should be treated as best-effort if any errors were
+	// collected.
+	CollectErrors bool
+
+	// ChunkSize, when positive, causes the body of each summed file larger
+	// than ChunkSize to also be hashed in consecutive chunks of ChunkSize
+	// bytes, the last of which may be shorter, available through
+	// ChunkTarSum.GetChunkSums along with the chunked sum of the file
+	// derived from them, as ChunkedFileSum describes. The per-file sums
+	// and the checksum are unaffected. Chunk sums are non-standard.
+	ChunkSize int64
+
+	// Descriptors, when true, also digests the body of each summed regular
+	// file with sha256, available as OCI-style descriptors through
+	// DescriptorTarSum.Descriptors. Bodies are digested as read, before
+	// any TextNormalize or HashHeadBytes. It has no effect on the
+	// checksum.
+	Descriptors bool
+
+	// RecordWriter, when non-nil, receives a FileRecord encoded as a line
+	// of JSON for each file as its sum is recorded. Each line is written
+	// with a single call. It has no effect on the checksum.
+	RecordWriter io.Writer
+
+	// WeakSum, when true, also computes an Adler-32 checksum of the raw
+	// input, available through WeakTarSum.WeakSum, for quick mismatch
+	// detection before comparing checksums. It has no effect on the
+	// checksum.
+	WeakSum bool
+
+	// TrailingManifest, when true, causes Read to follow the output of the
+	// Mode with ManifestMarker and a Manifest of the per-file sums and the
+	// checksum once the archive has been read, so that both can be sent in
+	// a single stream. In ModeDigestOnly only the marker and the manifest
+	// are returned. SplitManifest separates the two sections.
+	TrailingManifest bool
+
+	// ContentDigest, when true, also computes a sha256 digest of the raw
+	// input, such as an OCI content digest, available through
+	// ContentTarSum.ContentDigest. Any input following the end of the
+	// archive is read so that the digest covers every byte. It has no
+	// effect on the checksum.
+	ContentDigest bool
+
+	// Mode selects what Read returns. The default, ModeReemit, returns
+	// the re-encoded archive.
+	Mode Mode
+}
+
+// Logger is the interface used by a TarSum to emit debug output.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+}
+
+// UseGlobalLogger, when set, causes TarSums created without an explicit
+// Logger to log through the package-global logrus logger.
+var UseGlobalLogger = false
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+func defaultLogger() Logger {
+	if UseGlobalLogger {
+		return log.StandardLogger()
+	}
+	return nopLogger{}
+}
@@ -0,0 +1,180 @@
+package tarsum
+
+import (
+	"encoding/hex"
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// ParallelOptions bounds the resources used by SumParallel.
+type ParallelOptions struct {
+	// Workers is the number of files hashed concurrently. When zero,
+	// runtime.GOMAXPROCS(0) workers are used.
+	Workers int
+	// MaxBufferBytes bounds the file bodies read ahead of the workers.
+	// A file larger than MaxBufferBytes is hashed as it is read, without
+	// being buffered. When zero, 64 MiB are used.
+	MaxBufferBytes int64
+}
+
+// parallelJob is a file whose body has been read ahead to be hashed by a
+// worker.
+type parallelJob struct {
+	pos  int
+	hdr  *tar.Header
+	body []byte
+}
+
+// SumParallel computes the checksum and the per-file sums of the archive
+// read from r, as a TarSum of the version would in ModeDigestOnly, while
+// hashing several files at once. The archive is parsed sequentially and the
+// bodies of the files are read ahead into buffers bounded by
+// popts.MaxBufferBytes, to be hashed by popts.Workers goroutines. The sums
+// are returned in archive order and the checksum is identical to that of the
+// serial path. It only speeds up archives of many large files.
+func SumParallel(r io.Reader, v Version, popts ParallelOptions) (string, FileInfoSums, error) {
+	ts, err := newTarSumOptions(r, v, Options{Mode: ModeDigestOnly})
+	if err != nil {
+		return "", nil, err
+	}
+	workers := popts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	maxBuffer := popts.MaxBufferBytes
+	if maxBuffer <= 0 {
+		maxBuffer = 64 << 20
+	}
+	budget := newByteBudget(maxBuffer)
+
+	var (
+		mu    sync.Mutex
+		names []string
+		types []byte
+		sums  []string // the sum of each file by position, once hashed
+		wg    sync.WaitGroup
+		jobs  = make(chan parallelJob, workers)
+	)
+	setSum := func(pos int, sum string) {
+		mu.Lock()
+		sums[pos] = sum
+		mu.Unlock()
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				setSum(job.pos, ts.sumFile(job.hdr, job.body))
+				budget.release(int64(len(job.body)))
+			}
+		}()
+	}
+
+	err = func() error {
+		defer close(jobs)
+		for {
+			hdr, err := ts.tarR.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			ts.beginEntry(hdr)
+			if ts.global {
+				// Global records are aggregated rather than summed.
+				if _, err := io.Copy(&ts.globalData, ts.tarR); err != nil {
+					return err
+				}
+				if err := ts.finishGlobal(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			mu.Lock()
+			pos := len(sums)
+			names = append(names, ts.currentFile)
+			types = append(types, hdr.Typeflag)
+			sums = append(sums, "")
+			mu.Unlock()
+
+			if hdr.Size > maxBuffer {
+				// Too large to buffer, so hash it as it is read.
+				h := ts.th.Hash()
+				ts.writeHeaders(h, hdr)
+				if _, err := io.Copy(h, ts.tarR); err != nil {
+					return err
+				}
+				setSum(pos, hex.EncodeToString(h.Sum(nil)))
+				continue
+			}
+			budget.acquire(hdr.Size)
+			body := make([]byte, hdr.Size)
+			if _, err := io.ReadFull(ts.tarR, body); err != nil {
+				return err
+			}
+			jobs <- parallelJob{pos: pos, hdr: hdr, body: body}
+		}
+	}()
+	wg.Wait()
+	if err != nil {
+		return "", nil, err
+	}
+
+	for pos, name := range names {
+		ts.sums = append(ts.sums, fileInfoSum{name: name, sum: sums[pos], pos: int64(pos), typeflag: types[pos]})
+	}
+	fileSums := append(FileInfoSums(nil), ts.sums...)
+	return ts.Sum(nil), fileSums, nil
+}
+
+// sumFile returns the per-file sum of a file with the given header and body.
+func (ts *tarSum) sumFile(hdr *tar.Header, body []byte) string {
+	h := ts.th.Hash()
+	ts.writeHeaders(h, hdr)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeHeaders hashes the selected headers of hdr into h.
+func (ts *tarSum) writeHeaders(h hash.Hash, hdr *tar.Header) {
+	writeEntryHeaders(h, ts.tarSumVersion, ts.headerSelector.selectHeaders(hdr), hdr.Size)
+}
+
+// byteBudget bounds the number of bytes held in buffers at once.
+type byteBudget struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	max  int64
+	used int64
+}
+
+func newByteBudget(max int64) *byteBudget {
+	b := &byteBudget{max: max}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire waits until n more bytes fit within the budget. n must not exceed
+// the budget.
+func (b *byteBudget) acquire(n int64) {
+	b.mu.Lock()
+	for b.used+n > b.max {
+		b.cond.Wait()
+	}
+	b.used += n
+	b.mu.Unlock()
+}
+
+func (b *byteBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
@@ -0,0 +1,78 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func parallelArchive(t testing.TB, files, size int) []byte {
+	entries := []testEntry{{header: &tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}}
+	for i := 0; i < files; i++ {
+		entries = append(entries, regEntry(fmt.Sprintf("dir/file%d", i), string(bytes.Repeat([]byte{byte('a' + i%26)}, size+i))))
+	}
+	return buildTar(t, entries...)
+}
+
+func TestSumParallel(t *testing.T) {
+	archive := parallelArchive(t, 20, 64*1024)
+	withGlobal := buildTar(t, globalEntry(paxRecord("comment", "one")), regEntry("a", "1"), regEntry("b", "2"))
+
+	testCases := []struct {
+		archive []byte
+		v       Version
+		popts   ParallelOptions
+	}{
+		{archive, Version1, ParallelOptions{}},
+		{archive, Version0, ParallelOptions{Workers: 3, MaxBufferBytes: 200 * 1024}},
+		// Every file is larger than the buffer and is hashed as it is read.
+		{archive, Version1, ParallelOptions{Workers: 2, MaxBufferBytes: 1024}},
+		{withGlobal, VersionGlobalPAX, ParallelOptions{Workers: 2}},
+	}
+	for _, testCase := range testCases {
+		sum, sums, err := SumParallel(bytes.NewReader(testCase.archive), testCase.v, testCase.popts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ts, err := NewTarSum(bytes.NewReader(testCase.archive), true, testCase.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			t.Fatal(err)
+		}
+		expectedSums := append(FileInfoSums(nil), ts.GetSums()...)
+		if expected := ts.Sum(nil); sum != expected {
+			t.Errorf("Mismatched sum for %s with %+v\n\tActual: %s\n\tExpected: %s", testCase.v, testCase.popts, sum, expected)
+		}
+		if !reflect.DeepEqual(sums, expectedSums) {
+			t.Errorf("Mismatched file sums for %s with %+v\n\tActual: %v\n\tExpected: %v", testCase.v, testCase.popts, sums, expectedSums)
+		}
+	}
+
+	if _, _, err := SumParallel(bytes.NewReader(archive[:len(archive)/2]), Version1, ParallelOptions{}); err == nil {
+		t.Error("expected an error for a truncated archive")
+	}
+}
+
+func benchmarkSumParallel(b *testing.B, workers int) {
+	archive := parallelArchive(b, 16, 4<<20)
+	b.SetBytes(int64(len(archive)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := SumParallel(bytes.NewReader(archive), Version1, ParallelOptions{Workers: workers}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSumParallel1(b *testing.B) { benchmarkSumParallel(b, 1) }
+func BenchmarkSumParallel4(b *testing.B) { benchmarkSumParallel(b, 4) }
+func BenchmarkSumParallel(b *testing.B)  { benchmarkSumParallel(b, 0) }
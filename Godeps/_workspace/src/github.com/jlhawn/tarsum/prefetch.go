@@ -0,0 +1,126 @@
+package tarsum
+
+import (
+	"io"
+	"sync"
+)
+
+// CloseTarSum extends TarSum with releasing its resources before the
+// archive has been read to the end. All TarSums created by this package
+// implement it.
+type CloseTarSum interface {
+	TarSum
+	io.Closer
+}
+
+// Close stops the TarSum, after which Read returns ErrClosed. With
+// Options.PrefetchBytes it stops reading ahead from the input; a read from
+// the input in progress is left to complete in the background, but nothing
+// more is read. It is not needed once Read has returned io.EOF or an error,
+// which stop reading ahead themselves, and it may be called more than once.
+// Close only closes the input if it was given to NewTarSumReadCloser, in
+// which case the error of closing it is returned unless it has already been
+// closed.
+func (ts *tarSum) Close() error {
+	ts.closed = true
+	ts.stopPrefetch()
+	return ts.closeSource()
+}
+
+// stopPrefetch stops the goroutine reading the input ahead, if there is one.
+func (ts *tarSum) stopPrefetch() {
+	if ts.prefetch != nil {
+		ts.prefetch.stop()
+	}
+}
+
+// prefetchChunkSize is the largest read made from the input ahead of Read.
+const prefetchChunkSize = buf32K
+
+// prefetchChunk is a read made from the input ahead of Read.
+type prefetchChunk struct {
+	buf []byte
+	err error
+}
+
+// prefetchReader reads from r on a separate goroutine, ahead of its own
+// Read, into chunks whose total size is bounded, so that waiting for the
+// input overlaps with processing what has already been read.
+type prefetchReader struct {
+	chunks   chan prefetchChunk
+	free     chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+	buf      []byte // the buffer of the chunk being read
+	cur      []byte // the unread bytes of buf
+	err      error
+}
+
+// newPrefetchReader starts reading r ahead into at most limit bytes of
+// buffers.
+func newPrefetchReader(r io.Reader, limit int) *prefetchReader {
+	size := prefetchChunkSize
+	if limit < size {
+		size = limit
+	}
+	n := limit / size
+	pr := &prefetchReader{
+		chunks: make(chan prefetchChunk, n),
+		free:   make(chan []byte, n),
+		done:   make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		pr.free <- make([]byte, size)
+	}
+	go pr.readAhead(r)
+	return pr
+}
+
+func (pr *prefetchReader) readAhead(r io.Reader) {
+	for {
+		var buf []byte
+		select {
+		case buf = <-pr.free:
+		case <-pr.done:
+			return
+		}
+		n, err := r.Read(buf[:cap(buf)])
+		select {
+		case pr.chunks <- prefetchChunk{buf: buf[:n], err: err}:
+		case <-pr.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (pr *prefetchReader) Read(p []byte) (int, error) {
+	for len(pr.cur) == 0 {
+		if pr.err != nil {
+			return 0, pr.err
+		}
+		select {
+		case c := <-pr.chunks:
+			pr.buf, pr.cur, pr.err = c.buf, c.buf, c.err
+			if len(c.buf) == 0 {
+				pr.free <- c.buf
+			}
+		case <-pr.done:
+			return 0, ErrClosed
+		}
+	}
+	n := copy(p, pr.cur)
+	pr.cur = pr.cur[n:]
+	if len(pr.cur) == 0 {
+		// The chunk has been consumed, so its buffer can be refilled.
+		pr.free <- pr.buf
+	}
+	return n, nil
+}
+
+// stop stops reading ahead. Chunks already read are discarded.
+func (pr *prefetchReader) stop() {
+	pr.stopOnce.Do(func() { close(pr.done) })
+}
@@ -0,0 +1,145 @@
+package tarsum
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// latentReader delays each read from r, as a high-latency input would.
+type latentReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (lr *latentReader) Read(p []byte) (int, error) {
+	time.Sleep(lr.delay)
+	return lr.r.Read(p)
+}
+
+// waitForGoroutines waits for the number of goroutines to fall to n,
+// reporting whether it did.
+func waitForGoroutines(n int) bool {
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPrefetch(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", strings.Repeat("b", 100*1024)), regEntry("c", strings.Repeat("c", 5000)))
+	run := func(opts Options, r io.Reader) (string, []byte) {
+		ts, err := NewTarSumWithOptions(r, Version1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := readAllSizes(t, ts, 7000)
+		return ts.Sum(nil), out
+	}
+	// Compressed output is flushed as the input arrives, so the re-encoded
+	// archive is compared uncompressed.
+	expectedSum, expectedOut := run(Options{DisableCompression: true}, bytes.NewReader(archive))
+
+	goroutines := runtime.NumGoroutine()
+	testCases := []struct {
+		limit int
+		r     io.Reader
+	}{
+		{1, bytes.NewReader(archive)},
+		{1000, bytes.NewReader(archive)},
+		{prefetchChunkSize, bytes.NewReader(archive)},
+		{1 << 20, bytes.NewReader(archive)},
+		// One byte reads from the input leave chunks partly filled.
+		{1000, &oneByteReader{bytes.NewReader(archive)}},
+	}
+	for _, testCase := range testCases {
+		sum, out := run(Options{DisableCompression: true, PrefetchBytes: testCase.limit}, testCase.r)
+		if sum != expectedSum {
+			t.Errorf("Mismatched sums with %d prefetch bytes\n\tActual: %s\n\tExpected: %s", testCase.limit, sum, expectedSum)
+		}
+		if !bytes.Equal(out, expectedOut) {
+			t.Errorf("expected the output with %d prefetch bytes to match", testCase.limit)
+		}
+	}
+	if !waitForGoroutines(goroutines) {
+		t.Errorf("expected reading ahead to stop once the archive was read: %d goroutines", runtime.NumGoroutine())
+	}
+
+	// Errors from the input are returned, and stop reading ahead.
+	errRead := errors.New("read failed")
+	ts, err := NewTarSumWithOptions(io.MultiReader(bytes.NewReader(archive[:1536]), &failingReader{errRead}), Version1, Options{PrefetchBytes: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != errRead {
+		t.Errorf("Mismatched errors\n\tActual: %v\n\tExpected: %v", err, errRead)
+	}
+	if !waitForGoroutines(goroutines) {
+		t.Errorf("expected reading ahead to stop after an error: %d goroutines", runtime.NumGoroutine())
+	}
+
+	// Closing stops reading ahead before the archive has been read.
+	ts, err = NewTarSumWithOptions(&latentReader{r: bytes.NewReader(archive), delay: time.Millisecond}, Version1, Options{PrefetchBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Read(make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.(CloseTarSum).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Read(make([]byte, 512)); err != ErrClosed {
+		t.Errorf("Mismatched errors after Close\n\tActual: %v\n\tExpected: %v", err, ErrClosed)
+	}
+	if !waitForGoroutines(goroutines) {
+		t.Errorf("expected reading ahead to stop after Close: %d goroutines", runtime.NumGoroutine())
+	}
+}
+
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return o.r.Read(p)
+}
+
+type failingReader struct {
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	return 0, f.err
+}
+
+func benchmarkPrefetch(b *testing.B, prefetch int) {
+	archive := buildTar(b, regEntry("blob", strings.Repeat("0123456789abcdef", 1<<16)))
+	b.SetBytes(int64(len(archive)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Each 32 KiB read from the input takes 200µs to arrive.
+		r := &latentReader{r: bytes.NewReader(archive), delay: 200 * time.Microsecond}
+		ts, err := NewTarSumWithOptions(r, Version1, Options{PrefetchBytes: prefetch})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLatentInput(b *testing.B)         { benchmarkPrefetch(b, 0) }
+func BenchmarkLatentInputPrefetch(b *testing.B) { benchmarkPrefetch(b, 1<<20) }
@@ -0,0 +1,29 @@
+package tarsum
+
+import "io"
+
+// NewTarSumReadCloser creates a new TarSum, as NewTarSum does, which reads
+// the archive from rc and closes it once it is no longer needed: when Read
+// returns io.EOF or an error, when Drain stops early, on Reset, or on Close,
+// whichever comes first. Closing the TarSum closes rc, so a caller sure to
+// either drain or close the TarSum, as with an HTTP response body, need not
+// close rc itself. It is closed at most once.
+func NewTarSumReadCloser(rc io.ReadCloser, dc bool, v Version) (CloseTarSum, error) {
+	ts, err := newTarSum(rc, dc, v)
+	if err != nil {
+		return nil, err
+	}
+	ts.source = rc
+	return ts, nil
+}
+
+// closeSource closes the source given to NewTarSumReadCloser, if there is
+// one which has not been closed yet.
+func (ts *tarSum) closeSource() error {
+	if ts.source == nil {
+		return nil
+	}
+	source := ts.source
+	ts.source = nil
+	return source.Close()
+}
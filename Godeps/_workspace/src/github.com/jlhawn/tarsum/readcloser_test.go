@@ -0,0 +1,107 @@
+package tarsum
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// countingCloser counts the calls to its Close method.
+type countingCloser struct {
+	io.Reader
+	closes int
+	err    error
+}
+
+func (c *countingCloser) Close() error {
+	c.closes++
+	return c.err
+}
+
+func TestNewTarSumReadCloser(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+	expected := sumArchive(t, archive, Version1)
+	errRead := errors.New("read failed")
+
+	testCases := []struct {
+		name   string
+		r      io.Reader
+		expect error
+	}{
+		{"drained", bytes.NewReader(archive), nil},
+		{"failed", io.MultiReader(bytes.NewReader(archive[:1024]), &failingReader{errRead}), errRead},
+	}
+	for _, testCase := range testCases {
+		rc := &countingCloser{Reader: testCase.r}
+		ts, err := NewTarSumReadCloser(rc, true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != testCase.expect {
+			t.Errorf("%s: Mismatched errors\n\tActual: %v\n\tExpected: %v", testCase.name, err, testCase.expect)
+		}
+		if testCase.expect == nil {
+			if sum := ts.Sum(nil); sum != expected {
+				t.Errorf("%s: Mismatched sums\n\tActual: %s\n\tExpected: %s", testCase.name, sum, expected)
+			}
+		}
+		if err := ts.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if rc.closes != 1 {
+			t.Errorf("%s: expected the source to be closed once, got %d", testCase.name, rc.closes)
+		}
+	}
+
+	// Closing before the archive has been read closes the source, and
+	// returns its error.
+	errClose := errors.New("close failed")
+	rc := &countingCloser{Reader: bytes.NewReader(archive), err: errClose}
+	ts, err := NewTarSumReadCloser(rc, true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Read(make([]byte, 512)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Close(); err != errClose {
+		t.Errorf("Mismatched errors from Close\n\tActual: %v\n\tExpected: %v", err, errClose)
+	}
+	if err := ts.Close(); err != nil || rc.closes != 1 {
+		t.Errorf("expected the source to be closed once, got %d and %v", rc.closes, err)
+	}
+
+	// Drain closes the source when its context is done, and Reset before
+	// summing another archive.
+	rc = &countingCloser{Reader: bytes.NewReader(archive)}
+	if ts, err = NewTarSumReadCloser(rc, true, Version1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Drain(ctx, ts, nil); err != context.Canceled {
+		t.Errorf("Mismatched errors from Drain\n\tActual: %v\n\tExpected: %v", err, context.Canceled)
+	}
+	if rc.closes != 1 {
+		t.Errorf("expected Drain to close the source once, got %d", rc.closes)
+	}
+	rc = &countingCloser{Reader: bytes.NewReader(archive)}
+	if ts, err = NewTarSumReadCloser(rc, true, Version1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.(ResetTarSum).Reset(bytes.NewReader(archive)); err != nil {
+		t.Fatal(err)
+	}
+	if rc.closes != 1 {
+		t.Errorf("expected Reset to close the source once, got %d", rc.closes)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+	if sum := ts.Sum(nil); sum != expected {
+		t.Errorf("Mismatched sums after Reset\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
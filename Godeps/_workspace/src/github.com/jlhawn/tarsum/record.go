@@ -0,0 +1,30 @@
+package tarsum
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// FileRecord describes a summed file, as written to Options.RecordWriter.
+type FileRecord struct {
+	Name     string `json:"name"`
+	Sum      string `json:"sum"`
+	Size     int64  `json:"size"`
+	Typeflag string `json:"typeflag"`
+	Pos      int64  `json:"pos"`
+}
+
+func (ts *tarSum) writeRecord(fis fileInfoSum) error {
+	record := FileRecord{
+		Name:     fis.name,
+		Sum:      fis.sum,
+		Size:     ts.currentSize,
+		Typeflag: string([]byte{ts.currentType}),
+		Pos:      fis.pos,
+	}
+	// Encode writes the record and its newline with a single call.
+	if err := json.NewEncoder(ts.opts.RecordWriter).Encode(record); err != nil {
+		return fmt.Errorf("tarsum: writing file record: %v", err)
+	}
+	return nil
+}
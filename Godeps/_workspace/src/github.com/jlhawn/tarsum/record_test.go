@@ -0,0 +1,50 @@
+package tarsum
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestRecordWriter(t *testing.T) {
+	link := testEntry{header: &tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "a", ModTime: time.Unix(1400000000, 0)}}
+	archive := buildTar(t, regEntry("a", "one"), link, regEntry("b", "three"))
+
+	var records bytes.Buffer
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{RecordWriter: &records})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+
+	expected := []FileRecord{
+		{Name: "a", Size: 3, Typeflag: "0"},
+		{Name: "link", Size: 0, Typeflag: "2"},
+		{Name: "b", Size: 5, Typeflag: "0"},
+	}
+	sums := ts.GetSums()
+	dec := json.NewDecoder(&records)
+	for i := range expected {
+		expected[i].Sum, expected[i].Pos = sums[i].Sum(), sums[i].Pos()
+
+		var record FileRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		if record != expected[i] {
+			t.Errorf("Mismatched record %d\n\tActual: %+v\n\tExpected: %+v", i, record, expected[i])
+		}
+	}
+	var extra FileRecord
+	if err := dec.Decode(&extra); err != io.EOF {
+		t.Errorf("expected %d records, found more: %+v", len(expected), extra)
+	}
+
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
@@ -0,0 +1,50 @@
+package tarsum
+
+import "io"
+
+// ReproReport describes the outcome of summing the same archive twice.
+type ReproReport struct {
+	// First and Second are the checksums of the two passes.
+	First  string
+	Second string
+	// Files compares the per-file sums of the second pass with those of
+	// the first, which are its expected sums.
+	Files *FileVerifyResult
+}
+
+// Identical reports whether both passes produced the same checksum and the
+// same per-file sums.
+func (r *ReproReport) Identical() bool {
+	return r.First == r.Second && r.Files.Match()
+}
+
+// ReproCheck sums the archive read from rs twice, seeking back to its
+// starting position between the passes, and reports any differences between
+// them. Summing is deterministic, so the passes always agree unless the
+// input changes between them or reading it is faulty; it is a
+// self-consistency check for pipelines which may be nondeterministic. An
+// error is only returned if the archive cannot be read or rewound;
+// differences are reported through the result.
+func ReproCheck(rs io.ReadSeeker, v Version) (*ReproReport, error) {
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	first, err := DigestArchive(rs, v)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	second, err := DigestArchive(rs, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReproReport{
+		First:  first.Sum,
+		Second: second.Sum,
+		Files:  compareFileSums(second.Sums, first.Sums),
+	}, nil
+}
@@ -0,0 +1,70 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// faultySeeker flips a byte at offset the second time the input is read
+// from its start.
+type faultySeeker struct {
+	*bytes.Reader
+	offset int64
+	passes int
+}
+
+func (fs *faultySeeker) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekStart && offset == 0 {
+		fs.passes++
+	}
+	return fs.Reader.Seek(offset, whence)
+}
+
+func (fs *faultySeeker) Read(p []byte) (int, error) {
+	pos, _ := fs.Reader.Seek(0, io.SeekCurrent)
+	n, err := fs.Reader.Read(p)
+	if fs.passes > 0 && pos <= fs.offset && fs.offset < pos+int64(n) {
+		p[fs.offset-pos] ^= 0xff
+	}
+	return n, err
+}
+
+func TestReproCheck(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "first"), regEntry("b", "second"), regEntry("c", "third"))
+
+	report, err := ReproCheck(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Identical() {
+		t.Errorf("expected identical passes: %+v", report)
+	}
+	if expected := sumArchive(t, archive, Version1); report.First != expected {
+		t.Errorf("Mismatched checksum\n\tActual: %s\n\tExpected: %s", report.First, expected)
+	}
+
+	// Corrupt the body of b, in the fourth block, on the second pass.
+	report, err = ReproCheck(&faultySeeker{Reader: bytes.NewReader(archive), offset: 3*512 + 1}, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Identical() || report.First == report.Second {
+		t.Errorf("expected the passes to differ: %+v", report)
+	}
+	want := &FileVerifyResult{Matched: []string{"a", "c"}, Modified: []string{"b"}}
+	if !reflect.DeepEqual(report.Files, want) {
+		t.Errorf("Mismatched file differences\n\tActual: %+v\n\tExpected: %+v", report.Files, want)
+	}
+
+	// The passes start from the initial position.
+	rs := bytes.NewReader(append(make([]byte, 512), archive...))
+	rs.Seek(512, io.SeekStart)
+	if report, err = ReproCheck(rs, Version1); err != nil {
+		t.Fatal(err)
+	}
+	if !report.Identical() || report.First != sumArchive(t, archive, Version1) {
+		t.Errorf("expected identical passes over the archive: %+v", report)
+	}
+}
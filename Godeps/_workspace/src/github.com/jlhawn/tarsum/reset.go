@@ -0,0 +1,69 @@
+package tarsum
+
+import "io"
+
+// ResetTarSum extends TarSum with reuse for another archive. All TarSums
+// created by this package implement it.
+type ResetTarSum interface {
+	TarSum
+	Reset(r io.Reader) error
+}
+
+// Reset discards the state of the TarSum and prepares it to sum the archive
+// read from r, with the same version, hash and options, as if it had been
+// newly created. The buffers holding input and output are kept, with their
+// contents discarded, so that a TarSum reused for many archives does not
+// reallocate them. Results returned before Reset, such as the slice returned
+// by GetSums, are not affected by it. A source given to NewTarSumReadCloser
+// is closed first, if it has not been already, and r is not closed by the
+// TarSum.
+func (ts *tarSum) Reset(r io.Reader) error {
+	ts.stopPrefetch()
+	if err := ts.closeSource(); err != nil {
+		return err
+	}
+	ts.bufTar.Reset()
+	ts.bufWriter.Reset()
+	*ts = tarSum{
+		Reader:             r,
+		DisableCompression: ts.DisableCompression,
+		tarSumVersion:      ts.tarSumVersion,
+		headerSelector:     ts.headerSelector,
+		th:                 ts.th,
+		logger:             ts.logger,
+		opts:               ts.opts,
+		bufTar:             ts.bufTar,
+		bufWriter:          ts.bufWriter,
+		bufData:            ts.bufData,
+	}
+	return ts.initTarSum()
+}
+
+// CloneTarSum extends TarSum with creating another TarSum configured like
+// it. All TarSums created by this package implement it.
+type CloneTarSum interface {
+	TarSum
+	Clone(r io.Reader) (TarSum, error)
+}
+
+// Clone returns a new TarSum for the archive read from r, with the same
+// version, hash and options, as if it had been newly created. Unlike Reset
+// it leaves the TarSum unchanged, and the clone allocates buffers of its
+// own, so that the two can be used at once. The progress of the TarSum is
+// not copied, and the clone does not close a source given to
+// NewTarSumReadCloser. Writers and functions given in Options are shared.
+func (ts *tarSum) Clone(r io.Reader) (TarSum, error) {
+	c := &tarSum{
+		Reader:             r,
+		DisableCompression: ts.DisableCompression,
+		tarSumVersion:      ts.tarSumVersion,
+		headerSelector:     ts.headerSelector,
+		th:                 ts.th,
+		logger:             ts.logger,
+		opts:               ts.opts,
+	}
+	if err := c.initTarSum(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,107 @@
+package tarsum
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReset(t *testing.T) {
+	first := buildTar(t, regEntry("a", "one"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 100*1024))))
+	second := buildTar(t, regEntry("c", "three"))
+
+	for _, dc := range []bool{true, false} {
+		ts, err := newTarSum(bytes.NewReader(first), dc, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		firstOut, _ := readAllSizes(t, ts, buf32K)
+		firstSum := ts.Sum(nil)
+		firstSums := ts.GetSums()
+		bufData, bufTar, bufWriter := ts.bufData, ts.bufTar, ts.bufWriter
+
+		if err := ts.Reset(bytes.NewReader(second)); err != nil {
+			t.Fatal(err)
+		}
+		secondOut, _ := readAllSizes(t, ts, buf32K)
+		if &ts.bufData[0] != &bufData[0] || ts.bufTar != bufTar || ts.bufWriter != bufWriter {
+			t.Errorf("expected the buffers to be reused after Reset with compression disabled %t", dc)
+		}
+		if actual, expected := ts.Sum(nil), sumArchive(t, second, Version1); actual != expected {
+			t.Errorf("Mismatched sum after Reset\n\tActual: %s\n\tExpected: %s", actual, expected)
+		}
+		if len(ts.GetSums()) != 1 || len(firstSums) != 2 {
+			t.Errorf("expected 1 file sum after Reset and 2 before, got %d and %d", len(ts.GetSums()), len(firstSums))
+		}
+
+		// A fresh TarSum produces the same output for the second archive,
+		// and resetting back to the first reproduces its results.
+		fresh, err := newTarSum(bytes.NewReader(second), dc, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if freshOut, _ := readAllSizes(t, fresh, buf32K); !bytes.Equal(secondOut, freshOut) {
+			t.Errorf("expected the output after Reset to match a new TarSum with compression disabled %t", dc)
+		}
+		if err := ts.Reset(bytes.NewReader(first)); err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := readAllSizes(t, ts, buf32K); !bytes.Equal(out, firstOut) || ts.Sum(nil) != firstSum {
+			t.Errorf("expected resetting to the first archive to reproduce its results with compression disabled %t", dc)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	first := buildTar(t, regEntry("a", "one"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 100*1024))))
+	second := buildTar(t, regEntry("c", string(bytes.Repeat([]byte{'c'}, 100*1024))))
+
+	for _, dc := range []bool{true, false} {
+		expected, err := newTarSum(bytes.NewReader(first), dc, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Compressed output depends on the sizes of the reads, so the
+		// original is read as the expected output is.
+		expectedHead := make([]byte, 4096)
+		n, err := expected.Read(expectedHead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedRest, _ := readAllSizes(t, expected, buf32K)
+		expectedOut := append(expectedHead[:n], expectedRest...)
+
+		// The clone is read to the end while the original is part way
+		// through its archive.
+		ts, err := newTarSum(bytes.NewReader(first), dc, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		head := make([]byte, 4096)
+		n, err = ts.Read(head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clone, err := ts.Clone(bytes.NewReader(second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c := clone.(*tarSum); c.bufTar == ts.bufTar || c.bufWriter == ts.bufWriter {
+			t.Errorf("expected the clone to have buffers of its own with compression disabled %t", dc)
+		}
+		readAllSizes(t, clone, buf32K)
+		if c := clone.(*tarSum); &c.bufData[0] == &ts.bufData[0] {
+			t.Errorf("expected the clone to have a data buffer of its own with compression disabled %t", dc)
+		}
+		if actual, expectedSum := clone.Sum(nil), sumArchive(t, second, Version1); actual != expectedSum {
+			t.Errorf("Mismatched sum of the clone\n\tActual: %s\n\tExpected: %s", actual, expectedSum)
+		}
+
+		rest, _ := readAllSizes(t, ts, buf32K)
+		if out := append(head[:n], rest...); !bytes.Equal(out, expectedOut) {
+			t.Errorf("expected the clone not to change the output of the original with compression disabled %t", dc)
+		}
+		if actual, expectedSum := ts.Sum(nil), expected.Sum(nil); actual != expectedSum {
+			t.Errorf("Mismatched sum of the original\n\tActual: %s\n\tExpected: %s", actual, expectedSum)
+		}
+	}
+}
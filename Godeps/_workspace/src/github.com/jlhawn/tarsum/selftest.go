@@ -0,0 +1,103 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// selfTestVectors holds the expected checksum of the archive built by
+// selfTestArchive for each built-in version. A version whose headers or
+// aggregation change must have its vector updated deliberately.
+var selfTestVectors = map[Version]string{
+	Version0:          "tarsum+sha256:e4f049fe65e5ee6cef35ed499e3caadbeb0bf4902fd7028ddfb4fd4f824fc57d",
+	Version1:          "tarsum.v1+sha256:558421ec0096559d34a5f4ecb02b54b8c84d23dac5a8473d18d9ec5a0ced6a4a",
+	VersionDev:        "tarsum.dev+sha256:558421ec0096559d34a5f4ecb02b54b8c84d23dac5a8473d18d9ec5a0ced6a4a",
+	VersionMtimeNano:  "tarsum.mtimenano+sha256:0361a94c50098d7573de98ea94a028e2f64bbeebbde92c09844f79223de71f6f",
+	VersionCleanLinks: "tarsum.cleanlinks+sha256:558421ec0096559d34a5f4ecb02b54b8c84d23dac5a8473d18d9ec5a0ced6a4a",
+	VersionWhiteout:   "tarsum.whiteout+sha256:558421ec0096559d34a5f4ecb02b54b8c84d23dac5a8473d18d9ec5a0ced6a4a",
+	VersionSeparated:  "tarsum.separated+sha256:0b0d8298de2ea4b51c53cb95b3f6a3628ebfb66d7ef2957b4af972edd17a4e90",
+	VersionGlobalPAX:  "tarsum.globalpax+sha256:558421ec0096559d34a5f4ecb02b54b8c84d23dac5a8473d18d9ec5a0ced6a4a",
+	VersionNoMode:     "tarsum.nomode+sha256:803479da227c9715a11998b9bd0db5281c8f2a73e2fd45eca6f86ae2bba0fff8",
+	VersionHardened:   "tarsum.hardened+sha256:24357cb09eec7494f557d7cc81e8466de1efbb0e0e9a2d14e0e3d91b73fc75d5",
+	VersionTypedNames: "tarsum.typednames+sha256:558421ec0096559d34a5f4ecb02b54b8c84d23dac5a8473d18d9ec5a0ced6a4a",
+}
+
+// selfTestArchive builds a small reference archive covering a directory, a
+// regular file with extended attributes and a sub-second mtime, an empty
+// file, a symlink and a device node.
+func selfTestArchive() ([]byte, error) {
+	mtime := time.Unix(1400000000, 123456789)
+	headers := []struct {
+		hdr  *tar.Header
+		data string
+	}{
+		{&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: mtime}, ""},
+		{&tar.Header{
+			Name: "etc/motd", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, Gid: 1000,
+			Uname: "user", Gname: "group", ModTime: mtime,
+			Xattrs: map[string]string{"user.comment": "greeting"},
+		}, "hello, world\n"},
+		{&tar.Header{Name: "etc/empty", Typeflag: tar.TypeReg, Mode: 0600, ModTime: mtime}, ""},
+		{&tar.Header{Name: "etc/issue", Typeflag: tar.TypeSymlink, Linkname: "motd", Mode: 0777, ModTime: mtime}, ""},
+		{&tar.Header{Name: "dev/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3, Mode: 0666, ModTime: mtime}, ""},
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range headers {
+		h.hdr.Size = int64(len(h.data))
+		if err := tw.WriteHeader(h.hdr); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(tw, h.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SelfTest computes the checksum of a small built-in reference archive with
+// each built-in version and compares it against a known value, returning an
+// error naming the first version which does not match. Applications may call
+// it at startup to detect a broken build or vendored copy of this package.
+func SelfTest() error {
+	archive, err := selfTestArchive()
+	if err != nil {
+		return fmt.Errorf("tarsum: self-test: building reference archive: %v", err)
+	}
+
+	versions := GetVersions()
+	sort.Sort(versionsByValue(versions))
+	for _, v := range versions {
+		expected, ok := selfTestVectors[v]
+		if !ok {
+			return fmt.Errorf("tarsum: self-test: no reference checksum for %s", v)
+		}
+		ts, err := newTarSum(bytes.NewReader(archive), true, v)
+		if err != nil {
+			return fmt.Errorf("tarsum: self-test: %s: %v", v, err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			return fmt.Errorf("tarsum: self-test: %s: %v", v, err)
+		}
+		if sum := ts.Sum(nil); sum != expected {
+			return fmt.Errorf("tarsum: self-test: %s: got checksum %s, want %s", v, sum, expected)
+		}
+	}
+	return nil
+}
+
+type versionsByValue []Version
+
+func (v versionsByValue) Len() int           { return len(v) }
+func (v versionsByValue) Less(i, j int) bool { return v[i] < v[j] }
+func (v versionsByValue) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
@@ -0,0 +1,24 @@
+package tarsum
+
+import (
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	if err := SelfTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range GetVersions() {
+		if _, ok := selfTestVectors[v]; !ok {
+			t.Errorf("no self-test vector for %s", v)
+		}
+	}
+
+	saved := selfTestVectors[Version1]
+	defer func() { selfTestVectors[Version1] = saved }()
+	selfTestVectors[Version1] = "tarsum.v1+sha256:0"
+	if err := SelfTest(); err == nil {
+		t.Error("expected an error for a mismatched vector")
+	}
+}
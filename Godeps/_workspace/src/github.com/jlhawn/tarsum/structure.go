@@ -0,0 +1,73 @@
+package tarsum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// StructureTarSum extends TarSum with a fingerprint of the layout of the
+// archive. All TarSums created by this package implement it, but only those
+// created with Options.StructureSum record the layout.
+type StructureTarSum interface {
+	TarSum
+	StructureSum() string
+}
+
+// structureEntry holds the header fields of a file covered by StructureSum.
+type structureEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	size     int64
+}
+
+// byStructure is a sort.Sort helper for sorting structure entries by name,
+// and by their other fields when names are the same.
+type byStructure []structureEntry
+
+func (bs byStructure) Len() int      { return len(bs) }
+func (bs byStructure) Swap(i, j int) { bs[i], bs[j] = bs[j], bs[i] }
+func (bs byStructure) Less(i, j int) bool {
+	a, b := bs[i], bs[j]
+	switch {
+	case a.name != b.name:
+		return a.name < b.name
+	case a.typeflag != b.typeflag:
+		return a.typeflag < b.typeflag
+	case a.mode != b.mode:
+		return a.mode < b.mode
+	}
+	return a.size < b.size
+}
+
+// recordStructure records the header fields of the current file for
+// StructureSum.
+func (ts *tarSum) recordStructure(h *tar.Header) {
+	ts.structure = append(ts.structure, structureEntry{name: ts.currentFile, typeflag: h.Typeflag, mode: h.Mode, size: h.Size})
+}
+
+// StructureSum returns a fingerprint of the layout of the files summed so
+// far: a hash of the name, type, mode and declared size of each of them,
+// sorted by name, ignoring their contents and every other header field. It
+// changes when a file is added, removed, renamed, retyped, resized or has
+// its mode changed, but not when only its contents change, so it is a
+// cheap check for layout changes alongside the checksum. Names are taken
+// with any leading "./" and trailing "/" removed. It is labelled like a
+// checksum but it is not comparable with one. Without Options.StructureSum
+// nothing is recorded and it returns "".
+func (ts *tarSum) StructureSum() string {
+	if !ts.opts.StructureSum {
+		return ""
+	}
+	entries := append(byStructure(nil), ts.structure...)
+	sort.Sort(entries)
+	h := ts.th.Hash()
+	for _, e := range entries {
+		// The length of the name keeps the records unambiguous.
+		fmt.Fprintf(h, "%d %s %c %o %d\n", len(e.name), e.name, e.typeflag, e.mode, e.size)
+	}
+	return ts.Version().String() + "+" + ts.th.Name() + ":" + hex.EncodeToString(h.Sum(nil))
+}
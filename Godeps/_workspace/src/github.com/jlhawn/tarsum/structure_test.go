@@ -0,0 +1,70 @@
+package tarsum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestStructureSum(t *testing.T) {
+	dir := func(name string) testEntry {
+		e := regEntry(name, "")
+		e.header.Typeflag = tar.TypeDir
+		e.header.Mode = 0755
+		return e
+	}
+	structure := func(entries ...testEntry) (string, string) {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(buildTar(t, entries...)), Version1, Options{DisableCompression: true, StructureSum: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		return ts.(StructureTarSum).StructureSum(), ts.Sum(nil)
+	}
+
+	base, baseSum := structure(dir("etc/"), regEntry("etc/passwd", "root:x:0:0"), regEntry("bin", "binary"))
+	if !strings.HasPrefix(base, "tarsum.v1+sha256:") || base == baseSum {
+		t.Errorf("Mismatched structure sum label: %s", base)
+	}
+
+	// The contents change, but not the layout.
+	changed, changedSum := structure(dir("etc/"), regEntry("etc/passwd", "user:x:1:1"), regEntry("bin", "BINARY"))
+	if changed != base {
+		t.Errorf("Mismatched structure sums of changed contents\n\tActual: %s\n\tExpected: %s", changed, base)
+	}
+	if changedSum == baseSum {
+		t.Error("expected the contents to change the checksum")
+	}
+	// The order of the entries does not matter.
+	if reordered, _ := structure(regEntry("bin", "binary"), regEntry("etc/passwd", "root:x:0:0"), dir("./etc")); reordered != base {
+		t.Errorf("Mismatched structure sums of reordered entries\n\tActual: %s\n\tExpected: %s", reordered, base)
+	}
+
+	chmod := regEntry("bin", "binary")
+	chmod.header.Mode = 0755
+	layouts := map[string][]testEntry{
+		"renamed": {dir("etc/"), regEntry("etc/shadow", "root:x:0:0"), regEntry("bin", "binary")},
+		"resized": {dir("etc/"), regEntry("etc/passwd", "root:x:0:0"), regEntry("bin", "binary!")},
+		"chmod":   {dir("etc/"), regEntry("etc/passwd", "root:x:0:0"), chmod},
+		"retyped": {dir("etc/"), regEntry("etc/passwd", "root:x:0:0"), dir("bin")},
+		"added":   {dir("etc/"), regEntry("etc/passwd", "root:x:0:0"), regEntry("bin", "binary"), regEntry("new", "")},
+		"removed": {dir("etc/"), regEntry("etc/passwd", "root:x:0:0")},
+	}
+	for desc, entries := range layouts {
+		if sum, _ := structure(entries...); sum == base {
+			t.Errorf("expected the %s layout to change the structure sum", desc)
+		}
+	}
+
+	// The layout is only recorded when asked for.
+	ts, err := NewTarSum(bytes.NewReader(buildTar(t, layouts["added"]...)), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+	if sum := ts.(StructureTarSum).StructureSum(); sum != "" || ts.(*tarSum).structure != nil {
+		t.Errorf("expected no structure to be recorded without Options.StructureSum, got %q", sum)
+	}
+}
@@ -0,0 +1,92 @@
+package tarsum
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// SubtreeSum drains the archive read from r and returns the checksum of the
+// entries beneath the directory named by prefix, as if that directory were
+// the root of the archive. The result equals the checksum of an archive
+// holding only those entries, with the same headers and in the same order,
+// so a directory's contents can be verified wherever it is placed.
+//
+// The prefix is matched with any leading "./" and trailing "/" removed, as
+// are the names of the entries. An entry is beneath it if its name, so
+// normalized, starts with the prefix and a "/"; its name is then replaced
+// by the rest of the name, keeping any trailing "/". The entry of the
+// directory itself is not included. The target of a hard link beneath the
+// prefix is renamed in the same way; symlink targets are left as they are.
+// An empty prefix, or ".", names the whole archive. With VersionGlobalPAX,
+// the records of global PAX headers are aggregated as for the archive.
+func SubtreeSum(r io.Reader, prefix string, v Version) (string, error) {
+	ts, err := newTarSum(nil, true, v)
+	if err != nil {
+		return "", err
+	}
+	root := entryName(prefix)
+	if root == "." {
+		root = ""
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		ts.global = v == VersionGlobalPAX && hdr.Typeflag == tar.TypeXGlobalHeader
+		ts.skip = ts.global
+		if ts.global {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return "", err
+			}
+			ts.captureGlobal(data)
+		} else {
+			name, ok := subtreeName(hdr.Name, root)
+			if !ok {
+				continue
+			}
+			hdr.Name = name
+			if hdr.Typeflag == tar.TypeLink {
+				if target, ok := subtreeName(hdr.Linkname, root); ok {
+					hdr.Linkname = target
+				}
+			}
+			ts.currentFile, ts.currentType = entryName(hdr.Name), hdr.Typeflag
+			if err := ts.encodeHeader(hdr); err != nil {
+				return "", err
+			}
+			if _, err := io.Copy(ts.h, tr); err != nil {
+				return "", err
+			}
+		}
+		if err := ts.finishFile(); err != nil {
+			return "", err
+		}
+	}
+
+	return ts.Sum(nil), nil
+}
+
+// subtreeName returns the name of an entry relative to the directory root,
+// or false if it is not beneath it.
+func subtreeName(name, root string) (string, bool) {
+	if root == "" {
+		return name, true
+	}
+	rest := strings.TrimPrefix(name, "./")
+	if !strings.HasPrefix(rest, root+"/") {
+		return "", false
+	}
+	rest = rest[len(root)+1:]
+	return rest, rest != ""
+}
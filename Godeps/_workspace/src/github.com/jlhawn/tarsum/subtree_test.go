@@ -0,0 +1,75 @@
+package tarsum
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestSubtreeSum(t *testing.T) {
+	dir := func(name string) testEntry {
+		return testEntry{header: &tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir, ModTime: time.Unix(1400000000, 0)}}
+	}
+	link := func(name, target string, typeflag byte) testEntry {
+		return testEntry{header: &tar.Header{Name: name, Linkname: target, Mode: 0777, Typeflag: typeflag, ModTime: time.Unix(1400000000, 0)}}
+	}
+	subtree := func(root string) []testEntry {
+		return []testEntry{
+			dir(root + "lib/"),
+			regEntry(root+"lib/libc.so", "library"),
+			regEntry(root+"bin/sh", "shell"),
+			link(root+"bin/bash", root+"bin/sh", tar.TypeLink),
+			link(root+"bin/rbash", "bash", tar.TypeSymlink),
+		}
+	}
+	entries := []testEntry{dir("./"), dir("./etc/"), regEntry("./etc/motd", "hello"), dir("./usr/")}
+	entries = append(entries, subtree("./usr/")...)
+	entries = append(entries, regEntry("./usrlocal/file", "not beneath usr"))
+	archive := buildTar(t, entries...)
+
+	// The same subtree, at the top level and placed elsewhere.
+	expected := sumArchive(t, buildTar(t, subtree("")...), Version1)
+	moved := buildTar(t, append([]testEntry{dir("opt/"), dir("opt/usr/")}, subtree("opt/usr/")...)...)
+
+	testCases := []struct {
+		archive []byte
+		prefix  string
+	}{
+		{archive, "usr"},
+		{archive, "./usr/"},
+		{archive, "usr/"},
+		{moved, "opt/usr"},
+	}
+	for _, testCase := range testCases {
+		sum, err := SubtreeSum(bytes.NewReader(testCase.archive), testCase.prefix, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != expected {
+			t.Errorf("Mismatched sums of %q\n\tActual: %s\n\tExpected: %s", testCase.prefix, sum, expected)
+		}
+	}
+
+	for _, prefix := range []string{"", "."} {
+		sum, err := SubtreeSum(bytes.NewReader(archive), prefix, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if whole := sumArchive(t, archive, Version1); sum != whole {
+			t.Errorf("Mismatched sums of %q\n\tActual: %s\n\tExpected: %s", prefix, sum, whole)
+		}
+	}
+
+	sum, err := SubtreeSum(bytes.NewReader(archive), "missing", Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty := sumArchive(t, buildTar(t), Version1); sum != empty {
+		t.Errorf("Mismatched sums of a missing subtree\n\tActual: %s\n\tExpected: %s", sum, empty)
+	}
+	if _, err := SubtreeSum(bytes.NewReader(archive[:1000]), "usr", Version1); err == nil {
+		t.Error("expected an error for a truncated archive")
+	}
+}
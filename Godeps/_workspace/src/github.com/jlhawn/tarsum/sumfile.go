@@ -0,0 +1,59 @@
+package tarsum
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// SumFile computes the checksum of the tar archive stored at path. Regular
+// files are memory-mapped where the platform supports it and their size fits
+// in an int, and read through a buffer otherwise; both produce the same
+// checksum as streaming the archive through a TarSum. Files named with a
+// ".gz" or ".tgz" extension are decompressed before being summed.
+func SumFile(path string, v Version) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	var r io.Reader
+	if fi.Mode().IsRegular() && fi.Size() > 0 && int64(int(fi.Size())) == fi.Size() {
+		if data, unmap, err := mmapFile(f, fi.Size()); err == nil {
+			defer unmap()
+			r = bytes.NewReader(data)
+		}
+	}
+	if r == nil {
+		r = bufio.NewReaderSize(f, buf32K)
+	}
+
+	if strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	ts, err := newTarSum(r, true, v)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return "", err
+	}
+
+	return ts.Sum(nil), nil
+}
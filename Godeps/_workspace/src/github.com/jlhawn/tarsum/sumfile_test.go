@@ -0,0 +1,91 @@
+package tarsum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempArchive(t testing.TB, name string, data []byte) string {
+	dir, err := ioutil.TempDir("", "tarsum-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSumFile(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "hello"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 70*1024))))
+	expected := sumArchive(t, archive, Version1)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(archive)
+	gz.Close()
+
+	for name, data := range map[string][]byte{"layer.tar": archive, "layer.tar.gz": gzipped.Bytes()} {
+		path := writeTempArchive(t, name, data)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		sum, err := SumFile(path, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != expected {
+			t.Errorf("Mismatched sum for %s\n\tActual: %s\n\tExpected: %s", name, sum, expected)
+		}
+	}
+}
+
+// benchmarkArchive writes a 64 MiB archive to a temporary file for the
+// SumFile benchmarks, returning its path and size.
+func benchmarkArchive(b *testing.B) (string, int64) {
+	archive := buildTar(b, regEntry("big", string(bytes.Repeat([]byte{'x'}, 64*1024*1024))))
+	return writeTempArchive(b, "layer.tar", archive), int64(len(archive))
+}
+
+func BenchmarkSumFile(b *testing.B) {
+	path, size := benchmarkArchive(b)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := SumFile(path, Version1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSumFileStreaming is the baseline for BenchmarkSumFile, streaming
+// the same file through a TarSum.
+func BenchmarkSumFileStreaming(b *testing.B) {
+	path, size := benchmarkArchive(b)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		ts, err := NewTarSumWithOptions(f, Version1, Options{Mode: ModeDigestOnly})
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, ts)
+		}
+		f.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
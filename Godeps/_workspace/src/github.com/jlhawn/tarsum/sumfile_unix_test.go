@@ -0,0 +1,48 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package tarsum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// A named pipe is not a regular file, so SumFile reads it through a buffer
+// rather than mapping it.
+func TestSumFileFIFO(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "hello"), regEntry("b", string(make([]byte, 70*1024))))
+	dir, err := ioutil.TempDir("", "tarsum-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "layer.tar")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		t.Skipf("cannot create a named pipe: %v", err)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err == nil {
+			_, err = f.Write(archive)
+			f.Close()
+		}
+		written <- err
+	}()
+
+	sum, err := SumFile(path, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if expected := sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum of a named pipe\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
@@ -5,13 +5,15 @@ import (
 	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"hash"
+	"hash/adler32"
 	"io"
-	"strings"
+	"io/ioutil"
+	"path"
+	"time"
 
 	"github.com/jlhawn/tarsum/archive/tar"
-
-	log "github.com/Sirupsen/logrus"
 )
 
 const (
@@ -26,35 +28,244 @@ const (
 // This is used for calculating checksums of layers of an image, in some cases
 // including the byte payload of the image's json metadata as well, and for
 // calculating the checksums for buildcache.
+func NewTarSum(r io.Reader, dc bool, v Version) (TarSum, error) {
+	return newTarSum(r, dc, v)
+}
+
+// NewTarSumHash creates a new TarSum, providing a THash to use rather than
+// the DefaultTHash.
+func NewTarSumHash(r io.Reader, dc bool, v Version, th THash) (TarSum, error) {
+	return newTarSumHash(r, dc, v, th)
+}
+
+// NewTarSumWithOptions creates a new TarSum configured by the given Options.
+func NewTarSumWithOptions(r io.Reader, v Version, opts Options) (TarSum, error) {
+	return newTarSumOptions(r, v, opts)
+}
+
+// NewTarSumFromReader creates a new TarSum which reads the entries of an
+// archive from tr, such as a reader positioned by other tar-processing code,
+// rather than parsing a raw stream of its own. It consumes tr from its
+// current position: the remainder of the current entry, if any, is skipped,
+// and the checksum covers only the entries which follow. Options which act
+// on the raw stream are unavailable, so the output of Read is compressed as
+// with NewTarSum, and data following the end of the archive is not checked.
+func NewTarSumFromReader(tr *tar.Reader, v Version) (TarSum, error) {
+	ts, err := newTarSumOptions(nil, v, Options{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+		return nil, err
+	}
+	ts.tarR = tr
+	return ts, nil
+}
+
 func newTarSum(r io.Reader, dc bool, v Version) (*tarSum, error) {
-	return newTarSumHash(r, dc, v, defaultTHash)
+	return newTarSumHash(r, dc, v, DefaultTHash)
+}
+
+func newTarSumHash(r io.Reader, dc bool, v Version, th THash) (*tarSum, error) {
+	return newTarSumOptions(r, v, Options{DisableCompression: dc, THash: th})
 }
 
-// Create a new TarSum, providing a THash to use rather than the DefaultTHash
-func newTarSumHash(r io.Reader, dc bool, v Version, th tHash) (*tarSum, error) {
+func newTarSumOptions(r io.Reader, v Version, opts Options) (*tarSum, error) {
 	headerSelector, err := getTarHeaderSelector(v)
 	if err != nil {
 		return nil, err
 	}
-	ts := &tarSum{Reader: r, DisableCompression: dc, tarSumVersion: v, headerSelector: headerSelector, th: th}
+	if opts.LegacyCompat {
+		headerSelector = legacyHeaderSelector{headerSelector}
+	}
+	if opts.THash != nil {
+		if err := validateTHash(opts.THash); err != nil {
+			return nil, err
+		}
+	}
+	for _, pattern := range opts.ExcludePatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("tarsum: invalid exclude pattern %q: %v", pattern, err)
+		}
+	}
+	if opts.SkipFirstN < 0 || int64(len(opts.ResumeSums)) > opts.SkipFirstN {
+		return nil, fmt.Errorf("tarsum: %d resumed sums cannot be for %d skipped entries", len(opts.ResumeSums), opts.SkipFirstN)
+	}
+	if opts.ChunkSize < 0 {
+		return nil, fmt.Errorf("tarsum: invalid chunk size %d", opts.ChunkSize)
+	}
+	if opts.HashHeadBytes < 0 {
+		return nil, fmt.Errorf("tarsum: invalid head size %d", opts.HashHeadBytes)
+	}
+	if opts.CaptureTrailingData && opts.RejectTrailingData {
+		return nil, fmt.Errorf("tarsum: trailing data cannot be both captured and rejected")
+	}
+	switch opts.Mode {
+	case ModeReemit, ModePassthrough, ModeDigestOnly:
+	default:
+		return nil, fmt.Errorf("tarsum: unknown mode %v", opts.Mode)
+	}
+	switch opts.FlushPolicy {
+	case FlushAlways, FlushNever:
+	case FlushEveryN:
+		if opts.FlushBytes <= 0 {
+			return nil, fmt.Errorf("tarsum: invalid flush interval %d", opts.FlushBytes)
+		}
+	default:
+		return nil, fmt.Errorf("tarsum: unknown flush policy %v", opts.FlushPolicy)
+	}
+	if opts.PerFileDigestBytes != 0 {
+		th := opts.THash
+		if th == nil {
+			th = DefaultTHash
+		}
+		if size := th.Hash().Size(); opts.PerFileDigestBytes < 0 || opts.PerFileDigestBytes > size {
+			return nil, fmt.Errorf("tarsum: invalid per-file digest length %d for %d byte %s digests", opts.PerFileDigestBytes, size, th.Name())
+		}
+	}
+	if opts.HashSelector != nil && (opts.DigestEncoding != EncodingHex || opts.PerFileDigestBytes != 0) {
+		return nil, fmt.Errorf("tarsum: per-file sums labelled by HashSelector cannot be re-encoded")
+	}
+	switch opts.DigestEncoding {
+	case EncodingHex, EncodingBase64URL, EncodingBase32:
+	default:
+		return nil, fmt.Errorf("tarsum: unknown digest encoding %v", opts.DigestEncoding)
+	}
+	ts := &tarSum{Reader: r, DisableCompression: opts.DisableCompression, tarSumVersion: v, headerSelector: headerSelector, th: opts.THash, logger: opts.Logger, opts: opts}
 	err = ts.initTarSum()
 	return ts, err
 }
 
+// TarSum is the generic interface for calculating fixed time
+// checksums of a tar archive
+type TarSum interface {
+	io.Reader
+	// GetSums returns the per-file sums computed so far. The list is only
+	// complete once Read has returned io.EOF; calling Sum is not required.
+	// Entries are in archive order until Sum is called, which sorts them
+	// in place by sum.
+	GetSums() FileInfoSums
+	Sum([]byte) string
+	Version() Version
+	Hash() THash
+}
+
+// FormatTarSum extends TarSum with the tar formats detected in the archive.
+// All TarSums created by this package implement it.
+type FormatTarSum interface {
+	TarSum
+	// Format returns the union of the formats of the entries read so far.
+	// It does not affect the checksum.
+	Format() tar.Format
+}
+
+// FeatureTarSum extends TarSum with the tar format extensions used by the
+// archive. All TarSums created by this package implement it.
+type FeatureTarSum interface {
+	TarSum
+	// Features returns the extensions used by the headers read so far.
+	// It does not affect the checksum.
+	Features() tar.Features
+}
+
+// PreimageTarSum extends TarSum with the bytes hashed to compute the
+// checksum. All TarSums created by this package implement it.
+type PreimageTarSum interface {
+	TarSum
+	SumPreimage(extra []byte) []byte
+}
+
+// FilterTarSum extends TarSum with access to a subset of the per-file sums.
+// All TarSums created by this package implement it.
+type FilterTarSum interface {
+	TarSum
+	GetSumsWhere(pred func(name string) bool) FileInfoSums
+}
+
+// ContentTarSum extends TarSum with a digest of the raw input. TarSums
+// created with Options.ContentDigest implement it.
+type ContentTarSum interface {
+	TarSum
+	// ContentDigest returns the sha256 digest of the input, before any
+	// decompression, in the form "sha256:<hex>". It is only complete
+	// once Read has returned io.EOF.
+	ContentDigest() string
+}
+
+// WeakTarSum extends TarSum with a weak Adler-32 checksum of the raw input.
+// TarSums created with Options.WeakSum implement it.
+type WeakTarSum interface {
+	TarSum
+	// WeakSum returns the Adler-32 checksum of the input bytes read so
+	// far, before any decompression. It is advisory and non-standard.
+	WeakSum() uint32
+}
+
+// HeaderTarSum extends TarSum with access to the headers of the summed
+// files. TarSums created with Options.RetainHeaders implement it.
+type HeaderTarSum interface {
+	TarSum
+	GetHeaders() []*tar.Header
+	GetOffsets() []int64
+}
+
 // tarSum struct is the structure for a Version0 checksum calculation
 type tarSum struct {
 	io.Reader
 	tarR               *tar.Reader
 	tarW               *tar.Writer
-	writer             writeCloseFlusher
+	writer             CompressWriter
 	bufTar             *bytes.Buffer
 	bufWriter          *bytes.Buffer
 	bufData            []byte
 	h                  hash.Hash
-	th                 tHash
-	sums               fileInfoSums
+	th                 THash
+	logger             Logger
+	opts               Options
+	headers            []*tar.Header                    // retained when opts.RetainHeaders is set
+	offsets            []int64                          // the offsets of the retained headers
+	tarInput           *countingReader                  // counts the input of tarR, with opts.RetainHeaders
+	headerOffset       int64                            // the offset of the current entry's header
+	structure          []structureEntry                 // the header fields of the summed files, for StructureSum
+	fileDone           func(FileInfoSumInterface) error // called as each file's sum is recorded
+	sums               FileInfoSums
 	fileCounter        int64
+	entryCounter       int64      // the number of entries read, including those skipped
+	format             tar.Format // the formats of the entries read
+	errs               []error    // recoverable errors when opts.CollectErrors is set
+	chunker            *chunker   // hashes the chunks of the current file when it is chunked
+	bodyDigest         hash.Hash  // digests the body of the current file when it is described
+	descriptors        []Descriptor
+	chunkSums          map[string]chunkedFile
+	required           map[string]bool // whether each of opts.RequireFiles has been read
+	names              map[string]bool // the distinct entry names read, with opts.MaxDistinctNames
+	text               *crlfNormalizer // hashes the body of the current file when it is normalized as text
+	features           tar.Features    // the format extensions of the entries read
+	weak               hash.Hash32     // sums the raw input when opts.WeakSum is set
+	content            hash.Hash       // digests the raw input when opts.ContentDigest is set
+	rest               io.Reader       // the raw input to drain after the archive for opts.ContentDigest
 	currentFile        string
+	prevFile           string // the name of the previous entry, with opts.RequireSortedEntries
+	currentSize        int64
+	currentType        byte
+	fileElapsed        time.Duration            // time spent reading the current file, with opts.PerFileTimeout
+	skip               bool                     // whether the current file is excluded from the checksum
+	global             bool                     // whether the current entry holds global PAX records to aggregate
+	headOnly           bool                     // whether only the head of the current file's body is hashed, with opts.HashHeadBytes
+	globalData         bytes.Buffer             // the data of the current global PAX entry
+	globalRecords      map[string]string        // the global PAX records aggregated by VersionGlobalPAX
+	raw                io.Reader                // the input tee in ModePassthrough
+	unflushed          int64                    // bytes written to writer since it was last flushed, with FlushEveryN
+	headLeft           int64                    // the bytes of the current file's body left to hash, with headOnly
+	fileHashes         map[string]hash.Hash     // the per-file hashes chosen by opts.HashSelector, by name
+	fileHashName       string                   // the name of the current file's hash, with opts.HashSelector
+	appended           []byte                   // the encoded entries added by AppendEntry and not yet read
+	inputEnded         bool                     // whether the archive read from the input has ended
+	trailing           []byte                   // the bytes following the archive, with opts.CaptureTrailingData
+	timings            map[string]time.Duration // accumulated when opts.RecordTimings is set
+	prefetch           *prefetchReader          // reads the input ahead, with opts.PrefetchBytes
+	source             io.Closer                // closed once the archive has been read, with NewTarSumReadCloser
+	closed             bool
 	finished           bool
 	first              bool
 	DisableCompression bool              // false by default. When false, the output gzip compressed.
@@ -62,7 +273,7 @@ type tarSum struct {
 	headerSelector     tarHeaderSelector // handles selecting and ordering headers for files in the archive
 }
 
-func (ts tarSum) Hash() tHash {
+func (ts tarSum) Hash() THash {
 	return ts.th
 }
 
@@ -70,19 +281,19 @@ func (ts tarSum) Version() Version {
 	return ts.tarSumVersion
 }
 
-// A hash.Hash type generator and its name
-type tHash interface {
+// THash is a hash.Hash type generator and its name
+type THash interface {
 	Hash() hash.Hash
 	Name() string
 }
 
-// Convenience method for creating a THash
-func newTHash(name string, h func() hash.Hash) tHash {
+// NewTHash is a convenience method for creating a THash
+func NewTHash(name string, h func() hash.Hash) THash {
 	return simpleTHash{n: name, h: h}
 }
 
-// TarSum default is "sha256"
-var defaultTHash = newTHash("sha256", sha256.New)
+// DefaultTHash is the TarSum default, "sha256"
+var DefaultTHash = NewTHash("sha256", sha256.New)
 
 type simpleTHash struct {
 	n string
@@ -92,140 +303,827 @@ type simpleTHash struct {
 func (sth simpleTHash) Name() string    { return sth.n }
 func (sth simpleTHash) Hash() hash.Hash { return sth.h() }
 
-func (ts *tarSum) encodeHeader(h *tar.Header) error {
-	for _, elem := range ts.headerSelector.selectHeaders(h) {
-		if _, err := ts.h.Write([]byte(elem[0] + elem[1])); err != nil {
-			return err
+// validateTHash checks that th is named and that it produces a usable hash,
+// converting a panic from its Hash method into ErrInvalidTHash.
+func validateTHash(th THash) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = ErrInvalidTHash
 		}
+	}()
+
+	if th.Name() == "" || th.Hash() == nil {
+		return ErrInvalidTHash
 	}
 	return nil
 }
 
+func (ts *tarSum) encodeHeader(h *tar.Header) error {
+	headers := ts.headerSelector.selectHeaders(h)
+	size := h.Size
+	if ts.text != nil && !ts.headOnly {
+		// The size of a normalized body is not known in advance.
+		selected := headers[:0:0]
+		for _, elem := range headers {
+			if elem[0] != "size" {
+				selected = append(selected, elem)
+			}
+		}
+		headers, size = selected, -1
+	}
+	var w io.Writer = ts.h
+	if ts.chunker != nil {
+		w = io.MultiWriter(ts.h, ts.chunker.sum)
+	}
+	return writeEntryHeaders(w, ts.tarSumVersion, headers, size)
+}
+
+// copyHeader returns a copy of h which shares no state with it.
+func copyHeader(h *tar.Header) *tar.Header {
+	c := *h
+	if h.Xattrs != nil {
+		c.Xattrs = make(map[string]string, len(h.Xattrs))
+		for k, v := range h.Xattrs {
+			c.Xattrs[k] = v
+		}
+	}
+	return &c
+}
+
+// canonicalHeader returns a copy of h with the fields which do not describe
+// the logical content of an entry (timestamps and ownership) reset to fixed
+// values.
+func canonicalHeader(h *tar.Header) *tar.Header {
+	c := *h
+	c.ModTime = time.Unix(0, 0)
+	c.AccessTime = time.Time{}
+	c.ChangeTime = time.Time{}
+	c.Uid, c.Gid = 0, 0
+	c.Uname, c.Gname = "", ""
+	return &c
+}
+
 func (ts *tarSum) initTarSum() error {
-	ts.bufTar = bytes.NewBuffer([]byte{})
-	ts.bufWriter = bytes.NewBuffer([]byte{})
-	ts.tarR = tar.NewReader(ts.Reader)
-	ts.tarW = tar.NewWriter(ts.bufTar)
-	if !ts.DisableCompression {
-		ts.writer = gzip.NewWriter(ts.bufWriter)
+	if ts.bufTar == nil {
+		ts.bufTar = bytes.NewBuffer([]byte{})
+		ts.bufWriter = bytes.NewBuffer([]byte{})
+	}
+	if ts.opts.PrefetchBytes > 0 {
+		// Read the raw input ahead, before anything else consumes it.
+		ts.prefetch = newPrefetchReader(ts.Reader, ts.opts.PrefetchBytes)
+		ts.Reader = ts.prefetch
+	}
+	if ts.opts.WeakSum {
+		ts.weak = adler32.New()
+		ts.Reader = io.TeeReader(ts.Reader, ts.weak)
+	}
+	if ts.opts.ContentDigest {
+		ts.content = sha256.New()
+		ts.Reader = io.TeeReader(ts.Reader, ts.content)
+	}
+	if ts.opts.MaxInputBytes > 0 {
+		// Limit the raw input, before any decompression.
+		ts.Reader = &inputLimitReader{r: ts.Reader, n: ts.opts.MaxInputBytes}
+	}
+	if ts.opts.ContentDigest {
+		// The remainder is read once the archive ends so that the digest
+		// covers all of the input.
+		ts.rest = ts.Reader
+	}
+	if ts.opts.Mode == ModePassthrough {
+		// The input is copied to the output as it is consumed.
+		ts.Reader = io.TeeReader(ts.Reader, ts.bufWriter)
+		ts.raw = ts.Reader
+	}
+	if ts.opts.AutoDecompress {
+		r, err := decompressReader(ts.Reader, ts.opts.Decompressors)
+		if err != nil {
+			return err
+		}
+		ts.Reader = r
+	}
+	tarInput := ts.Reader
+	if ts.opts.RetainHeaders {
+		// The offsets of the headers are counted from the bytes the tar
+		// reader consumes.
+		ts.tarInput = &countingReader{r: ts.Reader}
+		tarInput = ts.tarInput
+	}
+	if ts.opts.StrictHeaders {
+		ts.tarR = tar.NewStrictReader(tarInput)
 	} else {
+		ts.tarR = tar.NewReader(tarInput)
+	}
+	switch {
+	case ts.opts.Mode != ModeReemit:
+		ts.tarW = tar.NewWriter(ioutil.Discard)
+		ts.writer = &nopCloseFlusher{Writer: ioutil.Discard}
+	case !ts.DisableCompression && ts.opts.Compressor != nil:
+		w, err := ts.opts.Compressor(ts.bufWriter)
+		if err != nil {
+			return err
+		}
+		ts.tarW = tar.NewWriter(ts.bufTar)
+		ts.writer = w
+	case !ts.DisableCompression:
+		ts.tarW = tar.NewWriter(ts.bufTar)
+		ts.writer = gzip.NewWriter(ts.bufWriter)
+	default:
+		ts.tarW = tar.NewWriter(ts.bufTar)
 		ts.writer = &nopCloseFlusher{Writer: ts.bufWriter}
 	}
+	ts.tarW.Deterministic = ts.opts.Canonicalize
 	if ts.th == nil {
-		ts.th = defaultTHash
+		ts.th = DefaultTHash
+	}
+	if ts.logger == nil {
+		ts.logger = defaultLogger()
+	}
+	if ts.opts.ChunkSize > 0 {
+		ts.chunkSums = make(map[string]chunkedFile)
+	}
+	if len(ts.opts.RequireFiles) > 0 {
+		ts.required = make(map[string]bool, len(ts.opts.RequireFiles))
+		for _, name := range ts.opts.RequireFiles {
+			ts.required[entryName(name)] = false
+		}
+	}
+	if ts.opts.RecordTimings {
+		ts.timings = make(map[string]time.Duration)
 	}
 	ts.h = ts.th.Hash()
 	ts.h.Reset()
 	ts.first = true
-	ts.sums = fileInfoSums{}
+	ts.sums = append(FileInfoSums{}, ts.opts.ResumeSums...)
+	ts.fileCounter = int64(len(ts.opts.ResumeSums))
 	return nil
 }
 
+// isSpecialFile reports whether entries of the given type never have data.
+func isSpecialFile(typeflag byte) bool {
+	switch typeflag {
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return true
+	}
+	return false
+}
+
+// finishFile records the sum of the current file, unless it is being
+// skipped, and resets the hash for the next one.
+func (ts *tarSum) finishFile() error {
+	if ts.global {
+		if err := ts.finishGlobal(); err != nil {
+			return err
+		}
+	}
+	if ts.skip {
+		// The file was excluded from the checksum.
+		ts.h.Reset()
+		return nil
+	}
+	if ts.text != nil {
+		err := ts.text.flush()
+		ts.text = nil
+		if err != nil {
+			return err
+		}
+	}
+	sum := hex.EncodeToString(ts.h.Sum(nil))
+	if ts.opts.HashSelector != nil {
+		sum = ts.fileHashName + ":" + sum
+	}
+	fis := fileInfoSum{name: ts.currentFile, sum: sum, pos: ts.fileCounter, typeflag: ts.currentType}
+	ts.sums = append(ts.sums, fis)
+	if ts.chunker != nil {
+		ts.chunkSums[ts.currentFile] = ts.chunker.finish()
+		ts.chunker = nil
+	}
+	ts.finishDescriptor()
+	ts.fileCounter++
+	ts.h.Reset()
+	if ts.opts.AuditWriter != nil {
+		if _, err := fmt.Fprintf(ts.opts.AuditWriter, "%d\t%q\t%s\n", fis.pos, fis.name, fis.sum); err != nil {
+			if err := ts.softError(fmt.Errorf("tarsum: writing audit record: %v", err)); err != nil {
+				return err
+			}
+		}
+	}
+	if ts.opts.RecordWriter != nil {
+		if err := ts.writeRecord(fis); err != nil {
+			if err := ts.softError(err); err != nil {
+				return err
+			}
+		}
+	}
+	if ts.fileDone != nil {
+		// A verifier's mismatch is not recoverable, so it always aborts.
+		return ts.fileDone(fis)
+	}
+	return nil
+}
+
+// checkTrailingData reads the remainder of the input following the end of
+// the archive and returns ErrTrailingData if any of it is non-zero. Zero
+// bytes are accepted since tar writers commonly pad archives to a multiple
+// of their record size. It returns ErrBlockSize instead if the data starts
+// a block with a valid header, as the entries following the padding of an
+// archive using larger blocks would.
+func (ts *tarSum) checkTrailingData() error {
+	buf := make([]byte, buf8K)
+	for {
+		// The input is read in whole blocks so that each block of the
+		// buffer is aligned as in the archive.
+		n, err := io.ReadFull(ts.Reader, buf)
+		for off := 0; off < n; off += volumeBlockSize {
+			block := buf[off:n]
+			if len(block) > volumeBlockSize {
+				block = block[:volumeBlockSize]
+			}
+			if isZeroBlock(block) {
+				continue
+			}
+			if len(block) == volumeBlockSize && validHeaderChecksum(block) {
+				return ErrBlockSize
+			}
+			return ErrTrailingData
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func (ts *tarSum) Read(buf []byte) (int, error) {
-	if ts.finished {
-		return ts.bufWriter.Read(buf)
+	// Keep producing output until there is enough buffered to fill buf so
+	// that the size of each read is decoupled from the amount of input
+	// consumed by a single step.
+	if ts.closed {
+		return 0, ErrClosed
+	}
+	for !ts.finished && ts.bufWriter.Len() < len(buf) {
+		if err := ts.fill(len(buf)); err != nil {
+			ts.stopPrefetch()
+			// The error reading the archive is returned over any from
+			// closing the source.
+			ts.closeSource()
+			return 0, err
+		}
 	}
-	if len(ts.bufData) < len(buf) {
+	return ts.bufWriter.Read(buf)
+}
+
+// fill consumes up to size bytes of input from the tar reader, hashing it and
+// re-encoding it to bufWriter, or advances to the next entry of the archive
+// if the current one has been consumed.
+func (ts *tarSum) fill(size int) error {
+	// Only grow the scratch buffer when its capacity is insufficient so
+	// that callers alternating between large buffer sizes reuse it.
+	if cap(ts.bufData) < size {
 		switch {
-		case len(buf) <= buf8K:
+		case size <= buf8K:
 			ts.bufData = make([]byte, buf8K)
-		case len(buf) <= buf16K:
+		case size <= buf16K:
 			ts.bufData = make([]byte, buf16K)
-		case len(buf) <= buf32K:
+		case size <= buf32K:
 			ts.bufData = make([]byte, buf32K)
 		default:
-			ts.bufData = make([]byte, len(buf))
+			ts.bufData = make([]byte, size)
 		}
 	}
-	buf2 := ts.bufData[:len(buf)]
+	buf2 := ts.bufData[:size]
 
+	start := ts.startTiming()
+	readStart := ts.startFileTimer()
 	n, err := ts.tarR.Read(buf2)
+	ts.stopTiming(TimingTarRead, start)
+	if err := ts.checkFileTimeout(readStart); err != nil {
+		return err
+	}
 	if err != nil {
 		if err == io.EOF {
-			if _, err := ts.h.Write(buf2[:n]); err != nil {
-				return 0, err
+			ts.captureGlobal(buf2[:n])
+			if !ts.skip {
+				if err := ts.hashData(buf2[:n]); err != nil {
+					return err
+				}
 			}
 			if !ts.first {
-				ts.sums = append(ts.sums, fileInfoSum{name: ts.currentFile, sum: hex.EncodeToString(ts.h.Sum(nil)), pos: ts.fileCounter})
-				ts.fileCounter++
-				ts.h.Reset()
+				if err := ts.finishFile(); err != nil {
+					return err
+				}
 			} else {
 				ts.first = false
 			}
 
-			currentHeader, err := ts.tarR.Next()
+			start = ts.startTiming()
+			currentHeader, err := ts.nextHeader()
+			ts.stopTiming(TimingTarRead, start)
 			if err != nil {
 				if err == io.EOF {
-					if err := ts.tarW.Close(); err != nil {
-						return 0, err
+					if err := ts.endInput(); err != nil {
+						return err
+					}
+					if err := ts.checkRequired(); err != nil {
+						return err
+					}
+					start = ts.startTiming()
+					err := ts.tarW.Close()
+					ts.stopTiming(TimingTarWrite, start)
+					if err != nil {
+						return ErrReemit{Op: "close tar writer", Err: err}
 					}
-					if _, err := io.Copy(ts.writer, ts.bufTar); err != nil {
-						return 0, err
+					start = ts.startTiming()
+					err = ts.closeOutput()
+					ts.stopTiming(TimingCompress, start)
+					if err != nil {
+						return err
 					}
-					if err := ts.writer.Close(); err != nil {
-						return 0, err
+					if ts.raw != nil {
+						// Pass through anything following the archive.
+						if _, err := io.Copy(ioutil.Discard, ts.raw); err != nil {
+							return err
+						}
+					}
+					if ts.rest != nil {
+						if _, err := io.Copy(ioutil.Discard, ts.rest); err != nil {
+							return err
+						}
+					}
+					if ts.opts.TrailingManifest {
+						if err := ts.writeManifest(); err != nil {
+							return err
+						}
 					}
 					ts.finished = true
-					return n, nil
+					ts.stopPrefetch()
+					return ts.closeSource()
+				}
+				return err
+			}
+			if ts.opts.HeaderTransform != nil {
+				name, size := currentHeader.Name, currentHeader.Size
+				if err := ts.opts.HeaderTransform(currentHeader); err != nil {
+					return fmt.Errorf("tarsum: transforming header of %q: %v", name, err)
+				}
+				if currentHeader.Size != size {
+					return fmt.Errorf("tarsum: transforming header of %q changed its size", name)
 				}
-				return n, err
 			}
-			ts.currentFile = strings.TrimSuffix(strings.TrimPrefix(currentHeader.Name, "./"), "/")
-			if err := ts.encodeHeader(currentHeader); err != nil {
-				return 0, err
+			ts.beginEntry(currentHeader)
+			ts.fileElapsed = 0
+			ts.seeRequired(ts.currentFile)
+			if err := ts.seeName(ts.currentFile); err != nil {
+				return err
 			}
-			if err := ts.tarW.WriteHeader(currentHeader); err != nil {
-				return 0, err
+			if ts.opts.RequireSortedEntries {
+				if ts.entryCounter > 1 && lessEntryName(ts.currentFile, ts.prevFile) {
+					return ErrUnsortedEntries{Prev: ts.prevFile, Cur: ts.currentFile}
+				}
+				ts.prevFile = ts.currentFile
 			}
-			if _, err := ts.tarW.Write(buf2[:n]); err != nil {
-				return 0, err
+			if ts.currentFile == "" && ts.opts.RejectEmptyNames {
+				return ErrEmptyEntryName
 			}
-			ts.tarW.Flush()
-			if _, err := io.Copy(ts.writer, ts.bufTar); err != nil {
-				return 0, err
+			if ts.opts.StrictSpecialFiles && currentHeader.Size > 0 && isSpecialFile(currentHeader.Typeflag) {
+				return ErrSpecialFileData
 			}
-			ts.writer.Flush()
-
-			return ts.bufWriter.Read(buf)
+			if !ts.skip && ts.opts.ChunkSize > 0 && currentHeader.Size > ts.opts.ChunkSize {
+				ts.chunker = newChunker(ts.th, ts.opts.ChunkSize)
+			}
+			ts.startDescriptor(currentHeader.Typeflag)
+			if !ts.skip && ts.opts.HashSelector != nil {
+				if err := ts.selectFileHash(currentHeader); err != nil {
+					return err
+				}
+			}
+			if ts.isTextEntry(currentHeader.Typeflag) {
+				ts.text = &crlfNormalizer{w: ts.h}
+			}
+			ts.headOnly = ts.opts.HashHeadBytes > 0 && currentHeader.Size > ts.opts.HashHeadBytes
+			ts.headLeft = ts.opts.HashHeadBytes
+			if !ts.skip {
+				start = ts.startTiming()
+				err := ts.encodeHeader(currentHeader)
+				ts.stopTiming(TimingHash, start)
+				if err != nil {
+					return err
+				}
+				if ts.opts.StructureSum {
+					ts.recordStructure(currentHeader)
+				}
+				if ts.opts.RetainHeaders {
+					ts.headers = append(ts.headers, copyHeader(currentHeader))
+					ts.offsets = append(ts.offsets, ts.headerOffset)
+				}
+			}
+			emitHeader := currentHeader
+			if ts.opts.Canonicalize {
+				emitHeader = canonicalHeader(currentHeader)
+			}
+			start = ts.startTiming()
+			if err := ts.tarW.WriteHeader(emitHeader); err != nil {
+				return ErrReemit{Op: "write header", Err: err}
+			}
+			_, err = ts.tarW.Write(buf2[:n])
+			ts.stopTiming(TimingTarWrite, start)
+			if err != nil {
+				return ErrReemit{Op: "write entry", Err: err}
+			}
+			return ts.flushOutput()
 		}
-		return n, err
+		return err
 	}
 
 	// Filling the hash buffer
-	if _, err = ts.h.Write(buf2[:n]); err != nil {
-		return 0, err
+	ts.captureGlobal(buf2[:n])
+	if !ts.skip {
+		if err := ts.hashData(buf2[:n]); err != nil {
+			return err
+		}
 	}
 
 	// Filling the tar writter
-	if _, err = ts.tarW.Write(buf2[:n]); err != nil {
-		return 0, err
+	start = ts.startTiming()
+	_, err = ts.tarW.Write(buf2[:n])
+	ts.stopTiming(TimingTarWrite, start)
+	if err != nil {
+		return ErrReemit{Op: "write entry", Err: err}
 	}
-	ts.tarW.Flush()
 
 	// Filling the output writer
-	if _, err = io.Copy(ts.writer, ts.bufTar); err != nil {
-		return 0, err
+	return ts.flushOutput()
+}
+
+// beginEntry records the entry described by hdr as the current one: its
+// name, type and size, whether it is left out of the checksum, and the
+// format and features it adds to those of the archive. It is called for
+// each entry read, before its header is hashed.
+func (ts *tarSum) beginEntry(hdr *tar.Header) {
+	ts.format |= hdr.Format
+	ts.features |= ts.tarR.Features()
+	ts.currentSize, ts.currentType = hdr.Size, hdr.Typeflag
+	ts.currentFile = entryName(hdr.Name)
+	ts.skip = ts.entryCounter < ts.opts.SkipFirstN || ts.excluded(ts.currentFile, hdr.Typeflag)
+	// VersionGlobalPAX aggregates global PAX records rather than summing
+	// their entries as files.
+	ts.global = ts.tarSumVersion == VersionGlobalPAX && hdr.Typeflag == tar.TypeXGlobalHeader
+	ts.skip = ts.skip || ts.global
+	ts.entryCounter++
+}
+
+// hashData hashes data from the body of the current file.
+func (ts *tarSum) hashData(p []byte) error {
+	defer ts.stopTiming(TimingHash, ts.startTiming())
+	if ts.chunker != nil {
+		ts.chunker.Write(p)
+	}
+	if ts.bodyDigest != nil {
+		ts.bodyDigest.Write(p)
+	}
+	if ts.headOnly {
+		if int64(len(p)) > ts.headLeft {
+			p = p[:ts.headLeft]
+		}
+		ts.headLeft -= int64(len(p))
+	}
+	if ts.text != nil {
+		_, err := ts.text.Write(p)
+		return err
 	}
-	ts.writer.Flush()
+	_, err := ts.h.Write(p)
+	return err
+}
 
-	return ts.bufWriter.Read(buf)
+// flushOutput moves the re-encoded bytes through the output writer to
+// bufWriter. The tar writer is not flushed: it writes through to bufTar and
+// flushing mid-entry is an error, while the padding of each entry is written
+// by the following WriteHeader or Close. The output writer is flushed as
+// Options.FlushPolicy selects, and with Options.LazyFlush only when bufWriter
+// would otherwise be empty.
+func (ts *tarSum) flushOutput() error {
+	defer ts.stopTiming(TimingCompress, ts.startTiming())
+	n, err := ts.copyOutput()
+	if err != nil {
+		return err
+	}
+	if ts.skipFlush(n) || ts.opts.LazyFlush && ts.bufWriter.Len() > 0 {
+		return nil
+	}
+	if err := ts.writer.Flush(); err != nil {
+		return ErrReemit{Op: "flush output", Err: err}
+	}
+	return nil
+}
+
+// closeOutput writes the remaining re-encoded bytes to the output writer and
+// closes it.
+func (ts *tarSum) closeOutput() error {
+	if _, err := ts.copyOutput(); err != nil {
+		return err
+	}
+	if err := ts.writer.Close(); err != nil {
+		return ErrReemit{Op: "close output", Err: err}
+	}
+	return nil
+}
+
+// copyOutput writes the pending re-encoded bytes to the output writer and
+// returns their number. A short write is reported as io.ErrShortWrite by
+// io.Copy.
+func (ts *tarSum) copyOutput() (int64, error) {
+	n, err := io.Copy(ts.writer, ts.bufTar)
+	if err != nil {
+		return n, ErrReemit{Op: "write output", Err: err}
+	}
+	return n, nil
 }
 
 func (ts *tarSum) Sum(extra []byte) string {
-	ts.sums.SortBySums()
+	checksum := ts.Version().String() + "+" + ts.th.Name() + ":" + hex.EncodeToString(ts.sumDigest(extra))
+	ts.logger.Debugf("checksum processed: %s", checksum)
+	return checksum
+}
+
+// sumDigest returns the raw digest in the checksum returned by Sum.
+func (ts *tarSum) sumDigest(extra []byte) []byte {
 	h := ts.th.Hash()
+	ts.writePreimage(h, extra)
+	return h.Sum(nil)
+}
+
+// SumPreimage returns the bytes hashed by Sum(extra): extra followed by the
+// per-file sums in sorted order, as the version aggregates them. Hashing
+// them with Hash().Hash() gives the digest in the checksum returned by Sum,
+// so that it can be verified independently. Like Sum, it sorts the sums.
+func (ts *tarSum) SumPreimage(extra []byte) []byte {
+	var buf bytes.Buffer
+	ts.writePreimage(&buf, extra)
+	return buf.Bytes()
+}
+
+func (ts *tarSum) writePreimage(w io.Writer, extra []byte) {
+	ts.sums.sortSums(ts.tarSumVersion)
 	if extra != nil {
-		h.Write(extra)
+		w.Write(extra)
 	}
+	ts.writeGlobalRecords(w)
 	for _, fis := range ts.sums {
-		log.Debugf("-->%s<--", fis.Sum())
-		h.Write([]byte(fis.Sum()))
+		ts.logger.Debugf("-->%s<--", fis.Sum())
+		writeAggregateSum(w, ts.tarSumVersion, fis.Sum())
 	}
-	checksum := ts.Version().String() + "+" + ts.th.Name() + ":" + hex.EncodeToString(h.Sum(nil))
-	log.Debugf("checksum processed: %s", checksum)
-	return checksum
 }
 
-func (ts *tarSum) GetSums() fileInfoSums {
+func (ts *tarSum) GetSums() FileInfoSums {
+	if ts.opts.DigestEncoding != EncodingHex || ts.opts.PerFileDigestBytes > 0 {
+		// The sums are kept in full in hex for Sum; return a rendered
+		// copy.
+		return ts.opts.DigestEncoding.encodeSums(ts.sums, ts.opts.PerFileDigestBytes)
+	}
 	return ts.sums
 }
+
+// GetSumsWhere returns a copy of the per-file sums of the files whose names
+// satisfy pred, sorted as Sum sorts them. Unlike Sum it leaves the order of
+// the sums returned by GetSums unchanged. Sums are rendered with
+// Options.DigestEncoding and truncated to Options.PerFileDigestBytes.
+func (ts *tarSum) GetSumsWhere(pred func(name string) bool) FileInfoSums {
+	sums := FileInfoSums{}
+	for _, fis := range ts.sums {
+		if pred(fis.Name()) {
+			sums = append(sums, fis)
+		}
+	}
+	sums.sortSums(ts.tarSumVersion)
+	if ts.opts.DigestEncoding != EncodingHex || ts.opts.PerFileDigestBytes > 0 {
+		return ts.opts.DigestEncoding.encodeSums(sums, ts.opts.PerFileDigestBytes)
+	}
+	return sums
+}
+
+// WeakSum returns the Adler-32 checksum of the raw input read so far, as
+// WeakTarSum describes, or 0 without Options.WeakSum.
+func (ts *tarSum) WeakSum() uint32 {
+	if ts.weak == nil {
+		return 0
+	}
+	return ts.weak.Sum32()
+}
+
+func (ts *tarSum) ContentDigest() string {
+	if ts.content == nil {
+		return ""
+	}
+	return "sha256:" + hex.EncodeToString(ts.content.Sum(nil))
+}
+
+// Format returns the formats of the entries read so far, as FormatTarSum
+// describes.
+func (ts *tarSum) Format() tar.Format {
+	return ts.format
+}
+
+func (ts *tarSum) Features() tar.Features {
+	return ts.features
+}
+
+// GetHeaders returns copies of the headers of the summed files in the order
+// they were processed, when Options.RetainHeaders is set.
+func (ts *tarSum) GetHeaders() []*tar.Header {
+	return ts.headers
+}
+
+// GetOffsets returns the offset in the tar stream at which the header of each
+// file returned by GetHeaders begins, when Options.RetainHeaders is set. The
+// tar stream is the input, after any decompression by AutoDecompress, and an
+// offset is that of the first header block of the entry, such as an extended
+// header preceding its own. Entries added by AppendEntry have offset -1.
+func (ts *tarSum) GetOffsets() []int64 {
+	return ts.offsets
+}
+
+// tarOffset returns the offset in the tar stream of the next header, or -1
+// if the tar reader is not reading the input.
+func (ts *tarSum) tarOffset() int64 {
+	if ts.tarInput == nil {
+		return -1
+	}
+	// The data of the current entry has been read in full, so only its
+	// padding to the next block is left.
+	return (ts.tarInput.n + volumeBlockSize - 1) &^ (volumeBlockSize - 1)
+}
+
+// Normalize computes the TarSum of the archive read from r using the given
+// version and returns the uncompressed tar stream as re-encoded by the
+// internal tar writer along with the checksum. The canonical bytes may differ
+// from the input (header field encoding, padding, etc.) but the checksum always
+// corresponds to the original archive content.
+func Normalize(r io.Reader, v Version) (canonical []byte, sum string, err error) {
+	ts, err := newTarSum(r, true, v)
+	if err != nil {
+		return nil, "", err
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, ts); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), ts.Sum(nil), nil
+}
+
+// ComputeFileSums drains the archive read from r and returns the per-file
+// sums in archive order, without computing the aggregate checksum.
+func ComputeFileSums(r io.Reader, v Version) (FileInfoSums, error) {
+	ts, err := newTarSum(r, true, v)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return nil, err
+	}
+
+	return ts.GetSums(), nil
+}
+
+// DigestResult is the full report of a single pass over an archive.
+type DigestResult struct {
+	// Sum is the checksum of the archive.
+	Sum string
+	// Version and HashName identify how Sum was computed.
+	Version  Version
+	HashName string
+	// FileCount is the number of files summed.
+	FileCount int
+	// TotalBytes is the number of bytes read from the input.
+	TotalBytes int64
+	// Sums holds the per-file sums in archive order.
+	Sums FileInfoSums
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// DigestArchive drains the archive read from r and returns its checksum
+// under the given version together with its per-file sums and other
+// metadata. It is named to avoid the Digest type.
+func DigestArchive(r io.Reader, v Version) (*DigestResult, error) {
+	cr := &countingReader{r: r}
+	ts, err := newTarSumOptions(cr, v, Options{Mode: ModeDigestOnly})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return nil, err
+	}
+
+	// Copy the sums before Sum sorts them.
+	sums := append(FileInfoSums(nil), ts.GetSums()...)
+	sum := ts.Sum(nil)
+
+	return &DigestResult{
+		Sum:        sum,
+		Version:    v,
+		HashName:   ts.Hash().Name(),
+		FileCount:  len(sums),
+		TotalBytes: cr.n,
+		Sums:       sums,
+	}, nil
+}
+
+// ReSum computes the checksums of the archive read from r under two
+// different versions in a single pass over the input. Each returned sum is
+// identical to the one computed by a separate pass using that version.
+func ReSum(r io.Reader, from, to Version) (fromSum, toSum string, err error) {
+	pr, pw := io.Pipe()
+
+	fromTS, err := newTarSum(io.TeeReader(r, pw), true, from)
+	if err != nil {
+		return "", "", err
+	}
+	toTS, err := newTarSum(pr, true, to)
+	if err != nil {
+		return "", "", err
+	}
+
+	toErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, toTS)
+		// Unblock the tee if this side stopped reading early.
+		pr.CloseWithError(err)
+		toErr <- err
+	}()
+
+	_, err = io.Copy(ioutil.Discard, fromTS)
+	pw.CloseWithError(err)
+	if err2 := <-toErr; err == nil {
+		err = err2
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	return fromTS.Sum(nil), toTS.Sum(nil), nil
+}
+
+// SumWriter computes the TarSum of the archive written by fn to the given
+// tar.Writer, without buffering the archive. The writer is closed once fn
+// returns, and the checksum is identical to that of the archive fn writes.
+// An error from fn is returned in preference to any other.
+func SumWriter(fn func(tw *tar.Writer) error, v Version) (string, error) {
+	return sumWriter(fn, v, nil)
+}
+
+// sumWriter is SumWriter, also copying the archive to copyTo when it is
+// non-nil.
+func sumWriter(fn func(tw *tar.Writer) error, v Version, copyTo io.Writer) (string, error) {
+	pr, pw := io.Pipe()
+
+	ts, err := newTarSumOptions(pr, v, Options{Mode: ModeDigestOnly})
+	if err != nil {
+		return "", err
+	}
+
+	fnErr := make(chan error, 1)
+	go func() {
+		var w io.Writer = pw
+		if copyTo != nil {
+			w = io.MultiWriter(pw, copyTo)
+		}
+		tw := tar.NewWriter(w)
+		err := fn(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+		fnErr <- err
+	}()
+
+	_, err = io.Copy(ioutil.Discard, ts)
+	if err == nil {
+		// Consume anything the tar reader left unread, such as the end
+		// of the end-of-archive marker, so that fn can finish.
+		_, err = io.Copy(ioutil.Discard, pr)
+	}
+	// Unblock fn if reading stopped early.
+	pr.CloseWithError(err)
+	if err2 := <-fnErr; err2 != nil {
+		err = err2
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return ts.Sum(nil), nil
+}
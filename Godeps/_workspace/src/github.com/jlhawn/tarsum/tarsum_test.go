@@ -0,0 +1,1080 @@
+package tarsum
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+type testEntry struct {
+	header *tar.Header
+	data   []byte
+}
+
+func regEntry(name, data string) testEntry {
+	return testEntry{
+		header: &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  time.Unix(1400000000, 0),
+			Typeflag: tar.TypeReg,
+		},
+		data: []byte(data),
+	}
+}
+
+func buildTar(t testing.TB, entries ...testEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		if err := tw.WriteHeader(e.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadReusesLargeBuffer(t *testing.T) {
+	archive := buildTar(t, regEntry("big", string(bytes.Repeat([]byte{'a'}, 256*1024))))
+
+	ts, err := newTarSum(bytes.NewReader(archive), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sizes := []int{96 * 1024, 64 * 1024, 80 * 1024, 40 * 1024}
+	buf := make([]byte, sizes[0])
+	if _, err := ts.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	first := &ts.bufData[0]
+
+	for i := 0; i < 8; i++ {
+		buf = make([]byte, sizes[i%len(sizes)])
+		if _, err := ts.Read(buf); err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if &ts.bufData[0] != first {
+			t.Fatalf("scratch buffer reallocated for read of %d bytes", len(buf))
+		}
+	}
+}
+
+func BenchmarkReadAlternatingBuffers(b *testing.B) {
+	archive := buildTar(b, regEntry("big", string(bytes.Repeat([]byte{'a'}, 1024*1024))))
+	bufs := [][]byte{make([]byte, 48*1024), make([]byte, 128*1024)}
+
+	b.SetBytes(int64(len(archive)))
+	for i := 0; i < b.N; i++ {
+		ts, err := newTarSum(bytes.NewReader(archive), true, Version1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for j := 0; ; j++ {
+			if _, err := ts.Read(bufs[j%len(bufs)]); err != nil {
+				if err == io.EOF {
+					break
+				}
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestLazyFlush(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("big", string(bytes.Repeat([]byte("lazy"), 64*1024))))
+
+	output := func(opts Options) []byte {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := readAllSizes(t, ts, buf8K)
+		if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+			t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+		}
+		return out
+	}
+
+	eager, lazy := output(Options{}), output(Options{LazyFlush: true})
+	if len(lazy) >= len(eager) {
+		t.Errorf("expected lazy flushing to compress better: %d bytes, eager %d bytes", len(lazy), len(eager))
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(lazy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, expected := sumArchive(t, decoded, Version1), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum of decompressed output\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
+
+func benchmarkRead(b *testing.B, opts Options) {
+	archive := buildTar(b, regEntry("big", string(bytes.Repeat([]byte("benchmark"), 1024*1024))))
+	buf := make([]byte, buf8K)
+
+	b.SetBytes(int64(len(archive)))
+	for i := 0; i < b.N; i++ {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if _, err := ts.Read(buf); err != nil {
+				if err == io.EOF {
+					break
+				}
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkReadFlush(b *testing.B)     { benchmarkRead(b, Options{}) }
+func BenchmarkReadLazyFlush(b *testing.B) { benchmarkRead(b, Options{LazyFlush: true}) }
+
+func TestNormalize(t *testing.T) {
+	archive := buildTar(t, regEntry("a.txt", "hello"), regEntry("b.txt", "world"))
+
+	canonical, sum, err := Normalize(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The canonical form must itself be a valid archive with the same sum.
+	_, resum, err := Normalize(bytes.NewReader(canonical), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != resum {
+		t.Errorf("canonical archive sum mismatch\n\tActual: %s\n\tExpected: %s", resum, sum)
+	}
+}
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestCustomLogger(t *testing.T) {
+	archive := buildTar(t, regEntry("a.txt", "hello"))
+
+	logger := &recordingLogger{}
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+	sum := ts.Sum(nil)
+
+	if len(logger.lines) == 0 {
+		t.Fatal("expected debug output on the custom logger")
+	}
+	if last := logger.lines[len(logger.lines)-1]; !strings.Contains(last, sum) {
+		t.Errorf("expected final log line to contain the checksum, got %q", last)
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	entry := func(mtime int64, uid int, uname string) testEntry {
+		e := regEntry("dir/file", "content")
+		e.header.ModTime = time.Unix(mtime, 0)
+		e.header.Uid = uid
+		e.header.Uname = uname
+		e.header.Xattrs = map[string]string{"user.a": "1", "user.b": "2", "user.c": "3"}
+		return e
+	}
+	archives := [][]byte{
+		buildTar(t, entry(1400000000, 1000, "alice")),
+		buildTar(t, entry(1500000000, 0, "root")),
+	}
+
+	var outputs [][]byte
+	for _, archive := range archives {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{DisableCompression: true, Canonicalize: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, out)
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatal("expected canonicalized archives to be byte-identical")
+	}
+	// The PAX headers are named independently of the process, unless
+	// canonicalized.
+	if !bytes.Contains(outputs[0], []byte("dir/PaxHeaders.0/file")) {
+		t.Error("expected the canonicalized PAX header to be named with a fixed value")
+	}
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archives[0]), Version1, Options{DisableCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := fmt.Sprintf("dir/PaxHeaders.%d/file", os.Getpid()); !bytes.Contains(out, []byte(name)) {
+		t.Errorf("expected the PAX header to be named %s without Canonicalize", name)
+	}
+}
+
+func TestComputeFileSums(t *testing.T) {
+	archive := buildTar(t, regEntry("b.txt", "second"), regEntry("a.txt", "first"))
+
+	sums, err := ComputeFileSums(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 2 {
+		t.Fatalf("expected 2 file sums, got %d", len(sums))
+	}
+	for i, name := range []string{"b.txt", "a.txt"} {
+		if sums[i].Name() != name || sums[i].Pos() != int64(i) {
+			t.Errorf("unexpected file sum at %d: %s (pos %d)", i, sums[i].Name(), sums[i].Pos())
+		}
+	}
+}
+
+// paxEntry returns a PAX extended header entry holding the given records,
+// which applies to the entry that follows it.
+func paxEntry(records map[string]string) testEntry {
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, k := range keys {
+		msg := k + "=" + records[k]
+		size := len(msg) + 2
+		size += len(strconv.Itoa(size))
+		record := fmt.Sprintf("%d %s\n", size, msg)
+		if len(record) != size {
+			record = fmt.Sprintf("%d %s\n", len(record), msg)
+		}
+		buf.WriteString(record)
+	}
+
+	return testEntry{
+		header: &tar.Header{
+			Name:     "PaxHeaders.0/entry",
+			Size:     int64(buf.Len()),
+			ModTime:  time.Unix(1400000000, 0),
+			Typeflag: tar.TypeXHeader,
+		},
+		data: buf.Bytes(),
+	}
+}
+
+func TestVersionMtimeNano(t *testing.T) {
+	archive := func(mtime string) []byte {
+		return buildTar(t, paxEntry(map[string]string{"mtime": mtime}), regEntry("file", "data"))
+	}
+	a, b := archive("1400000000.25"), archive("1400000000.5")
+
+	sum := func(archive []byte, v Version) string { return sumArchive(t, archive, v) }
+
+	for _, v := range []Version{Version0, Version1} {
+		if sum(a, v) != sum(b, v) {
+			t.Errorf("%s should ignore sub-second modification times", v)
+		}
+	}
+	if sum(a, VersionMtimeNano) == sum(b, VersionMtimeNano) {
+		t.Errorf("%s should distinguish sub-second modification times", VersionMtimeNano)
+	}
+	if sum(a, VersionMtimeNano) != sum(archive("1400000000.250"), VersionMtimeNano) {
+		t.Errorf("%s should format equal times identically", VersionMtimeNano)
+	}
+}
+
+func sumArchive(t testing.TB, archive []byte, v Version) string {
+	ts, err := newTarSum(bytes.NewReader(archive), true, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+	return ts.Sum(nil)
+}
+
+func TestReSum(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 100*1024))))
+
+	fromSum, toSum, err := ReSum(bytes.NewReader(archive), Version0, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := sumArchive(t, archive, Version0); fromSum != expected {
+		t.Errorf("Mismatched from sum\n\tActual: %s\n\tExpected: %s", fromSum, expected)
+	}
+	if expected := sumArchive(t, archive, Version1); toSum != expected {
+		t.Errorf("Mismatched to sum\n\tActual: %s\n\tExpected: %s", toSum, expected)
+	}
+}
+
+func TestSumPreimage(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"), regEntry("c", "three"))
+
+	for _, v := range []Version{Version1, VersionSeparated} {
+		for _, extra := range [][]byte{nil, []byte("extra")} {
+			ts, err := NewTarSum(bytes.NewReader(archive), true, v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readAllSizes(t, ts, buf32K)
+
+			h := ts.Hash().Hash()
+			h.Write(ts.(PreimageTarSum).SumPreimage(extra))
+			expected := v.String() + "+" + ts.Hash().Name() + ":" + hex.EncodeToString(h.Sum(nil))
+			if sum := ts.Sum(extra); sum != expected {
+				t.Errorf("%s: Mismatched sum of preimage\n\tActual: %s\n\tExpected: %s", v, sum, expected)
+			}
+		}
+	}
+}
+
+func TestSumWriter(t *testing.T) {
+	entries := []testEntry{regEntry("a", "one"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 100*1024)))}
+	write := func(tw *tar.Writer) error {
+		for _, e := range entries {
+			if err := tw.WriteHeader(e.header); err != nil {
+				return err
+			}
+			if _, err := tw.Write(e.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	sum, err := SumWriter(write, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := sumArchive(t, buildTar(t, entries...), Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	errGenerate := errors.New("generate failed")
+	_, err = SumWriter(func(tw *tar.Writer) error {
+		if err := write(tw); err != nil {
+			return err
+		}
+		return errGenerate
+	}, Version1)
+	if err != errGenerate {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, errGenerate)
+	}
+}
+
+type brokenTHash struct {
+	name string
+	hash func() hash.Hash
+}
+
+func (th brokenTHash) Name() string    { return th.name }
+func (th brokenTHash) Hash() hash.Hash { return th.hash() }
+
+func TestNewTarSumFromReader(t *testing.T) {
+	first := regEntry("first", "skipped")
+	rest := []testEntry{regEntry("second", "2"), regEntry("dir/third", strings.Repeat("3", 10000))}
+	archive := buildTar(t, append([]testEntry{first}, rest...)...)
+	expected := sumArchive(t, buildTar(t, rest...), Version1)
+
+	// Whether the first entry's body is read, partly read or unread, the
+	// sum covers the entries following it.
+	for _, n := range []int64{-1, 3, 0} {
+		tr := tar.NewReader(bytes.NewReader(archive))
+		if _, err := tr.Next(); err != nil {
+			t.Fatal(err)
+		}
+		if n < 0 {
+			if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+				t.Fatal(err)
+			}
+		} else if _, err := io.CopyN(ioutil.Discard, tr, n); err != nil {
+			t.Fatal(err)
+		}
+
+		ts, err := NewTarSumFromReader(tr, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := readAllSizes(t, ts, buf32K)
+		if sum := ts.Sum(nil); sum != expected {
+			t.Errorf("Mismatched sums after reading %d bytes of the first entry\n\tActual: %s\n\tExpected: %s", n, sum, expected)
+		}
+		if sums := ts.GetSums(); len(sums) != len(rest) || sums.GetFile("first") != nil {
+			t.Errorf("expected sums of only the remaining entries: %v", sums)
+		}
+
+		// The output is the remaining entries.
+		gz, err := gzip.NewReader(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr, err := tar.NewReader(gz).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != "second" {
+			t.Errorf("Mismatched first output entry\n\tActual: %s\n\tExpected: second", hdr.Name)
+		}
+	}
+}
+
+func TestRepeatedDirectoryEntries(t *testing.T) {
+	dir := func(name string, mode int64) testEntry {
+		e := regEntry(name, "")
+		e.header.Typeflag = tar.TypeDir
+		e.header.Mode = mode
+		return e
+	}
+	empty, chmod, file, other := dir("dir/", 0755), dir("dir/", 0700), regEntry("dir", "a file"), regEntry("other", "data")
+	archive := buildTar(t, empty, chmod, file, other)
+
+	ts, err := NewTarSum(bytes.NewReader(archive), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+	sums := ts.GetSums()
+	expectedTypes := []byte{tar.TypeDir, tar.TypeDir, tar.TypeReg, tar.TypeReg}
+	if len(sums) != len(expectedTypes) {
+		t.Fatalf("Mismatched number of sums\n\tActual: %d\n\tExpected: %d", len(sums), len(expectedTypes))
+	}
+	seen := make(map[string]bool)
+	for i, fis := range sums {
+		if fis.Pos() != int64(i) {
+			t.Errorf("Mismatched position of %s\n\tActual: %d\n\tExpected: %d", fis.Name(), fis.Pos(), i)
+		}
+		if typeflag := fis.(TypedFileInfoSum).Typeflag(); typeflag != expectedTypes[i] {
+			t.Errorf("Mismatched type of entry %d\n\tActual: %c\n\tExpected: %c", i, typeflag, expectedTypes[i])
+		}
+		if seen[fis.Sum()] {
+			t.Errorf("expected entry %d to have a distinct sum", i)
+		}
+		seen[fis.Sum()] = true
+	}
+	if sums[0].Name() != "dir" || sums[2].Name() != "dir" {
+		t.Errorf("expected the directory and the file to be named dir: %v", sums)
+	}
+
+	// The standard versions order every entry with a repeated name by
+	// position, as Docker does, so their checksums of a file followed by a
+	// directory of the same name are pinned.
+	pinned := buildTar(t, regEntry("x", "a file"), dir("x/", 0755))
+	for v, expected := range map[Version]string{
+		Version0: "tarsum+sha256:3e4df5289b69343656d234b65b298f80f188fae19d6b61d1df068ee80ea262c6",
+		Version1: "tarsum.v1+sha256:6165aa377c6e8c40ecbc22a421f6847e17a170652498e300bf259b3245bb3517",
+	} {
+		if sum := sumArchive(t, pinned, v); sum != expected {
+			t.Errorf("Mismatched checksums\n\tActual: %s\n\tExpected: %s", sum, expected)
+		}
+		d, err := NewDigest(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Write(pinned); err != nil {
+			t.Fatal(err)
+		}
+		if digest := d.SumString(nil); digest != expected {
+			t.Errorf("Mismatched digest checksums\n\tActual: %s\n\tExpected: %s", digest, expected)
+		}
+	}
+
+	// Under VersionTypedNames the directory and the file are different
+	// entries, so the checksum does not depend on their order; the
+	// repeated directory entries are ordered by position as for any other
+	// repeated name.
+	sum := sumArchive(t, archive, VersionTypedNames)
+	for _, entries := range [][]testEntry{{file, empty, chmod, other}, {empty, other, file, chmod}} {
+		if reordered := sumArchive(t, buildTar(t, entries...), VersionTypedNames); reordered != sum {
+			t.Errorf("Mismatched checksums of reordered entries\n\tActual: %s\n\tExpected: %s", reordered, sum)
+		}
+	}
+	if swapped := sumArchive(t, buildTar(t, chmod, empty, file, other), VersionTypedNames); swapped == sum {
+		t.Error("expected the order of the repeated directory entries to change the checksum")
+	}
+
+	// Every way of summing agrees.
+	parallel, _, err := SumParallel(bytes.NewReader(archive), VersionTypedNames, ParallelOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parallel != sum {
+		t.Errorf("Mismatched parallel checksum\n\tActual: %s\n\tExpected: %s", parallel, sum)
+	}
+	d, err := NewDigest(VersionTypedNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Write(archive[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	state, err := d.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewDigest(VersionTypedNames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.Restore(state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Write(archive[1000:]); err != nil {
+		t.Fatal(err)
+	}
+	if digest := restored.SumString(nil); digest != sum {
+		t.Errorf("Mismatched digest checksum\n\tActual: %s\n\tExpected: %s", digest, sum)
+	}
+}
+
+func TestInvalidTHash(t *testing.T) {
+	cases := []THash{
+		brokenTHash{name: "nil", hash: func() hash.Hash { return nil }},
+		brokenTHash{name: "panics", hash: func() hash.Hash { panic("broken") }},
+		brokenTHash{name: "", hash: sha256.New},
+	}
+	for _, th := range cases {
+		if _, err := NewTarSumHash(bytes.NewReader(nil), true, Version1, th); err != ErrInvalidTHash {
+			t.Errorf("expected ErrInvalidTHash for THash %q, got %v", th.Name(), err)
+		}
+	}
+}
+
+func TestRejectTrailingData(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "hello"))
+
+	cases := []struct {
+		Input    []byte
+		Reject   bool
+		Expected error
+	}{
+		{Input: append(archive, "junk"...), Reject: false, Expected: nil},
+		{Input: append(archive, "junk"...), Reject: true, Expected: ErrTrailingData},
+		{Input: append(archive, make([]byte, 10240)...), Reject: true, Expected: nil},
+	}
+	for _, tc := range cases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(tc.Input), Version1, Options{RejectTrailingData: tc.Reject})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != tc.Expected {
+			t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, tc.Expected)
+		}
+	}
+}
+
+// legacyReader drives a tarSum the way Read did before output production
+// was decoupled from reads: a single fill step per call.
+type legacyReader struct {
+	ts *tarSum
+}
+
+func (lr legacyReader) Read(buf []byte) (int, error) {
+	if !lr.ts.finished {
+		if err := lr.ts.fill(len(buf)); err != nil {
+			return 0, err
+		}
+	}
+	return lr.ts.bufWriter.Read(buf)
+}
+
+// readAllSizes reads r to EOF using a fixed buffer size, returning the data
+// and the size of each read.
+func readAllSizes(t *testing.T, r io.Reader, size int) ([]byte, []int) {
+	var (
+		out   bytes.Buffer
+		sizes []int
+		buf   = make([]byte, size)
+	)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			out.Write(buf[:n])
+			sizes = append(sizes, n)
+		}
+		if err == io.EOF {
+			return out.Bytes(), sizes
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadMatchesLegacyOutput(t *testing.T) {
+	archive := buildTar(t,
+		regEntry("a", "small"),
+		regEntry("b", string(bytes.Repeat([]byte("0123456789"), 5000))),
+		regEntry("c", ""),
+	)
+
+	for _, dc := range []bool{false, true} {
+		legacyTS, err := newTarSum(bytes.NewReader(archive), dc, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacy, _ := readAllSizes(t, legacyReader{legacyTS}, 4096)
+
+		ts, err := newTarSum(bytes.NewReader(archive), dc, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, sizes := readAllSizes(t, ts, 4096)
+
+		if !bytes.Equal(out, legacy) {
+			t.Errorf("output differs from legacy implementation (compression disabled: %t)", dc)
+		}
+		if ts.Sum(nil) != legacyTS.Sum(nil) {
+			t.Errorf("checksum differs from legacy implementation (compression disabled: %t)", dc)
+		}
+		for i, n := range sizes[:len(sizes)-1] {
+			if n != 4096 {
+				t.Errorf("read %d returned %d bytes, expected a full buffer", i, n)
+			}
+		}
+	}
+}
+
+func TestRetainHeaders(t *testing.T) {
+	b := regEntry("b", "2")
+	b.header.Xattrs = map[string]string{"user.k": "v"}
+	archive := buildTar(t, regEntry("a", "1"), b)
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{RetainHeaders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+	ts.Sum(nil)
+
+	headers := ts.(HeaderTarSum).GetHeaders()
+	if len(headers) != 2 || headers[0].Name != "a" || headers[1].Name != "b" {
+		t.Fatalf("expected headers in processing order, got %v", headers)
+	}
+	if headers[1].Xattrs["user.k"] != "v" {
+		t.Errorf("expected xattrs to be retained, got %v", headers[1].Xattrs)
+	}
+}
+
+func TestGetOffsets(t *testing.T) {
+	b := regEntry("b", string(bytes.Repeat([]byte{'b'}, 1000)))
+	b.header.Xattrs = map[string]string{"user.k": "v"}
+	long := regEntry(strings.Repeat("long/", 30)+"name", "3")
+	archive := buildTar(t, regEntry("a", "1"), b, long, regEntry("c", ""))
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{RetainHeaders: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := regEntry("appended", "4")
+	if err := ts.(AppendTarSum).AppendEntry(extra.header, extra.data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	hts := ts.(HeaderTarSum)
+	headers, offsets := hts.GetHeaders(), hts.GetOffsets()
+	if len(offsets) != 5 || len(headers) != 5 {
+		t.Fatalf("expected 5 headers and offsets, got %d and %v", len(headers), offsets)
+	}
+	if offsets[4] != -1 {
+		t.Errorf("expected offset -1 for an appended entry, got %d", offsets[4])
+	}
+
+	// Reading from each offset starts with the entry's header.
+	ra := io.ReaderAt(bytes.NewReader(archive))
+	for i, offset := range offsets[:4] {
+		tr := tar.NewReader(io.NewSectionReader(ra, offset, int64(len(archive))-offset))
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Errorf("reading the header of %s at %d: %v", headers[i].Name, offset, err)
+			continue
+		}
+		if hdr.Name != headers[i].Name {
+			t.Errorf("Mismatched header at offset %d\n\tActual: %s\n\tExpected: %s", offset, hdr.Name, headers[i].Name)
+		}
+	}
+}
+
+func TestSkipFirstN(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"), regEntry("c", "three"))
+
+	full, err := ComputeFileSums(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+		SkipFirstN: 2,
+		ResumeSums: full[:2],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+
+	sums := ts.GetSums()
+	if len(sums) != 3 || sums[2].Name() != "c" || sums[2].Sum() != full[2].Sum() || sums[2].Pos() != 2 {
+		t.Errorf("Mismatched sums after resuming\n\tActual: %v\n\tExpected: %v", sums, full)
+	}
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	if _, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{SkipFirstN: 1, ResumeSums: full[:2]}); err == nil {
+		t.Error("expected an error for more resumed sums than skipped entries")
+	}
+}
+
+func TestAuditWriter(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("dir/b", "two"), regEntry("c\t0\tforged\nd", "three"))
+
+	var audit bytes.Buffer
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{AuditWriter: &audit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+
+	sums := ts.GetSums()
+	lines := strings.Split(strings.TrimSuffix(audit.String(), "\n"), "\n")
+	if len(lines) != len(sums) {
+		t.Fatalf("expected %d audit lines, got %d", len(sums), len(lines))
+	}
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		expected := []string{strconv.FormatInt(sums[i].Pos(), 10), strconv.Quote(sums[i].Name()), sums[i].Sum()}
+		if !reflect.DeepEqual(fields, expected) {
+			t.Errorf("Mismatched audit line %d\n\tActual: %q\n\tExpected: %q", i, fields, expected)
+		}
+	}
+
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	ustar := buildTar(t, regEntry("file", "data"))
+
+	// Rewrite the ustar magic and version as the old GNU magic.
+	gnu := append([]byte(nil), ustar...)
+	copy(gnu[257:265], "ustar  \x00")
+	fixHeaderChecksum(gnu[:512])
+
+	pax := buildTar(t, regEntry(strings.Repeat("x", 120), "data"))
+
+	testCases := []struct {
+		name    string
+		archive []byte
+		format  tar.Format
+	}{
+		{"ustar", ustar, tar.FormatUSTAR},
+		{"gnu", gnu, tar.FormatGNU},
+		{"pax", pax, tar.FormatPAX},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSum(bytes.NewReader(testCase.archive), true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		if format := ts.(FormatTarSum).Format(); format != testCase.format {
+			t.Errorf("Mismatched format for %s\n\tActual: %v\n\tExpected: %v", testCase.name, format, testCase.format)
+		}
+	}
+
+	if sumArchive(t, gnu, Version1) != sumArchive(t, ustar, Version1) {
+		t.Error("expected the format not to affect the checksum")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	ustar := buildTar(t, regEntry("file", "data"))
+
+	longName := strings.Repeat("long/", 30) + "name"
+	gnuLongName := buildTar(t,
+		testEntry{header: &tar.Header{Name: "././@LongLink", Typeflag: tar.TypeGNULongName, Size: int64(len(longName) + 1), ModTime: time.Unix(1400000000, 0)}, data: []byte(longName + "\x00")},
+		regEntry("truncated", "data"))
+
+	pax := buildTar(t, regEntry(strings.Repeat("x", 120), "data"))
+
+	sparseRecords := paxRecord("GNU.sparse.size", "8") + paxRecord("GNU.sparse.numblocks", "1") + paxRecord("GNU.sparse.map", "4,4")
+	sparse := buildTar(t,
+		testEntry{header: &tar.Header{Name: "PaxHeaders/file", Typeflag: tar.TypeXHeader, Size: int64(len(sparseRecords)), ModTime: time.Unix(1400000000, 0)}, data: []byte(sparseRecords)},
+		regEntry("file", "data"))
+
+	base256 := append([]byte(nil), ustar...)
+	setBase256(base256[108:116], 0)
+	fixHeaderChecksum(base256[:512])
+
+	testCases := []struct {
+		name     string
+		archive  []byte
+		features tar.Features
+	}{
+		{"ustar", ustar, 0},
+		{"gnu long name", gnuLongName, tar.FeatureGNULongName},
+		{"pax", pax, tar.FeaturePAX},
+		{"pax sparse", sparse, tar.FeaturePAX | tar.FeatureSparse},
+		{"base-256", base256, tar.FeatureBase256},
+		{"global pax", buildTar(t, globalEntry(paxRecord("comment", "c")), regEntry("file", "data")), tar.FeaturePAX},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSum(bytes.NewReader(testCase.archive), true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		if features := ts.(FeatureTarSum).Features(); features != testCase.features {
+			t.Errorf("Mismatched features for %s\n\tActual: %v\n\tExpected: %v", testCase.name, features, testCase.features)
+		}
+	}
+
+	if sumArchive(t, base256, Version1) != sumArchive(t, ustar, Version1) {
+		t.Error("expected the features not to affect the checksum")
+	}
+}
+
+func TestDigestArchive(t *testing.T) {
+	archive := buildTar(t, regEntry("b", "two"), regEntry("a", "one"))
+
+	result, err := DigestArchive(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := sumArchive(t, archive, Version1); result.Sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", result.Sum, expected)
+	}
+	if result.Version != Version1 || result.HashName != "sha256" || result.FileCount != 2 || result.TotalBytes != int64(len(archive)) {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if len(result.Sums) != 2 || result.Sums[0].Name() != "b" {
+		t.Errorf("expected per-file sums in archive order, got %v", result.Sums)
+	}
+}
+
+func TestHeaderTransform(t *testing.T) {
+	archive := buildTar(t, regEntry("build/root/bin/app", "binary"), regEntry("build/root/etc/conf", "config"))
+	reference := buildTar(t, regEntry("bin/app", "binary"), regEntry("etc/conf", "config"))
+
+	stripPrefix := func(hdr *tar.Header) error {
+		if !strings.HasPrefix(hdr.Name, "build/root/") {
+			return fmt.Errorf("unexpected path %q", hdr.Name)
+		}
+		hdr.Name = strings.TrimPrefix(hdr.Name, "build/root/")
+		return nil
+	}
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{
+		DisableCompression: true,
+		HeaderTransform:    stripPrefix,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := readAllSizes(t, ts, buf32K)
+
+	if sum, expected := ts.Sum(nil), sumArchive(t, reference, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+	if ts.GetSums().GetFile("bin/app") == nil {
+		t.Errorf("expected sums to use the transformed names, got %v", ts.GetSums())
+	}
+	if sum, expected := sumArchive(t, out, Version1), sumArchive(t, reference, Version1); sum != expected {
+		t.Errorf("expected the re-emitted archive to use the transformed headers")
+	}
+
+	ts, err = NewTarSumWithOptions(bytes.NewReader(reference), Version1, Options{HeaderTransform: stripPrefix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(ts); err == nil || !strings.Contains(err.Error(), "bin/app") {
+		t.Errorf("expected a transform error naming the entry, got %v", err)
+	}
+
+	grow := func(hdr *tar.Header) error {
+		hdr.Size++
+		return nil
+	}
+	ts, err = NewTarSumWithOptions(bytes.NewReader(reference), Version1, Options{HeaderTransform: grow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(ts); err == nil || !strings.Contains(err.Error(), "changed its size") {
+		t.Errorf("expected an error for a transform changing the size, got %v", err)
+	}
+}
+
+func TestWeakSum(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", strings.Repeat("two", 5000)))
+
+	for _, input := range [][]byte{archive, gzipBytes(t, archive)} {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(input), Version1, Options{WeakSum: true, AutoDecompress: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+
+		if weak, expected := ts.(WeakTarSum).WeakSum(), adler32.Checksum(input); weak != expected {
+			t.Errorf("Mismatched weak sum\n\tActual: %08x\n\tExpected: %08x", weak, expected)
+		}
+		if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+			t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+		}
+	}
+}
+
+func TestContentDigest(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", strings.Repeat("two", 5000)))
+	// Padding after the end-of-archive marker, as tar writes to fill a
+	// record, is part of the content.
+	padded := append(append([]byte(nil), archive...), make([]byte, 10240)...)
+
+	for _, input := range [][]byte{archive, padded, gzipBytes(t, archive)} {
+		for _, mode := range []Mode{ModeReemit, ModePassthrough, ModeDigestOnly} {
+			ts, err := NewTarSumWithOptions(bytes.NewReader(input), Version1, Options{ContentDigest: true, AutoDecompress: true, Mode: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			readAllSizes(t, ts, buf32K)
+
+			expected := fmt.Sprintf("sha256:%x", sha256.Sum256(input))
+			if digest := ts.(ContentTarSum).ContentDigest(); digest != expected {
+				t.Errorf("%s: Mismatched content digest\n\tActual: %s\n\tExpected: %s", mode, digest, expected)
+			}
+			if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+				t.Errorf("%s: Mismatched sum\n\tActual: %s\n\tExpected: %s", mode, sum, expected)
+			}
+		}
+	}
+}
+
+func TestEmptyNames(t *testing.T) {
+	root := testEntry{header: &tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}
+	archive := buildTar(t, root, regEntry("file", "data"))
+
+	sums, err := ComputeFileSums(bytes.NewReader(archive), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 2 || sums[0].Name() != "" || sums.GetFile("") == nil {
+		t.Errorf("expected the root entry to be summed under the empty name, got %v", sums)
+	}
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{RejectEmptyNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(ts); err != ErrEmptyEntryName {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, ErrEmptyEntryName)
+	}
+}
+
+func TestStrictSpecialFiles(t *testing.T) {
+	fifo := &tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, Mode: 0644, ModTime: time.Unix(1400000000, 0)}
+	smuggling := *fifo
+	smuggling.Size = 8
+	clean := buildTar(t, testEntry{header: fifo}, deviceEntry("null", tar.TypeChar, 1, 3), regEntry("file", "data"))
+	illegal := buildTar(t, testEntry{header: &smuggling, data: []byte("smuggled")}, regEntry("file", "data"))
+
+	testCases := []struct {
+		name    string
+		archive []byte
+		strict  bool
+		err     error
+	}{
+		{"clean", clean, false, nil},
+		{"clean strict", clean, true, nil},
+		{"data", illegal, false, nil},
+		{"data strict", illegal, true, ErrSpecialFileData},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(testCase.archive), Version1, Options{StrictSpecialFiles: testCase.strict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(ts); err != testCase.err {
+			t.Errorf("Mismatched error for %s\n\tActual: %v\n\tExpected: %v", testCase.name, err, testCase.err)
+		}
+	}
+}
+
+func TestGetSumsWhere(t *testing.T) {
+	archive := buildTar(t, regEntry("etc/b", "1"), regEntry("usr/a", "2"), regEntry("etc/a", "3"), regEntry("etc/c", "4"))
+	ts, err := NewTarSum(bytes.NewReader(archive), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+	order := func(sums FileInfoSums) []string {
+		var names []string
+		for _, fis := range sums {
+			names = append(names, fis.Name())
+		}
+		return names
+	}
+	before := order(ts.GetSums())
+
+	etc := ts.(FilterTarSum).GetSumsWhere(func(name string) bool { return strings.HasPrefix(name, "etc/") })
+	if len(etc) != 3 {
+		t.Fatalf("expected three sums beneath etc/, got %v", order(etc))
+	}
+	for i := 1; i < len(etc); i++ {
+		if etc[i-1].Sum() > etc[i].Sum() {
+			t.Errorf("expected sums in sum order, got %v", etc)
+		}
+	}
+	if after := order(ts.GetSums()); !reflect.DeepEqual(after, before) {
+		t.Errorf("Mismatched order of GetSums\n\tActual: %v\n\tExpected: %v", after, before)
+	}
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
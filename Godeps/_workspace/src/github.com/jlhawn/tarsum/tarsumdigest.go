@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"strings"
 
@@ -31,10 +32,11 @@ type Digest struct {
 	headerBuffer    bytes.Buffer
 	tarReader       *tar.Reader
 	entryHash       sha256.Resumable
-	sums            fileInfoSums
+	sums            FileInfoSums
 	fileCounter     int64
 	bytesWritten    int64
 	currentFilename string
+	currentType     byte
 	pad             int
 
 	// Miscellaneous State/Fields
@@ -55,6 +57,10 @@ const (
 )
 
 func NewDigest(version Version) (*Digest, error) {
+	if version == VersionGlobalPAX {
+		// Digest sums global PAX headers as files.
+		return nil, ErrVersionNotImplemented
+	}
 	headerSelector, err := getTarHeaderSelector(version)
 	if err != nil {
 		return nil, err
@@ -96,21 +102,17 @@ func (tsd *Digest) Reset() {
 	tsd.digestStage = stageReadHeader
 	tsd.tarReader = new(tar.Reader)
 	tsd.entryHash = sha256.New()
-	tsd.sums = fileInfoSums{}
+	tsd.sums = FileInfoSums{}
 	tsd.fileCounter = 0
 	tsd.bytesWritten = 0
 	tsd.currentFilename = ""
+	tsd.currentType = 0
 	tsd.pad = 0
 	tsd.err = nil
 }
 
 func (tsd *Digest) encodeHeader(header *tar.Header) error {
-	for _, elem := range tsd.headerSelector.selectHeaders(header) {
-		if _, err := tsd.entryHash.Write([]byte(elem[0] + elem[1])); err != nil {
-			return err
-		}
-	}
-	return nil
+	return writeEntryHeaders(tsd.entryHash, tsd.version, tsd.headerSelector.selectHeaders(header), header.Size)
 }
 
 func (tsd *Digest) Write(p []byte) (n int, err error) {
@@ -193,6 +195,7 @@ func (tsd *Digest) readHeader() (err error) {
 
 	// Write selected header info to current entry hasher.
 	tsd.currentFilename = strings.TrimSuffix(strings.TrimPrefix(tarHeader.Name, "./"), "/")
+	tsd.currentType = tarHeader.Typeflag
 	if err = tsd.encodeHeader(tarHeader); err != nil {
 		return
 	}
@@ -253,9 +256,10 @@ func (tsd *Digest) skipPadding() error {
 	// Finalize the entry, reset the current entry
 	// hasher, incremement the file counter, etc.
 	tsd.sums = append(tsd.sums, fileInfoSum{
-		name: tsd.currentFilename,
-		sum:  hex.EncodeToString(tsd.entryHash.Sum(nil)),
-		pos:  tsd.fileCounter,
+		name:     tsd.currentFilename,
+		sum:      hex.EncodeToString(tsd.entryHash.Sum(nil)),
+		pos:      tsd.fileCounter,
+		typeflag: tsd.currentType,
 	})
 	tsd.entryHash.Reset()
 	tsd.fileCounter++
@@ -310,7 +314,7 @@ func (tsd *Digest) Label() string {
 }
 
 func (tsd *Digest) Sum(extra []byte) []byte {
-	tsd.sums.SortBySums()
+	tsd.sums.sortSums(tsd.version)
 	hasher := sha256.New()
 
 	if extra != nil {
@@ -318,7 +322,7 @@ func (tsd *Digest) Sum(extra []byte) []byte {
 	}
 
 	for _, fis := range tsd.sums {
-		hasher.Write([]byte(fis.Sum()))
+		writeAggregateSum(hasher, tsd.version, fis.Sum())
 	}
 
 	return hasher.Sum(nil)
@@ -336,6 +340,7 @@ func (tsd *Digest) State() ([]byte, error) {
 	// 		fileCounter     int64
 	// 		digestStage     string
 	// 		currentFilename string
+	// 		currentType     byte
 	// 		pad             int
 	// 		headerBuffer    bytes.Buffer
 	// 		tarReader       *tar.Reader
@@ -397,13 +402,14 @@ func (tsd *Digest) State() ([]byte, error) {
 	}
 
 	// Encode all FileInfoSums.
-	tsd.sums.SortBySums()
+	tsd.sums.sortSums(tsd.version)
 
 	if err := encoder.Encode(len(tsd.sums)); err != nil {
 		return nil, err
 	}
 
-	for _, fis := range tsd.sums {
+	types := make([]byte, len(tsd.sums))
+	for i, fis := range tsd.sums {
 		vals := []interface{}{
 			fis.Name(), fis.Sum(), fis.Pos(),
 		}
@@ -413,6 +419,15 @@ func (tsd *Digest) State() ([]byte, error) {
 				return nil, err
 			}
 		}
+		types[i] = fileTypeflag(fis)
+	}
+
+	// The types of the entries follow the rest of the state so that
+	// states saved without them can still be restored.
+	for _, val := range []interface{}{types, tsd.currentType} {
+		if err := encoder.Encode(val); err != nil {
+			return nil, err
+		}
 	}
 
 	return buf.Bytes(), nil
@@ -488,7 +503,7 @@ func (tsd *Digest) Restore(state []byte) error {
 		return err
 	}
 
-	tsd.sums = make(fileInfoSums, 0, lenSums)
+	tsd.sums = make(FileInfoSums, 0, lenSums)
 
 	for i := 0; i < lenSums; i++ {
 		var fis fileInfoSum
@@ -501,5 +516,63 @@ func (tsd *Digest) Restore(state []byte) error {
 		tsd.sums = append(tsd.sums, fis)
 	}
 
-	return nil
+	// Decode the types of the entries, if the state has them.
+	var types []byte
+	if err := decoder.Decode(&types); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(types) != len(tsd.sums) {
+		return fmt.Errorf("tarsum: digest state has %d entry types for %d sums", len(types), len(tsd.sums))
+	}
+	for i, fis := range tsd.sums {
+		f := fis.(fileInfoSum)
+		f.typeflag = types[i]
+		tsd.sums[i] = f
+	}
+	return decoder.Decode(&tsd.currentType)
+}
+
+// TarSumHash adapts a Digest to the hash.Hash interface so that it can be
+// used by code expecting a content hasher. Unlike Digest.Sum, which treats
+// its argument as extra data to hash, TarSumHash.Sum appends the digest to
+// its argument as hash.Hash requires.
+//
+// The bytes written must form a tar archive, written in order; this is not
+// a hash over arbitrary byte streams. As hash.Hash requires, Write never
+// returns an error: an error parsing the archive stops the digest, and is
+// reported by Err.
+type TarSumHash struct {
+	*Digest
+}
+
+var _ hash.Hash = (*TarSumHash)(nil)
+
+// NewHash returns a TarSumHash which computes TarSums of the given version.
+func NewHash(version Version) (*TarSumHash, error) {
+	d, err := NewDigest(version)
+	if err != nil {
+		return nil, err
+	}
+	return &TarSumHash{Digest: d}, nil
+}
+
+// Sum appends the TarSum digest of the archive written so far to b.
+func (tsh *TarSumHash) Sum(b []byte) []byte {
+	return append(b, tsh.Digest.Sum(nil)...)
+}
+
+// Write writes p to the digest. It always returns len(p) and a nil error;
+// an error parsing the archive is reported by Err instead, and causes later
+// writes to be ignored.
+func (tsh *TarSumHash) Write(p []byte) (int, error) {
+	tsh.Digest.Write(p)
+	return len(p), nil
+}
+
+// Err returns the error which stopped the digest, such as for malformed tar
+// input, or nil. Sum is only meaningful when Err returns nil.
+func (tsh *TarSumHash) Err() error {
+	return tsh.Digest.err
 }
@@ -0,0 +1,62 @@
+package tarsum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"strings"
+	"testing"
+)
+
+func TestTarSumHash(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "hello"), regEntry("b", string(bytes.Repeat([]byte{'b'}, 3000))))
+	expected := sumArchive(t, archive, Version1)
+
+	tsh, err := NewHash(Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Feed the archive in uneven chunks, as generic hashing code might.
+	var h hash.Hash = tsh
+	for i := 0; i < len(archive); i += 700 {
+		end := i + 700
+		if end > len(archive) {
+			end = len(archive)
+		}
+		if _, err := h.Write(archive[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := h.Sum([]byte("prefix"))
+	if !bytes.HasPrefix(sum, []byte("prefix")) {
+		t.Fatal("expected Sum to append to its argument")
+	}
+	if digest := hex.EncodeToString(sum[len("prefix"):]); !strings.HasSuffix(expected, ":"+digest) {
+		t.Errorf("Mismatched digest\n\tActual: %s\n\tExpected: %s", digest, expected)
+	}
+
+	if err := tsh.Err(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	h.Reset()
+	if digest := hex.EncodeToString(h.Sum(nil)); strings.HasSuffix(expected, ":"+digest) {
+		t.Error("expected Reset to discard written data")
+	}
+
+	// Malformed input is reported by Err rather than by Write.
+	corrupt := append([]byte(nil), archive...)
+	corrupt[148] ^= 0xff // the header checksum
+	if n, err := h.Write(corrupt); n != len(corrupt) || err != nil {
+		t.Errorf("expected Write to consume the input without error, got %d, %v", n, err)
+	}
+	if err := tsh.Err(); err == nil {
+		t.Error("expected Err to report the malformed header")
+	}
+	h.Reset()
+	if err := tsh.Err(); err != nil {
+		t.Errorf("expected Reset to clear the error, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package tarsum
+
+import (
+	"io"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// crlfNormalizer writes the bytes written to it to w with each CRLF line
+// ending replaced by LF, as Options.TextNormalize requires.
+type crlfNormalizer struct {
+	w  io.Writer
+	cr bool // a CR ended the previous write
+}
+
+func (c *crlfNormalizer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	out := make([]byte, 0, len(p)+1)
+	if c.cr && p[0] != '\n' {
+		out = append(out, '\r')
+	}
+	c.cr = false
+	for i, b := range p {
+		if b == '\r' {
+			if i+1 == len(p) {
+				// The next write decides whether it ends a line.
+				c.cr = true
+				continue
+			}
+			if p[i+1] == '\n' {
+				continue
+			}
+		}
+		out = append(out, b)
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes a CR left pending by the last write.
+func (c *crlfNormalizer) flush() error {
+	if !c.cr {
+		return nil
+	}
+	c.cr = false
+	_, err := c.w.Write([]byte{'\r'})
+	return err
+}
+
+// isTextEntry reports whether the body of the current entry is normalized
+// as text by Options.TextNormalize.
+func (ts *tarSum) isTextEntry(typeflag byte) bool {
+	if ts.opts.TextNormalize == nil || ts.skip {
+		return false
+	}
+	if typeflag != tar.TypeReg && typeflag != tar.TypeRegA {
+		return false
+	}
+	return ts.opts.TextNormalize(ts.currentFile)
+}
@@ -0,0 +1,77 @@
+package tarsum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTextNormalize(t *testing.T) {
+	lf := strings.Repeat("line one\nline two\n", 2000)
+	crlf := strings.Replace(lf, "\n", "\r\n", -1)
+	binary := "\x00\r\n\x01"
+	archive := func(text string) []byte {
+		return buildTar(t, regEntry("README.txt", text), regEntry("blob.bin", binary))
+	}
+	opts := Options{DisableCompression: true, TextNormalize: func(name string) bool { return strings.HasSuffix(name, ".txt") }}
+	sum := func(b []byte, opts Options) (string, []byte) {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(b), Version1, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Small reads split CRLF pairs across writes to the hash.
+		out, _ := readAllSizes(t, ts, 7)
+		return ts.Sum(nil), out
+	}
+
+	lfSum, _ := sum(archive(lf), opts)
+	crlfSum, out := sum(archive(crlf), opts)
+	if lfSum != crlfSum {
+		t.Errorf("Mismatched sums of text differing in line endings\n\tActual: %s\n\tExpected: %s", crlfSum, lfSum)
+	}
+	if !bytes.Contains(out, []byte(crlf[:100])) {
+		t.Error("expected the re-emitted body not to be normalized")
+	}
+	if sumArchive(t, archive(lf), Version1) == sumArchive(t, archive(crlf), Version1) {
+		t.Errorf("%s: expected text differing in line endings to hash differently without normalizing", Version1)
+	}
+
+	// Bodies which are not selected are hashed as they are.
+	other, _ := sum(buildTar(t, regEntry("README.txt", lf), regEntry("blob.bin", "\x00\n\x01")), opts)
+	if other == lfSum {
+		t.Error("expected files not selected as text not to be normalized")
+	}
+	// A lone CR is kept.
+	lone, _ := sum(archive(strings.Replace(lf, "\n", "\r", -1)), opts)
+	if lone == lfSum {
+		t.Error("expected a lone CR not to be normalized")
+	}
+}
+
+func TestCRLFNormalizer(t *testing.T) {
+	testCases := []struct {
+		writes   []string
+		expected string
+	}{
+		{[]string{"a\r\nb"}, "a\nb"},
+		{[]string{"a\r", "\nb"}, "a\nb"},
+		{[]string{"a\r", "", "\nb"}, "a\nb"},
+		{[]string{"a\r", "b\r"}, "a\rb\r"},
+		{[]string{"\r\r\n\r"}, "\r\n\r"},
+	}
+	for _, testCase := range testCases {
+		var buf bytes.Buffer
+		c := &crlfNormalizer{w: &buf}
+		for _, w := range testCase.writes {
+			if _, err := c.Write([]byte(w)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != testCase.expected {
+			t.Errorf("Mismatched output for %q\n\tActual: %q\n\tExpected: %q", testCase.writes, buf.String(), testCase.expected)
+		}
+	}
+}
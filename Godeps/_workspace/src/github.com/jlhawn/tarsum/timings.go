@@ -0,0 +1,56 @@
+package tarsum
+
+import (
+	"time"
+)
+
+// The phases of Read reported by TimingTarSum.GetTimings.
+const (
+	// TimingTarRead is the time spent parsing the input archive.
+	TimingTarRead = "tar read"
+	// TimingHash is the time spent hashing headers and data.
+	TimingHash = "hash"
+	// TimingTarWrite is the time spent re-encoding the archive.
+	TimingTarWrite = "tar write"
+	// TimingCompress is the time spent writing, flushing and closing the
+	// output writer, which compresses the output unless compression is
+	// disabled.
+	TimingCompress = "compress"
+)
+
+// TimingTarSum extends TarSum with the time spent in each phase of Read.
+// TarSums created with Options.RecordTimings implement it.
+type TimingTarSum interface {
+	TarSum
+	GetTimings() map[string]time.Duration
+}
+
+// GetTimings returns a copy of the cumulative durations of each phase of Read
+// so far, keyed by the Timing constants. It returns nil unless
+// Options.RecordTimings was set.
+func (ts *tarSum) GetTimings() map[string]time.Duration {
+	if ts.timings == nil {
+		return nil
+	}
+	timings := make(map[string]time.Duration, len(ts.timings))
+	for phase, d := range ts.timings {
+		timings[phase] = d
+	}
+	return timings
+}
+
+// startTiming returns the start time of a phase, without reading the clock
+// unless timings are being recorded.
+func (ts *tarSum) startTiming() time.Time {
+	if ts.timings == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// stopTiming adds the time since start to the given phase.
+func (ts *tarSum) stopTiming(phase string, start time.Time) {
+	if ts.timings != nil {
+		ts.timings[phase] += time.Since(start)
+	}
+}
@@ -0,0 +1,36 @@
+package tarsum
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecordTimings(t *testing.T) {
+	archive := buildTar(t, regEntry("a", strings.Repeat("a", 100000)), regEntry("b", "b"))
+
+	ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, Options{RecordTimings: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf8K)
+
+	timings := ts.(TimingTarSum).GetTimings()
+	for _, phase := range []string{TimingTarRead, TimingHash, TimingTarWrite, TimingCompress} {
+		if _, ok := timings[phase]; !ok {
+			t.Errorf("no timing recorded for %q", phase)
+		}
+	}
+	if sum, expected := ts.Sum(nil), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	ts, err = NewTarSum(bytes.NewReader(archive), true, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf8K)
+	if timings := ts.(TimingTarSum).GetTimings(); timings != nil {
+		t.Errorf("expected no timings by default, got %v", timings)
+	}
+}
@@ -0,0 +1,56 @@
+package tarsum
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// MaxTrailingBytes is the largest number of bytes following the archive kept
+// with Options.CaptureTrailingData. Read returns ErrTrailingDataTooLarge if
+// there are more.
+const MaxTrailingBytes = 1 << 20
+
+// TrailingTarSum extends TarSum with the bytes found after the end of the
+// archive. All TarSums created by this package implement it.
+type TrailingTarSum interface {
+	TarSum
+	// TrailingBytes returns the bytes which followed the end-of-archive
+	// marker, or nil if there were none or Options.CaptureTrailingData
+	// was not set.
+	TrailingBytes() []byte
+}
+
+func (ts *tarSum) TrailingBytes() []byte {
+	return ts.trailing
+}
+
+// captureTrailingData reads the remainder of the input following the end
+// of the archive into ts.trailing, dropping the zero blocks padding the
+// archive. At most MaxTrailingBytes are kept.
+func (ts *tarSum) captureTrailingData() error {
+	block := make([]byte, volumeBlockSize)
+	for {
+		n, err := io.ReadFull(ts.Reader, block)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if n > 0 && !isZeroBlock(block[:n]) {
+				ts.trailing = block[:n]
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isZeroBlock(block) {
+			break
+		}
+	}
+	rest, err := ioutil.ReadAll(io.LimitReader(ts.Reader, MaxTrailingBytes-volumeBlockSize+1))
+	if err != nil {
+		return err
+	}
+	if trailing := append(block, rest...); len(trailing) <= MaxTrailingBytes {
+		ts.trailing = trailing
+		return nil
+	}
+	return ErrTrailingDataTooLarge
+}
@@ -0,0 +1,89 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// The fixture is an archive padded to a 10 KiB record, followed by an
+// armored signature.
+const (
+	signedFixture    = "testdata/signed.tar"
+	signedFixtureSum = "tarsum.v1+sha256:34395a80248caf95e2a2a3194e38ed4e7405866f28cbe40d7cc8a6d531d5762b"
+	signedSignature  = "-----BEGIN PGP SIGNATURE-----\n\niQEzBAABCAAdFiEEexampleexampleexampleexampleexamAFAlk0\nZRQACgkQexampleexampl=\n=ab12\n-----END PGP SIGNATURE-----\n"
+)
+
+func TestCaptureTrailingData(t *testing.T) {
+	signed, err := ioutil.ReadFile(signedFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := signed[:len(signed)-len(signedSignature)]
+	if sum := sumArchive(t, unsigned, Version1); sum != signedFixtureSum {
+		t.Fatalf("Mismatched sum of the unsigned fixture\n\tActual: %s\n\tExpected: %s", sum, signedFixtureSum)
+	}
+
+	capture := Options{CaptureTrailingData: true}
+	testCases := []struct {
+		name     string
+		input    []byte
+		opts     Options
+		trailing string
+	}{
+		{"signed", signed, capture, signedSignature},
+		// Trailing bytes are those following the decompressed archive.
+		{"compressed", gzipBytes(t, signed), Options{CaptureTrailingData: true, AutoDecompress: true}, signedSignature},
+		{"unsigned", unsigned, capture, ""},
+		{"not captured", signed, Options{}, ""},
+		// The three entries and the end-of-archive marker, unpadded.
+		{"unpadded", append(append([]byte(nil), unsigned[:3584]...), signedSignature...), capture, signedSignature},
+		{"zero tail", append(append([]byte(nil), unsigned...), make([]byte, 100)...), capture, ""},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(testCase.input), Version1, testCase.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		if sum := ts.Sum(nil); sum != signedFixtureSum {
+			t.Errorf("%s: Mismatched sums\n\tActual: %s\n\tExpected: %s", testCase.name, sum, signedFixtureSum)
+		}
+		if trailing := ts.(TrailingTarSum).TrailingBytes(); string(trailing) != testCase.trailing {
+			t.Errorf("%s: Mismatched trailing bytes\n\tActual: %q\n\tExpected: %q", testCase.name, trailing, testCase.trailing)
+		}
+	}
+
+	// ModePassthrough returns the trailing bytes with the rest of the input.
+	ts, err := NewTarSumWithOptions(bytes.NewReader(signed), Version1, Options{CaptureTrailingData: true, Mode: ModePassthrough})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := readAllSizes(t, ts, buf32K); !bytes.Equal(out, signed) {
+		t.Error("expected ModePassthrough to return the input with its trailing bytes")
+	}
+	if trailing := ts.(TrailingTarSum).TrailingBytes(); string(trailing) != signedSignature {
+		t.Errorf("Mismatched trailing bytes in ModePassthrough\n\tActual: %q\n\tExpected: %q", trailing, signedSignature)
+	}
+
+	// At most MaxTrailingBytes are kept.
+	for _, size := range []int{MaxTrailingBytes, MaxTrailingBytes + 1} {
+		input := append(append([]byte(nil), signed...), bytes.Repeat([]byte{'x'}, size-len(signedSignature))...)
+		ts, err := NewTarSumWithOptions(bytes.NewReader(input), Version1, capture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(ioutil.Discard, ts)
+		if size > MaxTrailingBytes && err != ErrTrailingDataTooLarge {
+			t.Errorf("expected ErrTrailingDataTooLarge for %d trailing bytes, got %v", size, err)
+		}
+		if size <= MaxTrailingBytes && (err != nil || len(ts.(TrailingTarSum).TrailingBytes()) != size) {
+			t.Errorf("expected %d trailing bytes to be kept, got %d, %v", size, len(ts.(TrailingTarSum).TrailingBytes()), err)
+		}
+	}
+
+	if _, err := NewTarSumWithOptions(bytes.NewReader(signed), Version1, Options{CaptureTrailingData: true, RejectTrailingData: true}); err == nil {
+		t.Error("expected capturing and rejecting trailing data to be rejected")
+	}
+}
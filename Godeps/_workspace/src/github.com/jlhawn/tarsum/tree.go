@@ -0,0 +1,145 @@
+package tarsum
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// TreeEntry is a file of a logical file set, such as a tree of a VCS
+// snapshot, to be summed by SumFromEntries.
+type TreeEntry struct {
+	// Path is the slash-separated path of the file.
+	Path string
+	// Mode holds the type of the file, os.ModeDir or os.ModeSymlink for
+	// directories and symlinks, and its permission bits.
+	Mode os.FileMode
+	// Content is the body of a regular file or the target of a symlink.
+	// It is not read for directories and may be nil.
+	Content io.Reader
+}
+
+// SumFromEntries computes the checksum of a canonical tar archive holding the
+// given file set, so that equal sets always have equal checksums. The archive
+// is also written to canonical when it is non-nil. It is canonicalized as
+// follows:
+//
+//   - Paths are cleaned with path.Clean, without any leading "/" or "./",
+//     and must be distinct. Directories are named with a trailing "/".
+//   - Every parent directory of a file is included, whether or not the set
+//     lists it.
+//   - Entries are sorted by path, element by element, so that the contents
+//     of a directory directly follow it, whatever their order in entries.
+//   - Directories have mode 0755 and symlinks 0777. Regular files have mode
+//     0755 if any execute bit of Mode is set and 0644 otherwise, as a git
+//     tree records them. No other file types are accepted.
+//   - The modification time is the Unix epoch, the owner and group are 0
+//     and no user or group names are recorded.
+//
+// Each body is read into memory while its entry is written. The entries are
+// checked before anything is written, but an error reading a body is only
+// found once the entries before it have been written to canonical, which
+// then holds a truncated archive.
+func SumFromEntries(entries []TreeEntry, v Version, canonical io.Writer) (string, error) {
+	files := make(map[string]TreeEntry, len(entries))
+	for _, e := range entries {
+		name := strings.TrimPrefix(path.Clean("/"+e.Path), "/")
+		if name == "" {
+			return "", fmt.Errorf("tarsum: tree entry %q has no name", e.Path)
+		}
+		if _, ok := files[name]; ok {
+			return "", fmt.Errorf("tarsum: duplicate tree entry %q", name)
+		}
+		if _, _, err := treeEntryType(name, e); err != nil {
+			return "", err
+		}
+		files[name] = e
+	}
+	for name := range files {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			if e, ok := files[dir]; ok {
+				if !e.Mode.IsDir() {
+					return "", fmt.Errorf("tarsum: tree entry %q is not a directory but %q is beneath it", dir, name)
+				}
+				continue
+			}
+			files[dir] = TreeEntry{Path: dir, Mode: os.ModeDir}
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Sort(byEntryName(names))
+
+	return sumWriter(func(tw *tar.Writer) error {
+		for _, name := range names {
+			hdr, body, err := treeEntryHeader(name, files[name])
+			if err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, v, canonical)
+}
+
+// byEntryName sorts names as lessEntryName orders them.
+type byEntryName []string
+
+func (n byEntryName) Len() int           { return len(n) }
+func (n byEntryName) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n byEntryName) Less(i, j int) bool { return lessEntryName(n[i], n[j]) }
+
+// treeEntryType returns the canonical type flag and mode of a tree entry.
+func treeEntryType(name string, e TreeEntry) (byte, int64, error) {
+	switch {
+	case e.Mode.IsDir():
+		return tar.TypeDir, 0755, nil
+	case e.Mode&os.ModeSymlink != 0:
+		return tar.TypeSymlink, 0777, nil
+	case e.Mode.IsRegular():
+		if e.Mode&0111 != 0 {
+			return tar.TypeReg, 0755, nil
+		}
+		return tar.TypeReg, 0644, nil
+	}
+	return 0, 0, fmt.Errorf("tarsum: tree entry %q has unsupported mode %v", name, e.Mode)
+}
+
+// treeEntryHeader returns the canonical header and body of a tree entry.
+func treeEntryHeader(name string, e TreeEntry) (*tar.Header, []byte, error) {
+	typeflag, mode, err := treeEntryType(name, e)
+	if err != nil {
+		return nil, nil, err
+	}
+	hdr := &tar.Header{Name: name, Typeflag: typeflag, Mode: mode, ModTime: time.Unix(0, 0)}
+	if typeflag == tar.TypeDir {
+		hdr.Name += "/"
+		return hdr, nil, nil
+	}
+	var body []byte
+	if e.Content != nil {
+		if body, err = ioutil.ReadAll(e.Content); err != nil {
+			return nil, nil, fmt.Errorf("tarsum: reading tree entry %q: %v", name, err)
+		}
+	}
+	if typeflag == tar.TypeSymlink {
+		hdr.Linkname = string(body)
+		return hdr, nil, nil
+	}
+	hdr.Size = int64(len(body))
+	return hdr, body, nil
+}
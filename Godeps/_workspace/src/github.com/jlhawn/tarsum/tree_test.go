@@ -0,0 +1,91 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestSumFromEntries(t *testing.T) {
+	tree := func(order []int) []TreeEntry {
+		all := []TreeEntry{
+			{Path: "README", Mode: 0664, Content: strings.NewReader("readme")},
+			{Path: "bin/run", Mode: 0775, Content: strings.NewReader("#!/bin/sh\n")},
+			{Path: "./src/lib/a.go", Mode: 0644, Content: strings.NewReader("package lib")},
+			{Path: "src/link", Mode: os.ModeSymlink, Content: strings.NewReader("lib/a.go")},
+			{Path: "src-extra/", Mode: os.ModeDir | 0700},
+		}
+		entries := make([]TreeEntry, len(order))
+		for i, j := range order {
+			entries[i] = all[j]
+		}
+		return entries
+	}
+
+	var canonical bytes.Buffer
+	sum, err := SumFromEntries(tree([]int{0, 1, 2, 3, 4}), Version1, &canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reordered, err := SumFromEntries(tree([]int{4, 2, 0, 3, 1}), Version1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != reordered {
+		t.Errorf("Mismatched sums of reordered entries\n\tActual: %s\n\tExpected: %s", reordered, sum)
+	}
+	if expected := sumArchive(t, canonical.Bytes(), Version1); sum != expected {
+		t.Errorf("Mismatched sum of the canonical archive\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	type entry struct {
+		name     string
+		mode     int64
+		typeflag byte
+		linkname string
+	}
+	expected := []entry{
+		{"README", 0644, tar.TypeReg, ""},
+		{"bin/", 0755, tar.TypeDir, ""},
+		{"bin/run", 0755, tar.TypeReg, ""},
+		{"src/", 0755, tar.TypeDir, ""},
+		{"src/lib/", 0755, tar.TypeDir, ""},
+		{"src/lib/a.go", 0644, tar.TypeReg, ""},
+		{"src/link", 0777, tar.TypeSymlink, "lib/a.go"},
+		{"src-extra/", 0755, tar.TypeDir, ""},
+	}
+	tr := tar.NewReader(&canonical)
+	for _, e := range expected {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := entry{hdr.Name, hdr.Mode, hdr.Typeflag, hdr.Linkname}
+		if actual != e || hdr.ModTime.Unix() != 0 {
+			t.Errorf("Mismatched canonical entry\n\tActual: %+v at %v\n\tExpected: %+v", actual, hdr.ModTime, e)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected the end of the canonical archive, got %v", err)
+	}
+
+	for _, bad := range [][]TreeEntry{
+		{{Path: "a", Content: strings.NewReader("1")}, {Path: "./a", Content: strings.NewReader("2")}},
+		{{Path: "a", Content: strings.NewReader("1")}, {Path: "a/b", Content: strings.NewReader("2")}},
+		{{Path: "dev", Mode: os.ModeDevice}},
+	} {
+		if _, err := SumFromEntries(bad, Version1, nil); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+
+	// Entries are checked before anything is written.
+	var partial bytes.Buffer
+	if _, err := SumFromEntries([]TreeEntry{{Path: "a", Content: strings.NewReader("1")}, {Path: "z", Mode: os.ModeNamedPipe}}, Version1, &partial); err == nil || partial.Len() != 0 {
+		t.Errorf("expected an unsupported mode to fail before writing, got %v after %d bytes", err, partial.Len())
+	}
+}
@@ -0,0 +1,195 @@
+package tarsum
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// UnionSum drains each of the archives read from readers and returns a
+// checksum over the set of files they contain together. A file is keyed by
+// its name and per-file sum, so a file present with the same content in
+// several archives is counted once, while files which share a name but
+// differ in content are all counted. The result does not depend on the
+// order of the archives or of the files within them.
+//
+// The set is aggregated as Sum aggregates the files of one archive, sorted
+// by sum, and labelled with the version and hash. This is a non-standard
+// aggregation: the union sum of a single archive equals its checksum only if
+// it has no duplicate files. Global PAX records are not carried over, as
+// those of different archives do not combine into one set.
+func UnionSum(readers []io.Reader, v Version) (string, error) {
+	seen := make(map[fileInfoSum]bool)
+	var union FileInfoSums
+	th := DefaultTHash
+	for _, r := range readers {
+		ts, err := newTarSumOptions(r, v, Options{Mode: ModeDigestOnly})
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			return "", err
+		}
+		th = ts.th
+		for _, fis := range ts.GetSums() {
+			k := fileInfoSum{name: fis.Name(), sum: fis.Sum()}
+			if !seen[k] {
+				seen[k] = true
+				union = append(union, k)
+			}
+		}
+	}
+
+	// Files which share a name are ordered by sum like any other, as
+	// their positions in different archives are unrelated.
+	sort.Sort(bySum{FileInfoSums: union})
+	h := th.Hash()
+	for _, fis := range union {
+		writeAggregateSum(h, v, fis.Sum())
+	}
+	return v.String() + "+" + th.Name() + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// aufsOpaqueMarker marks a directory whose contents in lower layers are
+// hidden by an aufs layer.
+const aufsOpaqueMarker = aufsMetaPrefix + ".opq"
+
+// MergedSum returns the checksum of the filesystem formed by applying the
+// overlay archive on top of the base archive, as when squashing two layers,
+// without materializing the merged archive. An overlay entry shadows any
+// base entry with the same name, and one which is not a directory also
+// removes the contents of a base directory it replaces. Whiteouts, as aufs
+// ".wh." files or overlay character devices 0/0, remove the named base
+// entry and its contents, and an aufs opaque marker removes the contents of
+// its directory; whiteouts and markers are not part of the merged files.
+// Within each archive the last entry with a name wins. Names are compared
+// with any leading "./" and trailing "/" removed.
+//
+// The merged files are aggregated as Sum aggregates the files of one
+// archive, so the result equals the checksum of an archive holding exactly
+// the merged files, in any order. Global PAX records are not carried over.
+func MergedSum(base, overlay io.Reader, v Version) (string, error) {
+	merged := newMergedTree()
+	var th THash
+	for i, r := range []io.Reader{base, overlay} {
+		ts, err := newTarSumOptions(r, v, Options{Mode: ModeDigestOnly, RetainHeaders: true})
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+			return "", err
+		}
+		th = ts.th
+		// Every summed file has a retained header, in the same order.
+		headers := ts.GetHeaders()
+		for j, fis := range ts.GetSums() {
+			if i == 0 {
+				merged.set(fis.Name(), mergedFile{sum: fis.Sum(), dir: headers[j].Typeflag == tar.TypeDir})
+				continue
+			}
+			merged.applyOverlayEntry(headers[j], fis)
+		}
+	}
+
+	sums := make(FileInfoSums, 0, len(merged.files))
+	for name, f := range merged.files {
+		sums = append(sums, fileInfoSum{name: name, sum: f.sum})
+	}
+	sort.Sort(bySum{FileInfoSums: sums})
+	h := th.Hash()
+	for _, fis := range sums {
+		writeAggregateSum(h, v, fis.Sum())
+	}
+	return v.String() + "+" + th.Name() + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mergedFile is a file of the filesystem merged by MergedSum.
+type mergedFile struct {
+	sum string
+	dir bool
+}
+
+// mergedTree holds the files merged by MergedSum, keyed by name, along with
+// an index of the names beneath each directory so that the contents of a
+// directory can be removed without scanning every file.
+type mergedTree struct {
+	files map[string]mergedFile
+	// children maps a name to the names one level beneath it, split at
+	// the last "/". Names which are only directories of other names are
+	// indexed whether or not they are files themselves.
+	children map[string]map[string]bool
+}
+
+func newMergedTree() *mergedTree {
+	return &mergedTree{
+		files:    make(map[string]mergedFile),
+		children: make(map[string]map[string]bool),
+	}
+}
+
+// set records the named file, indexing it beneath its directories.
+func (t *mergedTree) set(name string, f mergedFile) {
+	t.files[name] = f
+	for name != "" {
+		parent := ""
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			parent = name[:i]
+		}
+		names := t.children[parent]
+		if names == nil {
+			names = make(map[string]bool)
+			t.children[parent] = names
+		}
+		if names[name] {
+			return
+		}
+		names[name] = true
+		name = parent
+	}
+}
+
+// applyOverlayEntry applies an entry of an overlay archive, with header h
+// and sum fis, to the merged files beneath it.
+func (t *mergedTree) applyOverlayEntry(h *tar.Header, fis FileInfoSumInterface) {
+	name := fis.Name()
+	if dir, base := path.Split(name); base == aufsOpaqueMarker {
+		t.removeContents(entryName(dir))
+		return
+	}
+	if target, ok := whiteoutTarget(h); ok {
+		target = entryName(target)
+		delete(t.files, target)
+		t.removeContents(target)
+		return
+	}
+	dir := h.Typeflag == tar.TypeDir
+	if prev, ok := t.files[name]; ok && prev.dir && !dir {
+		t.removeContents(name)
+	}
+	t.set(name, mergedFile{sum: fis.Sum(), dir: dir})
+}
+
+// removeContents removes the files beneath the named directory. The empty
+// name is that of the top level. Each name is removed from the index along
+// with its files, so the cost of all removals is bounded by the names set.
+func (t *mergedTree) removeContents(dir string) {
+	if dir == "" || dir == "." {
+		for name := range t.files {
+			if name != dir {
+				delete(t.files, name)
+			}
+		}
+		t.children = make(map[string]map[string]bool)
+		return
+	}
+	for name := range t.children[dir] {
+		delete(t.files, name)
+		t.removeContents(name)
+	}
+	delete(t.children, dir)
+}
@@ -0,0 +1,110 @@
+package tarsum
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+func TestUnionSum(t *testing.T) {
+	a := buildTar(t, regEntry("shared", "same"), regEntry("a", "only in a"))
+	b := buildTar(t, regEntry("b", "only in b"), regEntry("shared", "same"))
+	c := buildTar(t, regEntry("shared", "different"))
+
+	union := func(archives ...[]byte) string {
+		readers := make([]io.Reader, len(archives))
+		for i, archive := range archives {
+			readers[i] = bytes.NewReader(archive)
+		}
+		sum, err := UnionSum(readers, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	flat := buildTar(t, regEntry("a", "only in a"), regEntry("b", "only in b"), regEntry("shared", "same"))
+	if sum, expected := union(a, b), sumArchive(t, flat, Version1); sum != expected {
+		t.Errorf("Mismatched union sum\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+	if union(a, b) != union(b, a) {
+		t.Error("expected the union sum not to depend on the order of the archives")
+	}
+	if union(a, b) != union(a, b, a) {
+		t.Error("expected repeated archives to collapse")
+	}
+	if union(a, b) == union(a, b, c) {
+		t.Error("expected files sharing a name with different content to be counted")
+	}
+	if sum, expected := union(a, a), sumArchive(t, a, Version1); sum != expected {
+		t.Errorf("Mismatched union sum of one archive\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
+
+func TestMergedSum(t *testing.T) {
+	dir := func(name string) testEntry {
+		e := regEntry(name, "")
+		e.header.Typeflag = tar.TypeDir
+		return e
+	}
+	base := buildTar(t,
+		dir("etc/"), regEntry("etc/hostname", "base"), regEntry("etc/removed", "gone"),
+		dir("var/"), dir("var/cache/"), regEntry("var/cache/a", "cached"),
+		dir("opt/"), regEntry("opt/old", "hidden"),
+		dir("lib/"), regEntry("lib/x.so", "library"),
+		regEntry("kept", "unchanged"))
+	overlay := buildTar(t,
+		regEntry("./etc/hostname", "overlay"),
+		regEntry("etc/.wh.removed", ""),
+		deviceEntry("var/cache", tar.TypeChar, 0, 0),
+		regEntry("opt/.wh..wh..opq", ""), regEntry("opt/new", "visible"),
+		regEntry("lib", "now a file"),
+		regEntry("added", "new"))
+	flat := buildTar(t,
+		regEntry("added", "new"),
+		dir("etc/"), regEntry("./etc/hostname", "overlay"),
+		dir("var/"),
+		dir("opt/"), regEntry("opt/new", "visible"),
+		regEntry("lib", "now a file"),
+		regEntry("kept", "unchanged"))
+
+	for _, v := range []Version{Version0, Version1} {
+		sum, err := MergedSum(bytes.NewReader(base), bytes.NewReader(overlay), v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := sumArchive(t, flat, v); sum != expected {
+			t.Errorf("%s: Mismatched merged sum\n\tActual: %s\n\tExpected: %s", v, sum, expected)
+		}
+	}
+
+	// An empty overlay leaves the base unchanged.
+	sum, err := MergedSum(bytes.NewReader(base), bytes.NewReader(buildTar(t)), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := sumArchive(t, base, Version1); sum != expected {
+		t.Errorf("Mismatched merged sum with an empty overlay\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	// Contents are removed beneath directories which have no entries of
+	// their own, and names added again after a removal are kept.
+	base = buildTar(t,
+		regEntry("deep/a/b/c", "nested"), regEntry("deep/a/d", "sibling"),
+		regEntry("deeper", "not beneath"), regEntry("other/a", "kept"))
+	overlay = buildTar(t,
+		regEntry("deep/.wh.a", ""),
+		regEntry("deep/a/b/new", "added"),
+		regEntry("other/.wh.a", ""), regEntry("other/a", "again"))
+	flat = buildTar(t,
+		regEntry("deep/a/b/new", "added"), regEntry("deeper", "not beneath"), regEntry("other/a", "again"))
+	sum, err = MergedSum(bytes.NewReader(base), bytes.NewReader(overlay), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := sumArchive(t, flat, Version1); sum != expected {
+		t.Errorf("Mismatched merged sum of nested removals\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+}
@@ -0,0 +1,396 @@
+package tarsum
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// standardTHashes are the hashes which may be named in a checksum.
+// NOTE: DO NOT include MD5 or SHA1, which are considered insecure.
+var standardTHashes = map[string]THash{
+	"sha256": DefaultTHash,
+	"sha512": NewTHash("sha512", sha512.New),
+}
+
+// ErrSumMismatch is returned when the checksum of an archive does not match
+// the expected checksum.
+type ErrSumMismatch struct {
+	Got  string
+	Want string
+}
+
+func (e ErrSumMismatch) Error() string {
+	return fmt.Sprintf("tarsum: checksum mismatch: got %s, want %s", e.Got, e.Want)
+}
+
+// newTarSumForChecksum creates a tarSum reading from r which uses the
+// version and hash named in the given checksum, which must be of the form
+// {version}+{hash}:{hex}.
+func newTarSumForChecksum(r io.Reader, checksum string) (*tarSum, error) {
+	v, hashName, err := parseChecksumLabel(checksum)
+	if err != nil {
+		return nil, err
+	}
+	th, ok := standardTHashes[hashName]
+	if !ok {
+		return nil, fmt.Errorf("tarsum: unknown hash name: %q", hashName)
+	}
+
+	return newTarSumHash(r, true, v, th)
+}
+
+// parseChecksumLabel returns the version and hash name from a checksum of
+// the form {version}+{hash}:{hex}.
+func parseChecksumLabel(checksum string) (Version, string, error) {
+	label := checksum
+	if i := strings.Index(checksum, ":"); i >= 0 {
+		label = checksum[:i]
+	}
+	parts := strings.SplitN(label, "+", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("tarsum: checksum %q should be of the form {version}+{hash}:{hex}", checksum)
+	}
+
+	v, err := GetVersionFromTarsum(parts[0])
+	if err != nil {
+		return 0, "", err
+	}
+	return v, parts[1], nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// CopyVerify copies src to dst while computing its checksum with the
+// version and hash named in expected. Once src is exhausted the checksum is
+// compared to expected and an ErrSumMismatch is returned if they differ.
+// The bytes written to dst are exactly the bytes read from src, so callers
+// must discard dst if an error is returned.
+func CopyVerify(dst io.Writer, src io.Reader, expected string) (written int64, err error) {
+	cw := &countingWriter{w: dst}
+	ts, err := newTarSumForChecksum(io.TeeReader(src, cw), expected)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return cw.n, err
+	}
+	// Copy anything following the end of the archive so that dst
+	// receives the complete input.
+	if _, err := io.Copy(cw, src); err != nil {
+		return cw.n, err
+	}
+
+	if got := ts.Sum(nil); got != expected {
+		return cw.n, ErrSumMismatch{Got: got, Want: expected}
+	}
+	return cw.n, nil
+}
+
+// CopyVerifyMulti copies src to each of dsts while computing its checksum
+// with the version and hash named in expected, as CopyVerify does for a
+// single writer. The first error from any writer stops the copy and is
+// returned. If the checksum does not match an ErrSumMismatch is returned
+// once src is exhausted. In either case the writers may already have
+// received some or all of the data, and the caller is responsible for
+// discarding it.
+func CopyVerifyMulti(expected string, src io.Reader, dsts ...io.Writer) (written int64, err error) {
+	return CopyVerify(io.MultiWriter(dsts...), src, expected)
+}
+
+// VerifyResult describes the outcome of verifying an archive against an
+// expected checksum.
+type VerifyResult struct {
+	// Computed is the checksum of the archive and Expected the checksum
+	// it was compared against.
+	Computed string
+	Expected string
+	// Match reports whether Computed and Expected are equal.
+	Match bool
+	// Version and HashName are parsed from Expected and were used to
+	// compute Computed.
+	Version  Version
+	HashName string
+	// Sums holds the per-file sums of the archive in archive order.
+	Sums FileInfoSums
+}
+
+// VerifyDetailed computes the checksum of the archive read from r using
+// the version and hash named in expected and reports how it compares. An
+// error is only returned if expected cannot be parsed or the archive cannot
+// be read; a mismatch is reported through the result.
+func VerifyDetailed(r io.Reader, expected string) (*VerifyResult, error) {
+	ts, err := newTarSumForChecksum(r, expected)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, ts); err != nil {
+		return nil, err
+	}
+
+	// Copy the sums before Sum sorts them.
+	sums := append(FileInfoSums(nil), ts.GetSums()...)
+	computed := ts.Sum(nil)
+
+	return &VerifyResult{
+		Computed: computed,
+		Expected: expected,
+		Match:    computed == expected,
+		Version:  ts.Version(),
+		HashName: ts.Hash().Name(),
+		Sums:     sums,
+	}, nil
+}
+
+// VerifyTarSum reports whether the checksum of the archive read from r
+// matches expected.
+func VerifyTarSum(r io.Reader, expected string) (bool, error) {
+	result, err := VerifyDetailed(r, expected)
+	if err != nil {
+		return false, err
+	}
+	return result.Match, nil
+}
+
+// ErrFileSumMismatch is returned when the sum of a file in an archive does
+// not match the sum expected for it.
+type ErrFileSumMismatch struct {
+	Name string
+	Got  string
+	Want string
+}
+
+func (e ErrFileSumMismatch) Error() string {
+	return fmt.Sprintf("tarsum: sum mismatch for file %q: got %s, want %s", e.Name, e.Got, e.Want)
+}
+
+// VerifyingTarSum is a TarSum which checks the sum of each file against an
+// expected manifest as soon as the file has been read, failing fast on the
+// first mismatch.
+type VerifyingTarSum struct {
+	TarSum
+	expected map[string]string
+	seen     map[string]bool
+	extra    []string
+}
+
+// NewVerifyingTarSum creates a VerifyingTarSum reading an archive from r.
+// The expected map is keyed by file name and holds the per-file sums as
+// reported by GetSums. Read returns an ErrFileSumMismatch as soon as a file
+// with an expected sum does not match it. The re-encoded tar stream returned
+// by Read is not compressed.
+func NewVerifyingTarSum(r io.Reader, v Version, expected map[string]string) (*VerifyingTarSum, error) {
+	ts, err := newTarSum(r, true, v)
+	if err != nil {
+		return nil, err
+	}
+
+	vts := &VerifyingTarSum{
+		TarSum:   ts,
+		expected: expected,
+		seen:     make(map[string]bool, len(expected)),
+	}
+	ts.fileDone = vts.checkFile
+
+	return vts, nil
+}
+
+func (vts *VerifyingTarSum) checkFile(fis FileInfoSumInterface) error {
+	vts.seen[fis.Name()] = true
+
+	want, ok := vts.expected[fis.Name()]
+	if !ok {
+		vts.extra = append(vts.extra, fis.Name())
+		return nil
+	}
+	if fis.Sum() != want {
+		return ErrFileSumMismatch{Name: fis.Name(), Got: fis.Sum(), Want: want}
+	}
+	return nil
+}
+
+// Extra returns the names of files read so far which are not present in the
+// expected manifest, in archive order.
+func (vts *VerifyingTarSum) Extra() []string {
+	return vts.extra
+}
+
+// Missing returns the sorted names of files in the expected manifest which
+// have not been read. It is only meaningful once Read has returned io.EOF.
+func (vts *VerifyingTarSum) Missing() []string {
+	var missing []string
+	for name := range vts.expected {
+		if !vts.seen[name] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// FileVerifyResult describes how the per-file sums of an archive compare
+// with the sums expected for them. Each list names files in archive order,
+// except Missing, which is in the order of the expected sums.
+type FileVerifyResult struct {
+	// Matched lists the files whose sums match the expected ones.
+	Matched []string
+	// Modified lists the files with expected sums which do not match.
+	Modified []string
+	// Missing lists the files with expected sums which are not in the
+	// archive.
+	Missing []string
+	// Extra lists the files in the archive without expected sums.
+	Extra []string
+}
+
+// Match reports whether every file matched, with none missing or extra.
+func (r *FileVerifyResult) Match() bool {
+	return len(r.Modified) == 0 && len(r.Missing) == 0 && len(r.Extra) == 0
+}
+
+// VerifyFileSums computes the per-file sums of the archive read from r and
+// compares them by name with expected, such as the sums returned by GetSums
+// for the same archive. A name occurring more than once is compared
+// occurrence by occurrence in order. An error is only returned if the
+// archive cannot be read; differences are reported through the result.
+func VerifyFileSums(r io.Reader, expected FileInfoSums, v Version) (*FileVerifyResult, error) {
+	sums, err := ComputeFileSums(r, v)
+	if err != nil {
+		return nil, err
+	}
+	return compareFileSums(sums, expected), nil
+}
+
+// compareFileSums compares sums with expected by name, as VerifyFileSums
+// does.
+func compareFileSums(sums, expected FileInfoSums) *FileVerifyResult {
+	want := make(map[string][]string, len(expected))
+	for _, fis := range expected {
+		want[fis.Name()] = append(want[fis.Name()], fis.Sum())
+	}
+	result := &FileVerifyResult{}
+	for _, fis := range sums {
+		w := want[fis.Name()]
+		switch {
+		case len(w) == 0:
+			result.Extra = append(result.Extra, fis.Name())
+			continue
+		case w[0] == fis.Sum():
+			result.Matched = append(result.Matched, fis.Name())
+		default:
+			result.Modified = append(result.Modified, fis.Name())
+		}
+		want[fis.Name()] = w[1:]
+	}
+	for _, fis := range expected {
+		if w := want[fis.Name()]; len(w) > 0 {
+			result.Missing = append(result.Missing, fis.Name())
+			want[fis.Name()] = w[1:]
+		}
+	}
+	return result
+}
+
+// VerifyUnordered computes the per-file sums of the archive read from r and
+// reports whether they are the same multiset of name and sum pairs as
+// expected, regardless of the order of the entries, so that an archive whose
+// entries were reordered still verifies against a manifest listing them in
+// their original order. An error is only returned if the archive cannot be
+// read.
+func VerifyUnordered(r io.Reader, expected FileInfoSums, v Version) (bool, error) {
+	sums, err := ComputeFileSums(r, v)
+	if err != nil {
+		return false, err
+	}
+	if len(sums) != len(expected) {
+		return false, nil
+	}
+
+	counts := make(map[[2]string]int, len(expected))
+	for _, fis := range expected {
+		counts[[2]string{fis.Name(), fis.Sum()}]++
+	}
+	for _, fis := range sums {
+		key := [2]string{fis.Name(), fis.Sum()}
+		if counts[key] == 0 {
+			return false, nil
+		}
+		counts[key]--
+	}
+	return true, nil
+}
+
+// ErrDiverged is returned by an EarlyExitVerifier when an archive is found
+// to differ from the expected one.
+type ErrDiverged struct {
+	// Name is the file at which the archive diverges: the first file
+	// whose name or sum differs from the one expected at its position, or
+	// the first expected file missing from the end of the archive.
+	Name string
+}
+
+func (e ErrDiverged) Error() string {
+	return fmt.Sprintf("tarsum: archive diverges from the expected files at %q", e.Name)
+}
+
+// EarlyExitVerifier is a TarSum which checks that an archive has exactly
+// the expected files, in order, stopping at the first that differs.
+type EarlyExitVerifier struct {
+	TarSum
+	expected FileInfoSums
+	pos      int
+}
+
+// NewEarlyExitVerifier creates an EarlyExitVerifier reading an archive from
+// r. The expected sums are those reported by GetSums for the known archive,
+// in archive order. Read returns an ErrDiverged as soon as a file does not
+// match the name and sum expected at its position, without reading the rest
+// of the archive, or at the end of the archive if it has fewer files. The
+// re-encoded tar stream returned by Read is not compressed.
+func NewEarlyExitVerifier(r io.Reader, v Version, expected FileInfoSums) (*EarlyExitVerifier, error) {
+	ts, err := newTarSum(r, true, v)
+	if err != nil {
+		return nil, err
+	}
+
+	eev := &EarlyExitVerifier{
+		TarSum:   ts,
+		expected: expected,
+	}
+	ts.fileDone = eev.checkFile
+
+	return eev, nil
+}
+
+func (eev *EarlyExitVerifier) checkFile(fis FileInfoSumInterface) error {
+	if eev.pos >= len(eev.expected) {
+		return ErrDiverged{Name: fis.Name()}
+	}
+	want := eev.expected[eev.pos]
+	if fis.Name() != want.Name() || fis.Sum() != want.Sum() {
+		return ErrDiverged{Name: fis.Name()}
+	}
+	eev.pos++
+	return nil
+}
+
+func (eev *EarlyExitVerifier) Read(p []byte) (int, error) {
+	n, err := eev.TarSum.Read(p)
+	if err == io.EOF && eev.pos < len(eev.expected) {
+		return n, ErrDiverged{Name: eev.expected[eev.pos].Name()}
+	}
+	return n, err
+}
@@ -0,0 +1,247 @@
+package tarsum
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func fileSumMap(t *testing.T, archive []byte, v Version) map[string]string {
+	sums, err := ComputeFileSums(bytes.NewReader(archive), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]string, len(sums))
+	for _, fis := range sums {
+		m[fis.Name()] = fis.Sum()
+	}
+	return m
+}
+
+func TestVerifyingTarSum(t *testing.T) {
+	expected := fileSumMap(t, buildTar(t, regEntry("a", "1"), regEntry("b", "2"), regEntry("c", "3")), Version1)
+
+	// A tampered file fails as soon as it has been read.
+	tampered := buildTar(t, regEntry("a", "1"), regEntry("b", "X"), regEntry("c", "3"))
+	vts, err := NewVerifyingTarSum(bytes.NewReader(tampered), Version1, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(ioutil.Discard, vts)
+	mismatch, ok := err.(ErrFileSumMismatch)
+	if !ok {
+		t.Fatalf("expected ErrFileSumMismatch, got %v", err)
+	}
+	if mismatch.Name != "b" || mismatch.Want != expected["b"] {
+		t.Errorf("unexpected mismatch: %v", mismatch)
+	}
+
+	// Added and removed files are reported at EOF.
+	changed := buildTar(t, regEntry("a", "1"), regEntry("b", "2"), regEntry("d", "4"))
+	vts, err = NewVerifyingTarSum(bytes.NewReader(changed), Version1, expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, vts); err != nil {
+		t.Fatal(err)
+	}
+	if extra := vts.Extra(); !reflect.DeepEqual(extra, []string{"d"}) {
+		t.Errorf("unexpected extra files: %v", extra)
+	}
+	if missing := vts.Missing(); !reflect.DeepEqual(missing, []string{"c"}) {
+		t.Errorf("unexpected missing files: %v", missing)
+	}
+}
+
+func TestCopyVerify(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "1"), regEntry("b", "2"))
+	expected := sumArchive(t, archive, Version1)
+
+	var dst bytes.Buffer
+	written, err := CopyVerify(&dst, bytes.NewReader(archive), expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(len(archive)) || !bytes.Equal(dst.Bytes(), archive) {
+		t.Errorf("expected the input to be copied unmodified, wrote %d of %d bytes", written, len(archive))
+	}
+
+	wrong := sumArchive(t, buildTar(t, regEntry("a", "1")), Version1)
+	_, err = CopyVerify(ioutil.Discard, bytes.NewReader(archive), wrong)
+	if mismatch, ok := err.(ErrSumMismatch); !ok || mismatch.Got != expected || mismatch.Want != wrong {
+		t.Errorf("expected ErrSumMismatch, got %v", err)
+	}
+}
+
+func TestCopyVerifyMulti(t *testing.T) {
+	archive := buildTar(t, regEntry("a", "1"), regEntry("b", "2"))
+	expected := sumArchive(t, archive, Version1)
+
+	var cache, registry bytes.Buffer
+	written, err := CopyVerifyMulti(expected, bytes.NewReader(archive), &cache, &registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(len(archive)) || !bytes.Equal(cache.Bytes(), archive) || !bytes.Equal(registry.Bytes(), archive) {
+		t.Errorf("expected the input to be copied to every writer, wrote %d of %d bytes", written, len(archive))
+	}
+
+	failing := &faultyWriter{w: ioutil.Discard, failAfter: 100}
+	if _, err := CopyVerifyMulti(expected, bytes.NewReader(archive), ioutil.Discard, failing); err != errInjected {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, errInjected)
+	}
+
+	wrong := sumArchive(t, buildTar(t, regEntry("a", "1")), Version1)
+	if _, err := CopyVerifyMulti(wrong, bytes.NewReader(archive), ioutil.Discard); err == nil {
+		t.Error("expected ErrSumMismatch")
+	} else if _, ok := err.(ErrSumMismatch); !ok {
+		t.Errorf("expected ErrSumMismatch, got %v", err)
+	}
+}
+
+func TestVerifyDetailed(t *testing.T) {
+	archive := buildTar(t, regEntry("b", "2"), regEntry("a", "1"))
+	expected := sumArchive(t, archive, Version0)
+
+	result, err := VerifyDetailed(bytes.NewReader(archive), expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Match || result.Computed != expected || result.Version != Version0 || result.HashName != "sha256" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if len(result.Sums) != 2 || result.Sums[0].Name() != "b" {
+		t.Errorf("expected per-file sums in archive order, got %v", result.Sums)
+	}
+
+	other := sumArchive(t, buildTar(t, regEntry("a", "1")), Version0)
+	if ok, err := VerifyTarSum(bytes.NewReader(archive), other); err != nil || ok {
+		t.Errorf("expected verification to fail, got %t, %v", ok, err)
+	}
+	if _, err := VerifyTarSum(bytes.NewReader(archive), "bogus"); err == nil {
+		t.Error("expected an error for an unparseable checksum")
+	}
+}
+
+func TestVerifyFileSums(t *testing.T) {
+	stored := buildTar(t, regEntry("same", "1"), regEntry("changed", "2"), regEntry("removed", "3"), regEntry("dup", "4"), regEntry("dup", "5"))
+	expected, err := ComputeFileSums(bytes.NewReader(stored), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := buildTar(t, regEntry("added", "6"), regEntry("changed", "changed"), regEntry("same", "1"), regEntry("dup", "4"), regEntry("dup", "changed"))
+	result, err := VerifyFileSums(bytes.NewReader(received), expected, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &FileVerifyResult{
+		Matched:  []string{"same", "dup"},
+		Modified: []string{"changed", "dup"},
+		Missing:  []string{"removed"},
+		Extra:    []string{"added"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Mismatched result\n\tActual: %+v\n\tExpected: %+v", result, want)
+	}
+	if result.Match() {
+		t.Error("expected the result not to match")
+	}
+
+	result, err = VerifyFileSums(bytes.NewReader(stored), expected, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Match() || len(result.Matched) != 5 {
+		t.Errorf("expected every file to match: %+v", result)
+	}
+}
+
+func TestVerifyUnordered(t *testing.T) {
+	stored := buildTar(t, regEntry("a", "1"), regEntry("b", "2"), regEntry("dup", "3"), regEntry("dup", "4"))
+	expected, err := ComputeFileSums(bytes.NewReader(stored), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		desc     string
+		archive  []byte
+		expected bool
+	}{
+		{"reordered", buildTar(t, regEntry("dup", "3"), regEntry("b", "2"), regEntry("a", "1"), regEntry("dup", "4")), true},
+		{"reordered duplicates", buildTar(t, regEntry("dup", "4"), regEntry("b", "2"), regEntry("dup", "3"), regEntry("a", "1")), true},
+		{"modified", buildTar(t, regEntry("dup", "4"), regEntry("b", "changed"), regEntry("dup", "3"), regEntry("a", "1")), false},
+		{"swapped duplicates", buildTar(t, regEntry("a", "1"), regEntry("b", "2"), regEntry("dup", "3"), regEntry("dup", "3")), false},
+		{"renamed", buildTar(t, regEntry("b", "1"), regEntry("a", "2"), regEntry("dup", "3"), regEntry("dup", "4")), false},
+		{"missing", buildTar(t, regEntry("a", "1"), regEntry("b", "2"), regEntry("dup", "3")), false},
+		{"extra", buildTar(t, regEntry("a", "1"), regEntry("b", "2"), regEntry("dup", "3"), regEntry("dup", "4"), regEntry("c", "5")), false},
+	}
+	for _, testCase := range testCases {
+		ok, err := VerifyUnordered(bytes.NewReader(testCase.archive), expected, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != testCase.expected {
+			t.Errorf("Mismatched verification of the %s archive\n\tActual: %t\n\tExpected: %t", testCase.desc, ok, testCase.expected)
+		}
+	}
+
+	// The reordered archive has the same checksum but not the same bytes.
+	// The checksum still depends on the order of entries with the same name.
+	reordered := testCases[0].archive
+	if sumArchive(t, reordered, Version1) != sumArchive(t, stored, Version1) {
+		t.Error("expected reordering not to change the checksum")
+	}
+	if bytes.Equal(reordered, stored) {
+		t.Error("expected the reordered archive to differ")
+	}
+}
+
+func TestEarlyExitVerifier(t *testing.T) {
+	entries := make([]testEntry, 10000)
+	for i := range entries {
+		entries[i] = regEntry(fmt.Sprintf("file%05d", i), fmt.Sprintf("content %d", i))
+	}
+	known := buildTar(t, entries...)
+	expected, err := ComputeFileSums(bytes.NewReader(known), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func(archive []byte) (int64, error) {
+		cr := &countingReader{r: bytes.NewReader(archive)}
+		eev, err := NewEarlyExitVerifier(cr, Version1, expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(ioutil.Discard, eev)
+		return cr.n, err
+	}
+
+	if _, err := verify(known); err != nil {
+		t.Errorf("expected the known archive to verify, got %v", err)
+	}
+
+	changed := append([]testEntry(nil), entries...)
+	changed[2] = regEntry("file00002", "changed")
+	n, err := verify(buildTar(t, changed...))
+	if expected := (ErrDiverged{Name: "file00002"}); err != expected {
+		t.Errorf("Mismatched error\n\tActual: %v\n\tExpected: %v", err, expected)
+	}
+	if n > int64(len(known))/10 {
+		t.Errorf("expected verification to stop early, read %d of %d bytes", n, len(known))
+	}
+
+	_, err = verify(buildTar(t, entries[:9999]...))
+	if expected := (ErrDiverged{Name: "file09999"}); err != expected {
+		t.Errorf("Mismatched error for a truncated archive\n\tActual: %v\n\tExpected: %v", err, expected)
+	}
+	_, err = verify(buildTar(t, append(append([]testEntry(nil), entries...), regEntry("extra", "x"))...))
+	if expected := (ErrDiverged{Name: "extra"}); err != expected {
+		t.Errorf("Mismatched error for an extended archive\n\tActual: %v\n\tExpected: %v", err, expected)
+	}
+}
@@ -1,7 +1,11 @@
 package tarsum
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,6 +24,65 @@ const (
 	Version1
 	// NOTE: this variable will be either the latest or an unsettled next-version of the TarSum calculation
 	VersionDev
+	// VersionMtimeNano is a non-standard version which includes the
+	// modification time with full nanosecond precision, as found in PAX
+	// mtime records. Its sums are not comparable with the other versions.
+	VersionMtimeNano
+	// The value after VersionMtimeNano is no longer used. It was a version
+	// prefixing each entry's data with its declared size, which Version1
+	// already hashes; VersionHardened separates headers from data instead.
+	_
+	// VersionCleanLinks is a non-standard version which cleans the target
+	// of each symlink with path.Clean before hashing it, so that targets
+	// such as "./bar" and "bar" hash identically. Its sums are not
+	// comparable with the other versions.
+	VersionCleanLinks
+	// VersionWhiteout is a non-standard version which hashes overlay
+	// whiteouts (character devices 0/0) and aufs ".wh." whiteout files as
+	// the same representation of the deleted path, so that a layer's sum
+	// is stable across storage driver conversions. Opaque directory markers
+	// are not canonicalized. Its sums are not comparable with the other
+	// versions.
+	VersionWhiteout
+	// VersionSeparated is a non-standard version which prefixes each file
+	// sum with its length, encoded as a fixed-width big-endian integer,
+	// when aggregating them into the checksum. File sums of different
+	// lengths, as from mixed digest sizes, can then never be split in more
+	// than one way. Its headers are those of Version1, but its sums are not
+	// comparable with the other versions.
+	VersionSeparated
+	// VersionGlobalPAX is a non-standard version which aggregates the
+	// records of global PAX headers into the checksum, sorted by keyword,
+	// ahead of the file sums; later records override earlier ones with the
+	// same keyword. The global header entries themselves are not summed as
+	// files, as they are by the other versions, so the checksum does not
+	// depend on how the records are ordered or split between headers. Its
+	// sums are not comparable with the other versions.
+	VersionGlobalPAX
+	// VersionNoMode is a non-standard version which leaves the mode of
+	// each entry out of its header hash: the permission bits, the setuid,
+	// setgid and sticky bits, and any file type bits stored with them. The
+	// type of the entry is still hashed from its typeflag, and every other
+	// Version1 header is hashed as is, so that a checksum is unchanged by
+	// chmod alone.
+	// Its sums are not comparable with the other versions.
+	VersionNoMode
+	// VersionHardened is a collision-hardened, non-standard version which
+	// separates the sections of each file hash: the Version1 headers are
+	// written after a fixed tag and their count, with every key and value
+	// prefixed by its length, and the data after a second tag and the
+	// entry's declared size. No header can then be read as the start of
+	// the data, or the data as more headers. Its sums are not comparable
+	// with the other versions.
+	VersionHardened
+	// VersionTypedNames is a non-standard version which tells a directory
+	// and a non-directory with the same name, such as "dir/" and "dir",
+	// apart when aggregating the file sums: they are ordered by sum like
+	// any two different files, rather than by position as the other
+	// versions order every repeated name, so the checksum does not depend
+	// on which of them comes first. Its headers are those of Version1, but
+	// its sums are not comparable with the other versions.
+	VersionTypedNames
 )
 
 // Get a list of all known tarsum Version
@@ -32,9 +95,17 @@ func GetVersions() []Version {
 }
 
 var tarSumVersions = map[Version]string{
-	Version0:   "tarsum",
-	Version1:   "tarsum.v1",
-	VersionDev: "tarsum.dev",
+	Version0:          "tarsum",
+	Version1:          "tarsum.v1",
+	VersionDev:        "tarsum.dev",
+	VersionMtimeNano:  "tarsum.mtimenano",
+	VersionCleanLinks: "tarsum.cleanlinks",
+	VersionWhiteout:   "tarsum.whiteout",
+	VersionSeparated:  "tarsum.separated",
+	VersionGlobalPAX:  "tarsum.globalpax",
+	VersionNoMode:     "tarsum.nomode",
+	VersionHardened:   "tarsum.hardened",
+	VersionTypedNames: "tarsum.typednames",
 }
 
 func (tsv Version) String() string {
@@ -59,6 +130,18 @@ func GetVersionFromTarsum(tarsum string) (Version, error) {
 var (
 	ErrNotVersion            = errors.New("string does not include a TarSum Version")
 	ErrVersionNotImplemented = errors.New("TarSum Version is not yet implemented")
+	ErrInvalidTHash          = errors.New("TarSum THash must have a name and produce a non-nil hash")
+	ErrTrailingData          = errors.New("TarSum archive has data following the end-of-archive marker")
+	ErrInputTooLarge         = errors.New("TarSum input exceeds the maximum number of bytes")
+	ErrTrailingDataTooLarge  = errors.New("TarSum archive is followed by more than MaxTrailingBytes")
+	ErrTooManyNames          = errors.New("TarSum archive exceeds the maximum number of distinct entry names")
+	ErrHeaderChecksum        = tar.ErrChecksum // returned with Options.StrictHeaders
+	ErrBlockSize             = tar.ErrBlockSize
+	ErrEmptyEntryName        = errors.New("TarSum archive has an entry with an empty name")
+	ErrSpecialFileData       = errors.New("TarSum archive has a device or fifo entry with data")
+	ErrBadVolume             = errors.New("TarSum archive volume does not continue the previous volume")
+	ErrAppendFinished        = errors.New("TarSum entry appended after the archive was summed")
+	ErrClosed                = errors.New("TarSum is closed")
 )
 
 // tarHeaderSelector is the interface which different versions
@@ -77,17 +160,17 @@ func (f tarHeaderSelectFunc) selectHeaders(h *tar.Header) (orderedHeaders [][2]s
 func v0TarHeaderSelect(h *tar.Header) (orderedHeaders [][2]string) {
 	return [][2]string{
 		{"name", h.Name},
-		{"mode", strconv.Itoa(int(h.Mode))},
-		{"uid", strconv.Itoa(h.Uid)},
-		{"gid", strconv.Itoa(h.Gid)},
-		{"size", strconv.Itoa(int(h.Size))},
-		{"mtime", strconv.Itoa(int(h.ModTime.UTC().Unix()))},
+		{"mode", strconv.FormatInt(h.Mode, 10)},
+		{"uid", strconv.FormatInt(int64(h.Uid), 10)},
+		{"gid", strconv.FormatInt(int64(h.Gid), 10)},
+		{"size", strconv.FormatInt(h.Size, 10)},
+		{"mtime", strconv.FormatInt(h.ModTime.UTC().Unix(), 10)},
 		{"typeflag", string([]byte{h.Typeflag})},
 		{"linkname", h.Linkname},
 		{"uname", h.Uname},
 		{"gname", h.Gname},
-		{"devmajor", strconv.Itoa(int(h.Devmajor))},
-		{"devminor", strconv.Itoa(int(h.Devminor))},
+		{"devmajor", strconv.FormatInt(h.Devmajor, 10)},
+		{"devminor", strconv.FormatInt(h.Devminor, 10)},
 	}
 }
 
@@ -116,10 +199,137 @@ func v1TarHeaderSelect(h *tar.Header) (orderedHeaders [][2]string) {
 	return
 }
 
+func mtimeNanoTarHeaderSelect(h *tar.Header) (orderedHeaders [][2]string) {
+	// Start with the v1 headers and reinsert 'mtime' as the 5th element,
+	// formatted as seconds and zero-padded nanoseconds.
+	v1headers := v1TarHeaderSelect(h)
+	orderedHeaders = make([][2]string, 0, len(v1headers)+1)
+	orderedHeaders = append(orderedHeaders, v1headers[0:5]...)
+	orderedHeaders = append(orderedHeaders, [2]string{"mtime", fmt.Sprintf("%d.%09d", h.ModTime.Unix(), h.ModTime.Nanosecond())})
+	orderedHeaders = append(orderedHeaders, v1headers[5:]...)
+
+	return
+}
+
+func cleanLinksTarHeaderSelect(h *tar.Header) (orderedHeaders [][2]string) {
+	if h.Typeflag != tar.TypeSymlink || h.Linkname == "" {
+		return v1TarHeaderSelect(h)
+	}
+	c := *h
+	c.Linkname = path.Clean(h.Linkname)
+	return v1TarHeaderSelect(&c)
+}
+
+// aufsWhiteoutPrefix marks an aufs whiteout file; aufsMetaPrefix marks
+// aufs metadata entries, such as opaque directory markers, which share it.
+const (
+	aufsWhiteoutPrefix = ".wh."
+	aufsMetaPrefix     = ".wh..wh."
+)
+
+// whiteoutTarget returns the path deleted by a whiteout entry, or false if
+// the entry is not a whiteout.
+func whiteoutTarget(h *tar.Header) (string, bool) {
+	name := path.Clean(h.Name)
+	switch {
+	case h.Typeflag == tar.TypeChar && h.Devmajor == 0 && h.Devminor == 0:
+		return name, true
+	case h.Typeflag == tar.TypeReg || h.Typeflag == tar.TypeRegA:
+		dir, base := path.Split(name)
+		if strings.HasPrefix(base, aufsWhiteoutPrefix) && !strings.HasPrefix(base, aufsMetaPrefix) {
+			return path.Join(dir, strings.TrimPrefix(base, aufsWhiteoutPrefix)), true
+		}
+	}
+	return "", false
+}
+
+func whiteoutTarHeaderSelect(h *tar.Header) (orderedHeaders [][2]string) {
+	if target, ok := whiteoutTarget(h); ok {
+		return [][2]string{
+			{"name", target},
+			{"whiteout", "1"},
+		}
+	}
+	return v1TarHeaderSelect(h)
+}
+
+func noModeTarHeaderSelect(h *tar.Header) (orderedHeaders [][2]string) {
+	// Drop 'mode', the 2nd element of the v1 headers.
+	v1headers := v1TarHeaderSelect(h)
+	return append(v1headers[0:1:1], v1headers[2:]...)
+}
+
 var registeredHeaderSelectors = map[Version]tarHeaderSelectFunc{
-	Version0:   v0TarHeaderSelect,
-	Version1:   v1TarHeaderSelect,
-	VersionDev: v1TarHeaderSelect,
+	Version0:          v0TarHeaderSelect,
+	Version1:          v1TarHeaderSelect,
+	VersionDev:        v1TarHeaderSelect,
+	VersionMtimeNano:  mtimeNanoTarHeaderSelect,
+	VersionCleanLinks: cleanLinksTarHeaderSelect,
+	VersionWhiteout:   whiteoutTarHeaderSelect,
+	VersionSeparated:  v1TarHeaderSelect,
+	VersionGlobalPAX:  v1TarHeaderSelect,
+	VersionNoMode:     noModeTarHeaderSelect,
+	VersionHardened:   v1TarHeaderSelect,
+	VersionTypedNames: v1TarHeaderSelect,
+}
+
+// The tags VersionHardened writes ahead of the header and data sections of
+// each file hash.
+const (
+	hardenedHeaderTag = "tarsum.hardened.header\x00"
+	hardenedDataTag   = "tarsum.hardened.data\x00"
+)
+
+// unknownDataLength is written by VersionHardened as the length of data
+// which is not known in advance, as for normalized text.
+const unknownDataLength = ^uint64(0)
+
+// writeEntryHeaders writes the selected headers of an entry to h, the hash
+// of the entry, as version v does. size is the number of bytes of data to
+// follow them, or -1 if it is not known in advance.
+func writeEntryHeaders(h io.Writer, v Version, headers [][2]string, size int64) error {
+	if v != VersionHardened {
+		for _, elem := range headers {
+			if _, err := h.Write([]byte(elem[0] + elem[1])); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var buf []byte
+	putLength := func(n uint64) {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], n)
+		buf = append(buf, length[:]...)
+	}
+	buf = append(buf, hardenedHeaderTag...)
+	putLength(uint64(len(headers)))
+	for _, elem := range headers {
+		putLength(uint64(len(elem[0])))
+		buf = append(buf, elem[0]...)
+		putLength(uint64(len(elem[1])))
+		buf = append(buf, elem[1]...)
+	}
+	buf = append(buf, hardenedDataTag...)
+	if size < 0 {
+		putLength(unknownDataLength)
+	} else {
+		putLength(uint64(size))
+	}
+	_, err := h.Write(buf)
+	return err
+}
+
+// writeAggregateSum writes a file sum to h, the hash aggregating the sums of
+// an archive into its checksum, as version v does.
+func writeAggregateSum(h io.Writer, v Version, sum string) {
+	if v == VersionSeparated {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(sum)))
+		h.Write(length[:])
+	}
+	h.Write([]byte(sum))
 }
 
 func getTarHeaderSelector(v Version) (tarHeaderSelector, error) {
@@ -0,0 +1,525 @@
+package tarsum
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// setHeaderSize rewrites the size field of the header block at offset and
+// fixes up its checksum, without touching the entry data.
+func setHeaderSize(archive []byte, offset int, size int64) []byte {
+	crafted := append([]byte(nil), archive...)
+	header := crafted[offset : offset+512]
+
+	copy(header[124:136], fmt.Sprintf("%011o\x00", size))
+	fixHeaderChecksum(header)
+
+	return crafted
+}
+
+// fixHeaderChecksum recomputes the checksum of a modified header block.
+func fixHeaderChecksum(header []byte) {
+	copy(header[148:156], "        ")
+	var chksum int64
+	for _, c := range header {
+		chksum += int64(c)
+	}
+	copy(header[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+}
+
+func deviceEntry(name string, typeflag byte, major, minor int64) testEntry {
+	return testEntry{header: &tar.Header{
+		Name:     name,
+		Mode:     0600,
+		Typeflag: typeflag,
+		Devmajor: major,
+		Devminor: minor,
+		ModTime:  time.Unix(1400000000, 0),
+	}}
+}
+
+// The v0 and v1 selectors already include devmajor and devminor, so device
+// nodes are distinguished without a dedicated version.
+func TestDeviceNumbers(t *testing.T) {
+	null := buildTar(t, deviceEntry("dev/null", tar.TypeChar, 1, 3))
+	zero := buildTar(t, deviceEntry("dev/null", tar.TypeChar, 1, 5))
+	block := buildTar(t, deviceEntry("dev/null", tar.TypeBlock, 1, 3))
+
+	for _, v := range []Version{Version0, Version1, VersionDev} {
+		if sumArchive(t, null, v) == sumArchive(t, zero, v) {
+			t.Errorf("%s: expected sums to differ for differing device numbers", v)
+		}
+		if sumArchive(t, null, v) == sumArchive(t, block, v) {
+			t.Errorf("%s: expected sums to differ for char and block devices", v)
+		}
+	}
+
+	// Device entries have no body, so the per-file sum covers only the
+	// selected headers.
+	sums, err := ComputeFileSums(bytes.NewReader(null), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	for _, elem := range []string{
+		"name", "dev/null", "mode", "384", "uid", "0", "gid", "0", "size", "0",
+		"typeflag", "3", "linkname", "", "uname", "", "gname", "",
+		"devmajor", "1", "devminor", "3",
+	} {
+		h.Write([]byte(elem))
+	}
+	if expected := hex.EncodeToString(h.Sum(nil)); len(sums) != 1 || sums[0].Sum() != expected {
+		t.Errorf("Mismatched device sum\n\tActual: %v\n\tExpected: %s", sums, expected)
+	}
+}
+
+func TestVersionCleanLinks(t *testing.T) {
+	link := func(target string) []byte {
+		return buildTar(t, testEntry{header: &tar.Header{
+			Name:     "foo",
+			Typeflag: tar.TypeSymlink,
+			Linkname: target,
+			Mode:     0777,
+			ModTime:  time.Unix(1400000000, 0),
+		}})
+	}
+	plain, dotted, doubled := link("bar"), link("./bar"), link("dir//../bar")
+
+	for _, archive := range [][]byte{dotted, doubled} {
+		if sumArchive(t, archive, VersionCleanLinks) != sumArchive(t, plain, VersionCleanLinks) {
+			t.Errorf("%s: expected equivalent symlink targets to hash identically", VersionCleanLinks)
+		}
+		if sumArchive(t, archive, Version1) == sumArchive(t, plain, Version1) {
+			t.Errorf("%s: expected symlink targets to be hashed as is", Version1)
+		}
+	}
+	if sumArchive(t, link("baz"), VersionCleanLinks) == sumArchive(t, plain, VersionCleanLinks) {
+		t.Errorf("%s: expected distinct symlink targets to hash differently", VersionCleanLinks)
+	}
+}
+
+func TestVersionWhiteout(t *testing.T) {
+	dir := testEntry{header: &tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Unix(1400000000, 0)}}
+	aufs := buildTar(t, dir, testEntry{header: &tar.Header{
+		Name:     "etc/.wh.passwd",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		ModTime:  time.Unix(1400000001, 0),
+	}})
+	overlay := buildTar(t, dir, deviceEntry("etc/passwd", tar.TypeChar, 0, 0))
+
+	if sumArchive(t, aufs, VersionWhiteout) != sumArchive(t, overlay, VersionWhiteout) {
+		t.Errorf("%s: expected both whiteout styles to hash identically", VersionWhiteout)
+	}
+	if sumArchive(t, aufs, Version1) == sumArchive(t, overlay, Version1) {
+		t.Errorf("%s: expected whiteout styles to hash differently", Version1)
+	}
+	other := buildTar(t, dir, deviceEntry("etc/shadow", tar.TypeChar, 0, 0))
+	if sumArchive(t, other, VersionWhiteout) == sumArchive(t, overlay, VersionWhiteout) {
+		t.Errorf("%s: expected whiteouts of different paths to hash differently", VersionWhiteout)
+	}
+
+	// Entries which are not whiteouts, including aufs metadata, are hashed
+	// as for Version1.
+	plain := buildTar(t, dir, regEntry("etc/.wh..wh..opq", ""), deviceEntry("dev/null", tar.TypeChar, 1, 3))
+	if strings.TrimPrefix(sumArchive(t, plain, VersionWhiteout), VersionWhiteout.String()) != strings.TrimPrefix(sumArchive(t, plain, Version1), Version1.String()) {
+		t.Errorf("%s: expected entries other than whiteouts to hash as for %s", VersionWhiteout, Version1)
+	}
+}
+
+func TestPAXOverrides(t *testing.T) {
+	reference := buildTar(t, regEntry("file", "hello"))
+
+	// A PAX size record takes precedence over the size in the header
+	// block, which is zeroed here.
+	sized := buildTar(t, paxEntry(map[string]string{"size": "5"}), regEntry("file", "hello"))
+	sized = setHeaderSize(sized, 1024, 0)
+	if sum, expected := sumArchive(t, sized, Version1), sumArchive(t, reference, Version1); sum != expected {
+		t.Errorf("Mismatched sum with PAX size\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	// Sizes beyond the 8GB limit of the header block are only available
+	// through PAX; the entry data itself is not needed to check the header.
+	huge := buildTar(t, paxEntry(map[string]string{"size": "9000000000"}), regEntry("big", ""))
+	hdr, err := tar.NewReader(bytes.NewReader(huge)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Size != 9000000000 {
+		t.Errorf("Mismatched PAX size\n\tActual: %d\n\tExpected: %d", hdr.Size, int64(9000000000))
+	}
+
+	longName := strings.Repeat("d/", 100) + strings.Repeat("f", 100)
+	long := buildTar(t, paxEntry(map[string]string{"path": longName}), regEntry("short", "data"))
+	sums, err := ComputeFileSums(bytes.NewReader(long), Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums[0].Name() != longName {
+		t.Errorf("expected the PAX path to name the file, got %v", sums)
+	}
+	if sum, expected := sumArchive(t, long, Version1), sumArchive(t, buildTar(t, regEntry(longName, "data")), Version1); sum != expected {
+		t.Errorf("Mismatched sum with PAX path\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	invalid := buildTar(t, paxEntry(map[string]string{"uid": "bogus"}), regEntry("file", "data"))
+	if _, err := ComputeFileSums(bytes.NewReader(invalid), Version1); err == nil {
+		t.Error("expected an error for an invalid PAX record")
+	}
+}
+
+func TestStrictHeaders(t *testing.T) {
+	archive := buildTar(t, regEntry("file", "data"))
+
+	corrupt := append([]byte(nil), archive...)
+	corrupt[0] ^= 0x01 // flip a bit in the name without fixing the checksum
+
+	// Some historic tars computed the checksum over signed bytes, which
+	// differs once a header contains a byte above 0x7f.
+	signed := append([]byte(nil), archive...)
+	header := signed[:512]
+	header[500] = 0x80
+	copy(header[148:156], "        ")
+	var chksum int64
+	for _, c := range header {
+		chksum += int64(int8(c))
+	}
+	copy(header[148:156], fmt.Sprintf("%06o\x00 ", chksum))
+
+	testCases := []struct {
+		name    string
+		archive []byte
+		strict  bool
+		err     error
+	}{
+		{"valid", archive, false, nil},
+		{"valid strict", archive, true, nil},
+		{"corrupt", corrupt, false, tar.ErrHeader},
+		{"corrupt strict", corrupt, true, ErrHeaderChecksum},
+		{"signed", signed, false, nil},
+		{"signed strict", signed, true, ErrHeaderChecksum},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(testCase.archive), Version1, Options{StrictHeaders: testCase.strict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(ts); err != testCase.err {
+			t.Errorf("Mismatched error for %s\n\tActual: %v\n\tExpected: %v", testCase.name, err, testCase.err)
+		}
+	}
+}
+
+func TestVersionSeparated(t *testing.T) {
+	// Resumed sums stand in for per-file digests of different lengths:
+	// both pairs concatenate to the same bytes.
+	archive := buildTar(t, regEntry("a", "one"), regEntry("b", "two"))
+	split := func(v Version, first, second string) string {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), v, Options{
+			SkipFirstN: 2,
+			ResumeSums: FileInfoSums{
+				fileInfoSum{name: "a", sum: first, pos: 0},
+				fileInfoSum{name: "b", sum: second, pos: 1},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAllSizes(t, ts, buf32K)
+		return strings.TrimPrefix(ts.Sum(nil), v.String())
+	}
+
+	if split(Version1, "0a", "0bc0") != split(Version1, "0a0b", "c0") {
+		t.Fatalf("expected %s to aggregate both splits identically", Version1)
+	}
+	if split(VersionSeparated, "0a", "0bc0") == split(VersionSeparated, "0a0b", "c0") {
+		t.Errorf("%s: expected sums of different lengths to be separated", VersionSeparated)
+	}
+
+	if strings.TrimPrefix(sumArchive(t, archive, VersionSeparated), VersionSeparated.String()) == strings.TrimPrefix(sumArchive(t, archive, Version1), Version1.String()) {
+		t.Errorf("%s must not be comparable with %s", VersionSeparated, Version1)
+	}
+}
+
+// setBase256 encodes x into a numeric header field using the GNU base-256
+// encoding.
+func setBase256(field []byte, x int64) {
+	for i := len(field) - 1; i >= 0; i-- {
+		field[i] = byte(x)
+		x >>= 8
+	}
+	field[0] |= 0x80
+}
+
+func TestBase256Numbers(t *testing.T) {
+	mtime := time.Unix(1400000000, 0)
+	archive := buildTar(t, testEntry{
+		header: &tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, Uid: 1000, Gid: 2000, Size: 4, ModTime: mtime},
+		data:   []byte("data"),
+	})
+
+	// Re-encoding every number in base-256 does not change the sum.
+	encoded := append([]byte(nil), archive...)
+	header := encoded[:512]
+	setBase256(header[108:116], 1000)
+	setBase256(header[116:124], 2000)
+	setBase256(header[124:136], 4)
+	setBase256(header[136:148], mtime.Unix())
+	fixHeaderChecksum(header)
+	if sum, expected := sumArchive(t, encoded, Version1), sumArchive(t, archive, Version1); sum != expected {
+		t.Errorf("Mismatched sum of base-256 header\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	// A uid which does not fit in an int is rejected rather than
+	// truncated.
+	var largeIntErr error
+	if strconv.IntSize == 32 {
+		largeIntErr = tar.ErrHeader
+	}
+	testCases := []struct {
+		name  string
+		field [2]int // the extent of the field within the header
+		value int64
+		err   error
+		check func(hdr *tar.Header) bool
+	}{
+		{"large size", [2]int{124, 136}, 10 << 30, nil, func(hdr *tar.Header) bool { return hdr.Size == 10<<30 }},
+		{"large uid", [2]int{108, 116}, 1 << 40, largeIntErr, func(hdr *tar.Header) bool { return int64(hdr.Uid) == 1<<40 }},
+		{"negative mtime", [2]int{136, 148}, -1000, nil, func(hdr *tar.Header) bool { return hdr.ModTime.Unix() == -1000 }},
+		{"negative size", [2]int{124, 136}, -1, tar.ErrHeader, nil},
+	}
+	for _, testCase := range testCases {
+		crafted := append([]byte(nil), archive...)
+		header := crafted[:512]
+		setBase256(header[testCase.field[0]:testCase.field[1]], testCase.value)
+		fixHeaderChecksum(header)
+
+		hdr, err := tar.NewReader(bytes.NewReader(crafted)).Next()
+		if err != testCase.err {
+			t.Errorf("%s: Mismatched error\n\tActual: %v\n\tExpected: %v", testCase.name, err, testCase.err)
+			continue
+		}
+		if err == nil && testCase.check != nil && !testCase.check(hdr) {
+			t.Errorf("%s: unexpected header %+v", testCase.name, hdr)
+		}
+	}
+
+	// Numbers beyond the range of int64 are rejected.
+	overflow := append([]byte(nil), archive...)
+	header = overflow[:512]
+	copy(header[124:136], []byte{0x80, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	fixHeaderChecksum(header)
+	if _, err := tar.NewReader(bytes.NewReader(overflow)).Next(); err != tar.ErrHeader {
+		t.Errorf("Mismatched error for overflow\n\tActual: %v\n\tExpected: %v", err, tar.ErrHeader)
+	}
+}
+
+func globalEntry(records ...string) testEntry {
+	data := strings.Join(records, "")
+	return testEntry{
+		header: &tar.Header{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, Size: int64(len(data)), ModTime: time.Unix(1400000000, 0)},
+		data:   []byte(data),
+	}
+}
+
+func TestVersionGlobalPAX(t *testing.T) {
+	file := regEntry("file", "data")
+	digest := func(archive []byte, v Version) string {
+		return strings.TrimPrefix(sumArchive(t, archive, v), v.String())
+	}
+
+	one := buildTar(t, globalEntry(paxRecord("comment", "one"), paxRecord("vendor", "acme")), file)
+	two := buildTar(t, globalEntry(paxRecord("comment", "two"), paxRecord("vendor", "acme")), file)
+	reordered := buildTar(t, globalEntry(paxRecord("vendor", "acme")), file, globalEntry(paxRecord("comment", "two")))
+	overridden := buildTar(t, globalEntry(paxRecord("comment", "one"), paxRecord("vendor", "acme")), globalEntry(paxRecord("comment", "two")), file)
+	plain := buildTar(t, file)
+
+	if sumArchive(t, one, VersionGlobalPAX) == sumArchive(t, two, VersionGlobalPAX) {
+		t.Errorf("%s: expected archives differing in a global comment to differ", VersionGlobalPAX)
+	}
+	if sumArchive(t, reordered, VersionGlobalPAX) != sumArchive(t, two, VersionGlobalPAX) {
+		t.Errorf("%s: expected the placement of global records not to matter", VersionGlobalPAX)
+	}
+	if sumArchive(t, overridden, VersionGlobalPAX) != sumArchive(t, two, VersionGlobalPAX) {
+		t.Errorf("%s: expected later global records to override earlier ones", VersionGlobalPAX)
+	}
+	if sumArchive(t, reordered, Version1) == sumArchive(t, two, Version1) {
+		t.Errorf("%s: expected global headers to be summed as files", Version1)
+	}
+	if digest(plain, VersionGlobalPAX) != digest(plain, Version1) {
+		t.Errorf("%s: expected an archive without global headers to hash as for %s", VersionGlobalPAX, Version1)
+	}
+	if digest(one, VersionGlobalPAX) == digest(plain, Version1) {
+		t.Errorf("%s must not be comparable with %s", VersionGlobalPAX, Version1)
+	}
+
+	sums, err := ComputeFileSums(bytes.NewReader(one), VersionGlobalPAX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sums) != 1 || sums[0].Name() != "file" {
+		t.Errorf("%s: expected only file to be summed, got %v", VersionGlobalPAX, sums)
+	}
+
+	malformed := buildTar(t, globalEntry("99 comment=one\n"), file)
+	if _, err := ComputeFileSums(bytes.NewReader(malformed), VersionGlobalPAX); err == nil {
+		t.Errorf("%s: expected an error for malformed global records", VersionGlobalPAX)
+	}
+}
+
+func TestVersionNoMode(t *testing.T) {
+	withMode := func(name string, mode int64) []byte {
+		e := regEntry(name, "content")
+		e.header.Mode = mode
+		return buildTar(t, e)
+	}
+	plain, chmodded, setuid := withMode("foo", 0644), withMode("foo", 0755), withMode("foo", 04755)
+
+	for _, archive := range [][]byte{chmodded, setuid} {
+		if sumArchive(t, archive, VersionNoMode) != sumArchive(t, plain, VersionNoMode) {
+			t.Errorf("%s: expected archives differing only in mode to hash identically", VersionNoMode)
+		}
+		if sumArchive(t, archive, Version1) == sumArchive(t, plain, Version1) {
+			t.Errorf("%s: expected archives differing in mode to hash differently", Version1)
+		}
+	}
+	if sumArchive(t, withMode("bar", 0644), VersionNoMode) == sumArchive(t, plain, VersionNoMode) {
+		t.Errorf("%s: expected distinct names to hash differently", VersionNoMode)
+	}
+	changed := regEntry("foo", "changed")
+	if sumArchive(t, buildTar(t, changed), VersionNoMode) == sumArchive(t, plain, VersionNoMode) {
+		t.Errorf("%s: expected distinct content to hash differently", VersionNoMode)
+	}
+}
+
+// padEntries builds an archive whose entries' data is padded to a multiple
+// of blockSize rather than of 512 bytes.
+func padEntries(t *testing.T, blockSize int, entries ...testEntry) []byte {
+	var archive []byte
+	for _, e := range entries {
+		buf := new(bytes.Buffer)
+		tw := tar.NewWriter(buf)
+		if err := tw.WriteHeader(e.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		b := buf.Bytes()
+		data := b[512:]
+		data = append(data, make([]byte, (blockSize-len(data)%blockSize)%blockSize)...)
+		archive = append(archive, b[:512]...)
+		archive = append(archive, data...)
+	}
+	return append(archive, make([]byte, 2*blockSize)...)
+}
+
+func TestBlockSizeDetection(t *testing.T) {
+	entries := []testEntry{regEntry("a", "one"), regEntry("b", "two")}
+
+	testCases := []struct {
+		blockSize int
+		opts      Options
+		err       error
+	}{
+		{512, Options{RejectTrailingData: true}, nil},
+		{1024, Options{}, ErrBlockSize},
+		{1024, Options{RejectTrailingData: true}, ErrBlockSize},
+		{4096, Options{RejectTrailingData: true}, ErrBlockSize},
+	}
+	for _, testCase := range testCases {
+		archive := padEntries(t, testCase.blockSize, entries...)
+		ts, err := NewTarSumWithOptions(bytes.NewReader(archive), Version1, testCase.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(ts); err != testCase.err {
+			t.Errorf("Mismatched error for %d byte blocks\n\tActual: %v\n\tExpected: %v", testCase.blockSize, err, testCase.err)
+		}
+	}
+	if sum, expected := sumArchive(t, padEntries(t, 512, entries...), Version1), sumArchive(t, buildTar(t, entries...), Version1); sum != expected {
+		t.Errorf("Mismatched sum for 512 byte blocks\n\tActual: %s\n\tExpected: %s", sum, expected)
+	}
+
+	// Trailing garbage which is not a header is still reported as such.
+	garbage := append(buildTar(t, entries...), bytes.Repeat([]byte{'x'}, 600)...)
+	ts, err := NewTarSumWithOptions(bytes.NewReader(garbage), Version1, Options{RejectTrailingData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(ts); err != ErrTrailingData {
+		t.Errorf("Mismatched error for trailing garbage\n\tActual: %v\n\tExpected: %v", err, ErrTrailingData)
+	}
+}
+
+func TestVersionHardened(t *testing.T) {
+	withOwners := func(uname, gname string) []byte {
+		e := regEntry("foo", "content")
+		e.header.Uname, e.header.Gname = uname, gname
+		return buildTar(t, e)
+	}
+	withXattr := func(key, value string) []byte {
+		e := regEntry("foo", "content")
+		e.header.Xattrs = map[string]string{key: value}
+		return buildTar(t, e)
+	}
+	testCases := []struct {
+		name string
+		a, b []byte
+	}{
+		// "uname" "xgname" "gname" "" and "uname" "x" "gname" "gname"
+		// concatenate identically.
+		{"owners", withOwners("xgname", ""), withOwners("x", "gname")},
+		{"xattrs", withXattr("user.ab", "c"), withXattr("user.a", "bc")},
+	}
+	for _, testCase := range testCases {
+		if sumArchive(t, testCase.a, Version1) != sumArchive(t, testCase.b, Version1) {
+			t.Fatalf("%s: expected the archives to collide under %s", testCase.name, Version1)
+		}
+		if sumArchive(t, testCase.a, VersionHardened) == sumArchive(t, testCase.b, VersionHardened) {
+			t.Errorf("%s: expected the archives to differ under %s", testCase.name, VersionHardened)
+		}
+	}
+
+	// The same bytes under a header declaring a different size.
+	file := buildTar(t, regEntry("file", "abc"))
+	if sumArchive(t, file, VersionHardened) == sumArchive(t, setHeaderSize(file, 0, 4), VersionHardened) {
+		t.Errorf("%s: expected sums to differ when declared sizes differ", VersionHardened)
+	}
+
+	// Every way of summing agrees.
+	archive := buildTar(t, regEntry("foo", "content"), regEntry("bar", "more content"))
+	sum := sumArchive(t, archive, VersionHardened)
+	if sum == strings.Replace(sumArchive(t, archive, Version1), Version1.String(), VersionHardened.String(), 1) {
+		t.Errorf("%s must not be comparable with %s", VersionHardened, Version1)
+	}
+	parallel, _, err := SumParallel(bytes.NewReader(archive), VersionHardened, ParallelOptions{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parallel != sum {
+		t.Errorf("Mismatched parallel checksum\n\tActual: %s\n\tExpected: %s", parallel, sum)
+	}
+	d, err := NewDigest(VersionHardened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Write(archive); err != nil {
+		t.Fatal(err)
+	}
+	if digest := d.SumString(nil); digest != sum {
+		t.Errorf("Mismatched digest checksum\n\tActual: %s\n\tExpected: %s", digest, sum)
+	}
+}
@@ -1,10 +1,15 @@
 package tarsum
 
 import (
+	"fmt"
 	"io"
 )
 
-type writeCloseFlusher interface {
+// CompressWriter compresses the re-encoded tar stream for
+// Options.Compressor. Flush writes any buffered data so that the output
+// read so far can be decompressed, as gzip.Writer.Flush does, and Close
+// writes the end of the compressed stream.
+type CompressWriter interface {
 	io.WriteCloser
 	Flush() error
 }
@@ -20,3 +25,15 @@ func (n *nopCloseFlusher) Close() error {
 func (n *nopCloseFlusher) Flush() error {
 	return nil
 }
+
+// ErrReemit is returned by Read when an entry cannot be re-encoded, or when
+// the re-encoded tar stream cannot be written, flushed or closed. Op
+// describes the failed step and Err holds the underlying error.
+type ErrReemit struct {
+	Op  string
+	Err error
+}
+
+func (e ErrReemit) Error() string {
+	return fmt.Sprintf("tarsum: re-emit failed to %s: %v", e.Op, e.Err)
+}
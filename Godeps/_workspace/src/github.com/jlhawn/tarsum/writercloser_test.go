@@ -0,0 +1,123 @@
+package tarsum
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+var errInjected = errors.New("injected failure")
+
+// faultyWriter is a CompressWriter which fails at a chosen point.
+type faultyWriter struct {
+	w            io.Writer
+	failAfter    int  // fail writes once this many bytes have been written; -1 never
+	shortWrite   bool // report a short write rather than an error
+	failOnFlush  bool
+	failOnClose  bool
+	bytesWritten int
+}
+
+func (fw *faultyWriter) Write(p []byte) (int, error) {
+	if fw.failAfter >= 0 && fw.bytesWritten+len(p) > fw.failAfter {
+		n := fw.failAfter - fw.bytesWritten
+		fw.w.Write(p[:n])
+		fw.bytesWritten += n
+		if fw.shortWrite {
+			return n, nil
+		}
+		return n, errInjected
+	}
+	n, err := fw.w.Write(p)
+	fw.bytesWritten += n
+	return n, err
+}
+
+func (fw *faultyWriter) Flush() error {
+	if fw.failOnFlush {
+		return errInjected
+	}
+	return nil
+}
+
+func (fw *faultyWriter) Close() error {
+	if fw.failOnClose {
+		return errInjected
+	}
+	return nil
+}
+
+func TestReemitWriterErrors(t *testing.T) {
+	archive := buildTar(t,
+		regEntry("a", strings.Repeat("a", 3000)),
+		regEntry("b", strings.Repeat("b", 3000)),
+	)
+
+	testCases := []struct {
+		name   string
+		writer *faultyWriter
+		op     string
+		err    error
+	}{
+		{"header", &faultyWriter{failAfter: 100}, "write output", errInjected},
+		{"mid-file", &faultyWriter{failAfter: 2000}, "write output", errInjected},
+		{"second file", &faultyWriter{failAfter: 4000}, "write output", errInjected},
+		{"short write", &faultyWriter{failAfter: 2000, shortWrite: true}, "write output", io.ErrShortWrite},
+		{"flush", &faultyWriter{failAfter: -1, failOnFlush: true}, "flush output", errInjected},
+		{"close", &faultyWriter{failAfter: -1, failOnClose: true}, "close output", errInjected},
+	}
+
+	for _, testCase := range testCases {
+		ts, err := newTarSum(bytes.NewReader(archive), true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCase.writer.w = ts.bufWriter
+		ts.writer = testCase.writer
+
+		_, err = io.Copy(ioutil.Discard, ts)
+		reemitErr, ok := err.(ErrReemit)
+		if !ok {
+			t.Errorf("%s: expected an ErrReemit, got %v", testCase.name, err)
+			continue
+		}
+		if reemitErr.Op != testCase.op || reemitErr.Err != testCase.err {
+			t.Errorf("Mismatched error for %s\n\tActual: %v\n\tExpected: %s: %v", testCase.name, reemitErr, testCase.op, testCase.err)
+		}
+	}
+
+	// Failures of the tar writer itself, re-encoding an entry.
+	tarTestCases := []struct {
+		name   string
+		writer *faultyWriter
+		op     string
+	}{
+		{"tar header", &faultyWriter{failAfter: 100}, "write header"},
+		{"tar mid-file", &faultyWriter{failAfter: 2000}, "write entry"},
+		{"tar second file", &faultyWriter{failAfter: 4000}, "write header"},
+		{"tar second file data", &faultyWriter{failAfter: 5000}, "write entry"},
+	}
+	for _, testCase := range tarTestCases {
+		ts, err := newTarSum(bytes.NewReader(archive), true, Version1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCase.writer.w = ts.bufTar
+		ts.tarW = tar.NewWriter(testCase.writer)
+
+		_, err = io.Copy(ioutil.Discard, ts)
+		reemitErr, ok := err.(ErrReemit)
+		if !ok {
+			t.Errorf("%s: expected an ErrReemit, got %v", testCase.name, err)
+			continue
+		}
+		if reemitErr.Op != testCase.op || reemitErr.Err != errInjected {
+			t.Errorf("Mismatched error for %s\n\tActual: %v\n\tExpected: %s: %v", testCase.name, reemitErr, testCase.op, errInjected)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package tarsum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jlhawn/tarsum/archive/tar"
+)
+
+// The fixture is a zstd frame of raw and RLE blocks, which the zstd
+// reference decoder accepts, so that it can be decoded without a zstd
+// implementation.
+const (
+	zstdFixture    = "testdata/layer.tar.zst"
+	zstdFixtureSum = "tarsum.v1+sha256:7839d7c0c3c5dd2392e1437d382b8f6f1f98917b946fd2d05a1ac73a5d36c205"
+)
+
+const (
+	zstdBlockRaw = 0
+	zstdBlockRLE = 1
+
+	zstdMaxBlock = 128 << 10
+)
+
+var errZstdUnsupported = errors.New("zstd frame uses an unsupported feature")
+
+// newZstdReader decompresses zstd frames made up of raw and RLE blocks, as
+// the test encoder writes them, standing in for a zstd implementation.
+func newZstdReader(r io.Reader) (io.Reader, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(decodeZstd(pw, bufio.NewReader(r)))
+	}()
+	return pr, nil
+}
+
+func decodeZstd(w io.Writer, r *bufio.Reader) error {
+	for {
+		magic := make([]byte, len(ZstdMagic))
+		if _, err := io.ReadFull(r, magic); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !bytes.Equal(magic, ZstdMagic) {
+			return errors.New("not a zstd frame")
+		}
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		// Only a window descriptor is supported, with an optional
+		// content checksum.
+		if header[0]&^0x04 != 0 {
+			return errZstdUnsupported
+		}
+		for last := false; !last; {
+			var block [4]byte
+			if _, err := io.ReadFull(r, block[:3]); err != nil {
+				return err
+			}
+			h := binary.LittleEndian.Uint32(block[:])
+			last = h&1 == 1
+			size := int64(h >> 3)
+			switch (h >> 1) & 3 {
+			case zstdBlockRaw:
+				if _, err := io.CopyN(w, r, size); err != nil {
+					return err
+				}
+			case zstdBlockRLE:
+				b, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(bytes.Repeat([]byte{b}, int(size))); err != nil {
+					return err
+				}
+			default:
+				return errZstdUnsupported
+			}
+		}
+		if header[0]&0x04 != 0 {
+			if _, err := io.CopyN(ioutil.Discard, r, 4); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// zstdWriter compresses as a single zstd frame of raw blocks, standing in
+// for a zstd encoder.
+type zstdWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func newZstdWriter(w io.Writer) (CompressWriter, error) {
+	return &zstdWriter{w: w}, nil
+}
+
+func (z *zstdWriter) block(p []byte, last bool) error {
+	if !z.started {
+		z.started = true
+		// A 128 KiB window.
+		header := append(append([]byte(nil), ZstdMagic...), 0, 7<<3)
+		if _, err := z.w.Write(header); err != nil {
+			return err
+		}
+	}
+	h := uint32(len(p))<<3 | zstdBlockRaw<<1
+	if last {
+		h |= 1
+	}
+	var block [4]byte
+	binary.LittleEndian.PutUint32(block[:], h)
+	if _, err := z.w.Write(block[:3]); err != nil {
+		return err
+	}
+	_, err := z.w.Write(p)
+	return err
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	for n := 0; n < len(p); n += zstdMaxBlock {
+		end := n + zstdMaxBlock
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := z.block(p[n:end], false); err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op since every write is written as whole blocks.
+func (z *zstdWriter) Flush() error {
+	return nil
+}
+
+func (z *zstdWriter) Close() error {
+	return z.block(nil, true)
+}
+
+func TestZstdLayer(t *testing.T) {
+	layer, err := ioutil.ReadFile(zstdFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := newZstdReader(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sumArchive(t, archive, Version1); sum != zstdFixtureSum {
+		t.Fatalf("Mismatched sum of the decompressed fixture\n\tActual: %s\n\tExpected: %s", sum, zstdFixtureSum)
+	}
+
+	zstd := Decompressor{Magic: ZstdMagic, NewReader: newZstdReader}
+	testCases := []struct {
+		opts       Options
+		compressed bool
+	}{
+		{Options{AutoDecompress: true, Decompressors: []Decompressor{zstd}, DisableCompression: true}, false},
+		{Options{AutoDecompress: true, Decompressors: []Decompressor{zstd}, Compressor: newZstdWriter}, true},
+	}
+	for _, testCase := range testCases {
+		ts, err := NewTarSumWithOptions(bytes.NewReader(layer), Version1, testCase.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := readAllSizes(t, ts, buf32K)
+		if sum := ts.Sum(nil); sum != zstdFixtureSum {
+			t.Errorf("Mismatched sum of the zstd layer\n\tActual: %s\n\tExpected: %s", sum, zstdFixtureSum)
+		}
+
+		var reemitted io.Reader = bytes.NewReader(out)
+		if testCase.compressed {
+			if !bytes.HasPrefix(out, ZstdMagic) {
+				t.Fatal("expected the output to be zstd compressed")
+			}
+			if reemitted, err = newZstdReader(reemitted); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tr := tar.NewReader(reemitted)
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+		if len(names) != 6 {
+			t.Errorf("Mismatched re-emitted entries\n\tActual: %v", names)
+		}
+	}
+
+	// Gzip is still detected alongside other decompressors.
+	ts, err := NewTarSumWithOptions(bytes.NewReader(gzipBytes(t, archive)), Version1, testCases[0].opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAllSizes(t, ts, buf32K)
+	if sum := ts.Sum(nil); sum != zstdFixtureSum {
+		t.Errorf("Mismatched sum of the gzip layer\n\tActual: %s\n\tExpected: %s", sum, zstdFixtureSum)
+	}
+}